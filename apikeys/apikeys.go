@@ -0,0 +1,215 @@
+// Package apikeys manages the API keys automation uses instead of OIDC
+// tokens. Keys are stored as SHA-256 hashes, optionally in a file so they
+// survive restarts.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// Prefix starts every API key, which tells them apart from JWTs.
+const Prefix = "anyvm_"
+
+// lastUsedInterval is how often last-used timestamps alone cause the file to
+// be written, so using a key doesn't mean a write per request.
+const lastUsedInterval = time.Minute
+
+var (
+	ErrNotFound   = errors.New("API key not found")
+	ErrInvalidKey = errors.New("invalid API key")
+)
+
+// record is a key as stored: its metadata and the hash of its secret.
+type record struct {
+	models.APIKey
+	Hash string `json:"hash"` // hex SHA-256 of the secret
+}
+
+// Store keeps the API keys in memory and, if it has a path, in a JSON file.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	keys  map[string]*record // by ID
+	saved time.Time          // last write of the file
+}
+
+// Open loads the keys stored at path, which needn't exist yet. With an empty
+// path keys are only kept in memory.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, keys: make(map[string]*record)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, r := range records {
+		s.keys[r.ID] = r
+	}
+	return s, nil
+}
+
+// Create adds a key and returns it with the key itself, which can't be
+// retrieved later.
+func (s *Store) Create(name, createdBy string, scopes []models.APIKeyScope, expiresAt *time.Time) (models.APIKey, error) {
+	id, secret := make([]byte, 8), make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return models.APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return models.APIKey{}, err
+	}
+	r := &record{
+		APIKey: models.APIKey{
+			ID:        hex.EncodeToString(id),
+			Name:      name,
+			CreatedBy: createdBy,
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt,
+		},
+		Hash: hash(secret),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[r.ID] = r
+	if err := s.save(); err != nil {
+		delete(s.keys, r.ID)
+		return models.APIKey{}, err
+	}
+	key := r.APIKey
+	key.Key = Prefix + r.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, nil
+}
+
+// List returns every key, including expired and revoked ones, oldest first.
+func (s *Store) List() []models.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]models.APIKey, 0, len(s.keys))
+	for _, r := range s.keys {
+		keys = append(keys, r.APIKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Revoke makes a key unusable. Revoked keys are kept so they can still be
+// listed.
+func (s *Store) Revoke(id string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.keys[id]
+	if !ok {
+		return models.APIKey{}, ErrNotFound
+	}
+	if r.RevokedAt == nil {
+		now := time.Now().UTC()
+		r.RevokedAt = &now
+		if err := s.save(); err != nil {
+			r.RevokedAt = nil
+			return models.APIKey{}, err
+		}
+	}
+	return r.APIKey, nil
+}
+
+// Authenticate returns the key with the given value if it is valid, and
+// records that it was used.
+func (s *Store) Authenticate(key string) (models.APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, Prefix), "_")
+	raw, err := base64.RawURLEncoding.DecodeString(secret)
+	if !ok || !strings.HasPrefix(key, Prefix) || err != nil {
+		return models.APIKey{}, ErrInvalidKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(hash(raw)), []byte(r.Hash)) != 1 {
+		return models.APIKey{}, ErrInvalidKey
+	}
+	now := time.Now().UTC()
+	switch {
+	case r.RevokedAt != nil:
+		return models.APIKey{}, errors.New("API key is revoked")
+	case r.ExpiresAt != nil && !now.Before(*r.ExpiresAt):
+		return models.APIKey{}, errors.New("API key is expired")
+	}
+	r.LastUsedAt = &now
+	if now.Sub(s.saved) >= lastUsedInterval {
+		if err := s.save(); err != nil {
+			fmt.Printf("Saving the last use of API key %s failed. Will continue without it. Error: %v\r\n", id, err)
+		}
+	}
+	return r.APIKey, nil
+}
+
+// save writes every key to the file, replacing it atomically. The caller
+// holds s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	records := make([]*record, 0, len(s.keys))
+	for _, r := range s.keys {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.saved = time.Now()
+	return nil
+}
+
+func hash(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,78 @@
+package apikeys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := []models.APIKeyScope{{Role: "operator", Providers: []string{"proxmox"}}}
+	ci, err := s.Create("ci", "u1", scopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	old, err := s.Create("old", "u1", scopes, &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ci.Key, Prefix) {
+		t.Fatalf("key %q", ci.Key)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := ci.Key[strings.LastIndex(ci.Key, "_")+1:]
+	if strings.Contains(string(data), secret) {
+		t.Error("the key is stored in plain text")
+	}
+
+	// Keys work after the store is opened again.
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.Authenticate(ci.Key)
+	if err != nil || key.ID != ci.ID || key.LastUsedAt == nil || key.Key != "" {
+		t.Errorf("Authenticate: %+v, %v", key, err)
+	}
+	for _, k := range []string{ci.Key + "x", ci.Key[:len(ci.Key)-2], "anyvm_" + ci.ID, "anyvm_nope_" + secret} {
+		if _, err := s.Authenticate(k); err != ErrInvalidKey {
+			t.Errorf("Authenticate(%q): %v", k, err)
+		}
+	}
+	if _, err := s.Authenticate(old.Key); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired key: %v", err)
+	}
+
+	if _, err := s.Revoke(ci.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ci.Key); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("revoked key: %v", err)
+	}
+	if _, err := s.Revoke("nope"); err != ErrNotFound {
+		t.Errorf("Revoke of an unknown key: %v", err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := s.List()
+	if len(keys) != 2 || keys[0].ID != ci.ID || keys[0].RevokedAt == nil || keys[0].LastUsedAt == nil {
+		t.Errorf("List after reopening: %+v", keys)
+	}
+}
@@ -0,0 +1,206 @@
+// Package audit keeps an append-only log of the requests that change VMs,
+// tags or API keys and of the outcome of the operations they start. Entries
+// are hash-chained: each carries the SHA-256 hash of the one before it, so
+// editing, inserting or removing entries is detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// Log appends entries to a file of JSON lines, or keeps them in memory if it
+// has no path.
+type Log struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	entries []models.AuditEntry // without path only
+	seq     int64               // of the last entry
+	last    string              // hash of the last entry
+}
+
+// Open opens the log at path, creating it if needed, and continues its chain.
+// A broken chain is reported but doesn't prevent new entries from being
+// written. With an empty path entries are only kept in memory.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	if path == "" {
+		return l, nil
+	}
+	v := l.verify(func(e models.AuditEntry) {
+		l.seq, l.last = e.Seq, e.Hash
+	})
+	if v.err != nil && !errors.Is(v.err, os.ErrNotExist) {
+		return nil, v.err
+	}
+	if !v.Valid && v.err == nil {
+		fmt.Printf("The audit log %s is not intact: %s. New entries continue the chain from its last entry.\r\n", path, v.Error)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+// Append adds e to the log, filling in its sequence number, time if unset
+// and hashes.
+func (l *Log) Append(e models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	e.Seq = l.seq + 1
+	e.PrevHash = l.last
+	hash, err := entryHash(e)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	if l.file == nil {
+		l.entries = append(l.entries, e)
+	} else {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+	}
+	l.seq, l.last = e.Seq, e.Hash
+	return nil
+}
+
+// Query returns the newest entries matching filter, at most limit of them,
+// newest first.
+func (l *Log) Query(filter models.AuditFilter, limit int) ([]models.AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var matching []models.AuditEntry
+	err := l.each(func(e models.AuditEntry, err error) {
+		if err == nil && filter.Match(e) {
+			matching = append(matching, e)
+			if len(matching) > limit {
+				matching = matching[1:]
+			}
+		}
+	})
+	for i, j := 0, len(matching)-1; i < j; i, j = i+1, j-1 {
+		matching[i], matching[j] = matching[j], matching[i]
+	}
+	return matching, err
+}
+
+// Verify checks the hash chain of every entry.
+func (l *Log) Verify() models.AuditVerification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.verify(nil).AuditVerification
+}
+
+type verification struct {
+	models.AuditVerification
+	err error // reading the log failed
+}
+
+// verify checks the chain, calling fn for every entry. The caller holds l.mu
+// unless the log is still being opened.
+func (l *Log) verify(fn func(models.AuditEntry)) verification {
+	var v verification
+	var prev models.AuditEntry
+	v.err = l.each(func(e models.AuditEntry, err error) {
+		v.Entries++
+		if err != nil {
+			if v.Error == "" {
+				v.Error = fmt.Sprintf("the line after entry %d is not an entry: %v", prev.Seq, err)
+			}
+			return
+		}
+		if fn != nil {
+			fn(e)
+		}
+		if v.Error != "" {
+			prev = e
+			return
+		}
+		hash, err := entryHash(e)
+		switch {
+		case err != nil:
+			v.Error = fmt.Sprintf("entry %d: %v", e.Seq, err)
+		case e.Seq != prev.Seq+1:
+			v.Error = fmt.Sprintf("entry %d follows entry %d", e.Seq, prev.Seq)
+		case e.PrevHash != prev.Hash:
+			v.Error = fmt.Sprintf("entry %d doesn't carry the hash of entry %d", e.Seq, prev.Seq)
+		case e.Hash != hash:
+			v.Error = fmt.Sprintf("entry %d was modified", e.Seq)
+		}
+		prev = e
+	})
+	if v.err != nil && v.Error == "" {
+		v.Error = v.err.Error()
+	}
+	v.Valid = v.Error == ""
+	return v
+}
+
+// each calls fn for every entry, oldest first, or with the error of a line
+// that isn't one. It returns errors reading the log.
+func (l *Log) each(fn func(models.AuditEntry, error)) error {
+	if l.path == "" {
+		for _, e := range l.entries {
+			fn(e, nil)
+		}
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var e models.AuditEntry
+			err := json.Unmarshal(line, &e)
+			fn(e, err)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// entryHash returns the hash of e, which covers every field but Hash.
+func entryHash(e models.AuditEntry) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []models.AuditEntry{
+		{Kind: "request", Action: "create", Provider: "aws", Subject: "u1", Body: json.RawMessage(`{"name":"web01","cpus":2}`)},
+		{Kind: "request", Action: "stop", Provider: "proxmox", VMID: "100", Subject: "u2"},
+		{Kind: "operation", Action: "create", Provider: "aws", VMID: "i-1", Result: json.RawMessage(`{"id":"i-1"}`)},
+	} {
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The chain continues after the log is opened again.
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(models.AuditEntry{Kind: "request", Action: "delete", Provider: "aws", VMID: "i-1", Subject: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := l.Verify(); !v.Valid || v.Entries != 4 {
+		t.Fatalf("Verify: %+v", v)
+	}
+
+	entries, err := l.Query(models.AuditFilter{Provider: "aws"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Seq != 4 || entries[1].Seq != 3 {
+		t.Errorf("Query: %+v", entries)
+	}
+	since := time.Now().Add(-time.Minute)
+	if entries, _ := l.Query(models.AuditFilter{Subject: "u1", Since: &since}, 10); len(entries) != 2 {
+		t.Errorf("Query by subject: %+v", entries)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	tests := []struct {
+		name, content, want string
+	}{
+		{"modified", strings.Join(lines[:1], "") + strings.Replace(lines[1], `"u2"`, `"u3"`, 1) + strings.Join(lines[2:], ""), "entry 2 was modified"},
+		{"removed", lines[0] + strings.Join(lines[2:], ""), "entry 3 follows entry 1"},
+		{"garbage", lines[0] + "{\n" + strings.Join(lines[1:], ""), "the line after entry 1 is not an entry"},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := l.Verify(); v.Valid || !strings.Contains(v.Error, tt.want) {
+			t.Errorf("%s: %+v, want error %q", tt.name, v, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	r.Use(l.Middleware)
+	r.HandleFunc("/vms/create", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["adminPassword"] != "hunter2" {
+			t.Errorf("handler got %v, %v", req, err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"success":true,"data":{"id":"op1","type":"create"}}`))
+	}).Methods("POST").Name("create")
+	r.HandleFunc("/vms/{provider}/{id:.+}/{action:start|stop}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"success":false,"error":"Permission \"power\" is required on provider aws"}`))
+	}).Methods("POST").Name("power")
+	r.HandleFunc("/vms", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/vms/create", strings.NewReader(`{"provider":"Proxmox","vmName":"web01","adminPassword":"hunter2","cloud":{"clientSecret":"s"},"sshKeys":["ssh-ed25519 AAAA"]}`)),
+		httptest.NewRequest("POST", "/vms/aws/i-1/stop", nil),
+		httptest.NewRequest("GET", "/vms", nil),
+	} {
+		req.RemoteAddr = "192.0.2.7:50000"
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	l.OperationFinished(models.Operation{ID: "op1", Type: "create", Provider: "proxmox", Status: models.OperationSucceeded,
+		Result: &models.VM{ID: "101", Name: "web01"}})
+
+	entries, err := l.Query(models.AuditFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3: %+v", len(entries), entries)
+	}
+	op, stop, create := entries[0], entries[1], entries[2]
+	if create.Action != "create" || create.Provider != "proxmox" || create.OperationID != "op1" || !create.Success ||
+		create.SourceIP != "192.0.2.7" || create.Status != http.StatusAccepted {
+		t.Errorf("create: %+v", create)
+	}
+	body := string(create.Body)
+	if strings.Contains(body, "hunter2") || strings.Contains(body, `"s"`) || !strings.Contains(body, "ssh-ed25519") || !strings.Contains(body, "web01") {
+		t.Errorf("create body %s", body)
+	}
+	if stop.Action != "stop" || stop.Provider != "aws" || stop.VMID != "i-1" || stop.Success || !strings.Contains(stop.Error, "power") {
+		t.Errorf("stop: %+v", stop)
+	}
+	if op.Kind != "operation" || op.VMID != "101" || op.OperationID != "op1" || !op.Success || !strings.Contains(string(op.Result), `"web01"`) {
+		t.Errorf("operation: %+v", op)
+	}
+}
@@ -0,0 +1,217 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBodySize bounds the request bodies that are recorded. Larger bodies are
+// passed on to the handler but left out of the entry.
+const maxBodySize = 1 << 20
+
+// secretKeys are parts of the JSON keys, in lowercase, whose values are
+// redacted from recorded bodies.
+var secretKeys = []string{"password", "secret", "token", "privatekey", "credential", "userdata", "customdata"}
+
+// Middleware records every request other than GET, HEAD and OPTIONS. It has
+// to run after the Authenticator, whose identity it records. The action is
+// the power action of the request or else the name of its route.
+func (l *Log) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		vars := mux.Vars(r)
+		e := models.AuditEntry{
+			Time:         start,
+			Kind:         "request",
+			Action:       vars["action"],
+			Provider:     strings.ToLower(vars["provider"]),
+			SourceIP:     r.RemoteAddr,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			Status:       rec.status,
+			DurationMs:   time.Since(start).Milliseconds(),
+		}
+		if e.Action == "" {
+			if route := mux.CurrentRoute(r); route != nil {
+				e.Action = route.GetName()
+			}
+		}
+		if e.Provider != "" {
+			e.VMID = vars["id"]
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.SourceIP = host
+		}
+		if id := middleware.IdentityFrom(r.Context()); id != nil {
+			e.Subject, e.Name, e.APIKey = id.Subject, id.Name, id.APIKey
+		}
+		if len(body) <= maxBodySize {
+			e.Body = redact(body)
+			if e.Provider == "" {
+				var req struct {
+					Provider string `json:"provider"`
+				}
+				json.Unmarshal(body, &req)
+				e.Provider = strings.ToLower(req.Provider)
+			}
+		}
+
+		var resp struct {
+			Success bool   `json:"success"`
+			Error   string `json:"error"`
+			Data    struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.body.Bytes(), &resp); err == nil {
+			e.Success, e.Error = resp.Success && rec.status < 400, resp.Error
+		} else {
+			e.Success = rec.status < 400
+		}
+		if rec.status == http.StatusAccepted {
+			e.OperationID = resp.Data.ID
+		}
+
+		if err := l.Append(e); err != nil {
+			fmt.Printf("Writing the audit log failed. Will continue without it. Error: %v\r\n", err)
+		}
+	})
+}
+
+// OperationFinished records the outcome of an operation; register it with
+// operations.Manager.OnFinish.
+func (l *Log) OperationFinished(op models.Operation) {
+	e := models.AuditEntry{
+		Kind:        "operation",
+		Action:      op.Type,
+		Provider:    op.Provider,
+		VMID:        op.VMID,
+		OperationID: op.ID,
+		Success:     op.Status == models.OperationSucceeded,
+		Error:       op.Error,
+	}
+	if op.FinishedAt != nil {
+		e.Time = *op.FinishedAt
+		e.DurationMs = op.FinishedAt.Sub(op.CreatedAt).Milliseconds()
+	}
+	if vm, ok := op.Result.(*models.VM); ok && vm != nil && e.VMID == "" {
+		e.VMID = vm.ID
+	}
+	if op.Result != nil {
+		result, err := json.Marshal(op.Result)
+		if err == nil {
+			e.Result = result
+		}
+	}
+	if err := l.Append(e); err != nil {
+		fmt.Printf("Writing the audit log failed. Will continue without it. Error: %v\r\n", err)
+	}
+}
+
+// redact returns body as compact JSON with the values of secret keys
+// replaced. Bodies that aren't JSON are recorded as a string.
+func redact(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		data, _ := json.Marshal("[not JSON]")
+		return data
+	}
+	data, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecret(key) && value != nil {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return v
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder passes a response on and keeps its status and the start of its
+// body.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if n := maxBodySize - r.body.Len(); n > 0 {
+		r.body.Write(b[:min(n, len(b))])
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.APIResponse{Success: false, Error: msg})
+}
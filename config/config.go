@@ -1,26 +1,70 @@
 package config
 
-import "os"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
 
 type AzureCredentials struct {
-	TenantID       string
-	ClientID       string
-	ClientSecret   string
-	SubscriptionID string // Added subscription ID for Azure
+	TenantID       string `json:"tenantId"`
+	ClientID       string `json:"clientId"`
+	ClientSecret   string `json:"clientSecret"`
+	SubscriptionID string `json:"subscriptionId"`
 }
 
 type AWSCredentials struct {
-	AccessKey string
-	SecretKey string
-	Region    string
+	AccessKey string   `json:"accessKey"`
+	SecretKey string   `json:"secretKey"`
+	Region    string   `json:"region"`
+	Regions   []string `json:"regions"` // regions to list instances in, or ["all"] for every enabled region; default Region
 }
 
 type GCPCredentials struct {
-	ProjectID       string
-	CredentialsFile string
+	ProjectID       string `json:"projectId"`
+	CredentialsFile string `json:"credentialsFile"`
+}
+
+type HyperVCredentials struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"` // WinRM HTTP port, 5985 by default
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
-// Add these type definitions below your existing credential types.
+type NutanixCredentials struct {
+	APIURL   string `json:"apiUrl"` // Prism Central, e.g. https://prism:9440
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Insecure bool   `json:"insecure"` // skip TLS certificate verification
+}
+
+type ProxmoxCredentials struct {
+	APIURL   string `json:"apiUrl"` // e.g. https://pve:8006/api2/json
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Node     string `json:"node"`
+}
+
+type VSphereCredentials struct {
+	URL        string `json:"url"` // e.g. https://vcenter/sdk
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Datacenter string `json:"datacenter"` // datacenter new VMs are created in
+}
 
 type CloudMappings struct {
 	Azure AzureMapping `json:"azure"`
@@ -50,36 +94,219 @@ type GCPMapping struct {
 	DefaultProject string            `json:"defaultProject"`
 }
 
-// Then add a new field to your Config struct:
+// Timeouts bounds how long a single call to a provider may take. Zero means
+// the value is not set.
+type Timeouts struct {
+	Read      time.Duration // list and get calls
+	Operation time.Duration // create, delete and power calls, which wait for the cloud to finish
+}
+
+// UnmarshalJSON reads timeouts written as durations, e.g. {"read": "30s"}.
+func (t *Timeouts) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Read      string `json:"read"`
+		Operation string `json:"operation"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	var err error
+	if raw.Read != "" {
+		if t.Read, err = time.ParseDuration(raw.Read); err != nil {
+			return err
+		}
+	}
+	if raw.Operation != "" {
+		if t.Operation, err = time.ParseDuration(raw.Operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProviderNames lists the names providers are registered under.
+var ProviderNames = []string{"azure", "aws", "gcp", "hyperv", "nutanix", "proxmox", "vsphere"}
+
+// AuthConfig configures how API callers are authenticated. Bearer tokens are
+// JWTs signed by an OIDC issuer (RS256, ES256, ...) or, with Config.JWTSecret,
+// HS256 tokens signed with the shared secret.
+type AuthConfig struct {
+	Disabled  bool     `json:"disabled"`  // accept every request; for local development only
+	Issuer    string   `json:"issuer"`    // OIDC issuer URL, which tokens must carry as "iss"
+	JWKSURL   string   `json:"jwksUrl"`   // signing keys; by default from the issuer's discovery document
+	Audiences []string `json:"audiences"` // tokens must carry one of them as "aud"
+
+	// RolesClaim and GroupsClaim name the claims holding the caller's roles
+	// and groups, "roles" and "groups" by default. Nested claims are given
+	// as a path, e.g. "realm_access.roles".
+	RolesClaim  string `json:"rolesClaim"`
+	GroupsClaim string `json:"groupsClaim"`
+
+	// Grants give roles to groups and subjects. Roles in the roles claim
+	// apply to every provider.
+	Grants []Grant `json:"grants"`
+
+	// APIKeysFile is where API keys are stored. Without it they are kept in
+	// memory and lost on restart. It is only read at startup.
+	APIKeysFile string `json:"apiKeysFile"`
+}
+
+// Actions callers can be allowed to take on the VMs of a provider.
+const (
+	ActionRead   = "read" // list and get VMs, images, sizes and operations
+	ActionCreate = "create"
+	ActionPower  = "power" // start, stop, restart and suspend
+	ActionTags   = "tags"
+	ActionDelete = "delete"
+)
+
+// Roles lists the actions each role allows.
+var Roles = map[string][]string{
+	"viewer":   {ActionRead},
+	"operator": {ActionRead, ActionCreate, ActionPower, ActionTags},
+	"admin":    {ActionRead, ActionCreate, ActionPower, ActionTags, ActionDelete},
+}
+
+// ValidateScope checks the role, provider patterns and actions of a grant or
+// API key scope.
+func ValidateScope(role string, providers, actions []string) error {
+	roleActions, ok := Roles[role]
+	if !ok {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	for _, pattern := range providers {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid provider pattern %q", pattern)
+		}
+	}
+	for _, action := range actions {
+		if !contains(roleActions, action) {
+			return fmt.Errorf("role %s has no action %q", role, action)
+		}
+	}
+	return nil
+}
+
+// Policy restricts create requests. It applies to callers in one of Groups,
+// or to everyone, creating VMs on one of Providers (names or patterns like
+// "azure-*"), or on any provider. Every rule that is set has to be met.
+type Policy struct {
+	Name      string   `json:"name"`
+	Groups    []string `json:"groups"`
+	Providers []string `json:"providers"`
+
+	// Allowed sizes, images and regions as given in requests, e.g. "small",
+	// "Standard_B2s" or "eu-*". Patterns are matched case-insensitively.
+	AllowedSizes   []string `json:"allowedSizes"`
+	AllowedImages  []string `json:"allowedImages"`
+	AllowedRegions []string `json:"allowedRegions"`
+
+	MaxVMsPerUser  int      `json:"maxVMsPerUser"`  // VMs a caller may own on the policy's providers
+	RequiredTags   []string `json:"requiredTags"`   // tag keys requests have to set
+	ForbidPublicIP bool     `json:"forbidPublicIp"` // VMs get no public IP address
+	NamePattern    string   `json:"namePattern"`    // regular expression VM names have to match in full
+}
+
+// Grant gives Role to the callers in one of Groups or with one of Subjects.
+// Providers limits it to some providers or accounts, by name or pattern like
+// "azure-*", and Actions to some of the role's actions; read is always kept.
+type Grant struct {
+	Role      string   `json:"role"`
+	Groups    []string `json:"groups"`
+	Subjects  []string `json:"subjects"`
+	Providers []string `json:"providers"`
+	Actions   []string `json:"actions"`
+}
+
 type Config struct {
-	Port       string
-	JWTSecret  string
-	AzureCreds AzureCredentials
-	AWSCreds   AWSCredentials
-	GCPCreds   GCPCredentials
-	Mappings   CloudMappings // <--- new field for unified mappings
+	Port         string             `json:"port"`
+	JWTSecret    string             `json:"jwtSecret"`
+	Auth         AuthConfig         `json:"auth"`
+	AuditLogFile string             `json:"auditLogFile"` // without it the audit log is kept in memory
+	AzureCreds   AzureCredentials   `json:"azure"`
+	AWSCreds     AWSCredentials     `json:"aws"`
+	GCPCreds     GCPCredentials     `json:"gcp"`
+	HyperVCreds  HyperVCredentials  `json:"hyperv"`
+	NutanixCreds NutanixCredentials `json:"nutanix"`
+	ProxmoxCreds ProxmoxCredentials `json:"proxmox"`
+	VSphereCreds VSphereCredentials `json:"vsphere"`
+	Mappings     CloudMappings      `json:"mappings"`
+
+	// Images maps logical image names to a reference per provider type. They
+	// are added to the built-in images; see ImageAliases.
+	Images map[string]map[string]string `json:"images"`
+
+	// Timeouts holds the deadlines of every provider and account. In the
+	// config file it is keyed by provider type, plus "default" for all
+	// providers.
+	Timeouts map[string]Timeouts `json:"timeouts"`
+
+	// Accounts are additional named providers, e.g. a second Azure subscription.
+	Accounts []Account `json:"accounts"`
+
+	// Policies restrict what callers may create.
+	Policies []Policy `json:"policies"`
+
+	instances []Instance
 }
 
-// Finally, update LoadConfig to set default mappings (or load them from environment variables as needed):
-func LoadConfig() *Config {
+// Account is an additional instance of a provider type registered under its
+// own name. Only the credentials section of its type is used; its mappings
+// are applied on top of the top-level ones and its timeouts default to those
+// of its type.
+type Account struct {
+	Name     string             `json:"name"` // e.g. "azure-prod"
+	Type     string             `json:"type"` // one of ProviderNames
+	Azure    AzureCredentials   `json:"azure"`
+	AWS      AWSCredentials     `json:"aws"`
+	GCP      GCPCredentials     `json:"gcp"`
+	HyperV   HyperVCredentials  `json:"hyperv"`
+	Nutanix  NutanixCredentials `json:"nutanix"`
+	Proxmox  ProxmoxCredentials `json:"proxmox"`
+	VSphere  VSphereCredentials `json:"vsphere"`
+	Mappings json.RawMessage    `json:"mappings"`
+	Timeouts Timeouts           `json:"timeouts"`
+}
+
+// credentials returns the credentials section of the given provider type.
+func (a *Account) credentials(typ string) interface{} {
+	switch typ {
+	case "azure":
+		return a.Azure
+	case "aws":
+		return a.AWS
+	case "gcp":
+		return a.GCP
+	case "hyperv":
+		return a.HyperV
+	case "nutanix":
+		return a.Nutanix
+	case "proxmox":
+		return a.Proxmox
+	case "vsphere":
+		return a.VSphere
+	}
+	return nil
+}
+
+// Instance is a provider to register: the top-level configuration of a
+// provider type under the type's name, or an account under its own name.
+type Instance struct {
+	Name   string
+	Type   string
+	Config *Config // what the provider is created from
+}
+
+// defaults returns the built-in configuration the config file and the
+// environment are applied to.
+func defaults() *Config {
 	return &Config{
-		Port:      getEnv("PORT", "8080"),
-		JWTSecret: getEnv("JWT_SECRET", "your-secret-key"),
-		AzureCreds: AzureCredentials{
-			TenantID:       getEnv("AZURE_TENANT_ID", ""),
-			ClientID:       getEnv("AZURE_CLIENT_ID", ""),
-			ClientSecret:   getEnv("AZURE_CLIENT_SECRET", ""),
-			SubscriptionID: getEnv("AZURE_SUBSCRIPTION_ID", ""),
-		},
-		AWSCreds: AWSCredentials{
-			AccessKey: getEnv("AWS_ACCESS_KEY", ""),
-			SecretKey: getEnv("AWS_SECRET_KEY", ""),
-			Region:    getEnv("AWS_REGION", "us-east-1"),
-		},
-		GCPCreds: GCPCredentials{
-			ProjectID:       getEnv("GCP_PROJECT_ID", ""),
-			CredentialsFile: getEnv("GCP_CREDENTIALS_FILE", ""),
-		},
+		Port:        "8080",
+		Auth:        AuthConfig{RolesClaim: "roles", GroupsClaim: "groups"},
+		AWSCreds:    AWSCredentials{Region: "us-east-1"},
+		HyperVCreds: HyperVCredentials{Port: 5985},
 		Mappings: CloudMappings{
 			Azure: AzureMapping{
 				CustomVMSizes: map[string]string{
@@ -87,11 +314,8 @@ func LoadConfig() *Config {
 					"medium": "Standard_DS2_v2",
 					"large":  "Standard_DS3_v2",
 				},
-				CustomImages: map[string]string{
-					"ubuntu24": "Canonical:ubuntu-24_04-lts:server:latest",
-				},
-				DefaultResourceGroup: getEnv("AZURE_DEFAULT_RESOURCE_GROUP", "script-test"),
-				DefaultLocation:      getEnv("AZURE_DEFAULT_LOCATION", "westeurope"),
+				CustomImages:    map[string]string{},
+				DefaultLocation: "westeurope",
 			},
 			AWS: AWSMapping{
 				CustomVMSizes: map[string]string{
@@ -99,12 +323,8 @@ func LoadConfig() *Config {
 					"medium": "t2.small",
 					"large":  "t2.medium",
 				},
-				CustomImages: map[string]string{
-					"ubuntu24": "ami-0644165ab979df02d",
-				},
-				DefaultKeyName:          getEnv("AWS_DEFAULT_KEYNAME", "testkey345"),
-				DefaultSecurityGroupIDs: []string{getEnv("AWS_DEFAULT_SECURITY_GROUP", "sg-01234567")},
-				DefaultRegion:           getEnv("AWS_DEFAULT_REGION", "eu-west-3"),
+				CustomImages:  map[string]string{},
+				DefaultRegion: "eu-west-3",
 			},
 			GCP: GCPMapping{
 				CustomVMSizes: map[string]string{
@@ -112,19 +332,574 @@ func LoadConfig() *Config {
 					"medium": "t2d-standard-2",
 					"large":  "t2d-standard-4",
 				},
-				CustomImages: map[string]string{
-					"ubuntu24": "projects/ubuntu-os-cloud/global/images/ubuntu-2404-noble-amd64-v20250313",
-				},
-				DefaultZone:    getEnv("GCP_DEFAULT_ZONE", "europe-west9-c"),
-				DefaultProject: getEnv("GCP_DEFAULT_PROJECT", ""),
+				CustomImages: map[string]string{},
+				DefaultZone:  "europe-west9-c",
 			},
 		},
 	}
 }
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// builtinImages are the logical images available without configuration. The
+// references resolve to the latest release when a VM is created: AWS by
+// "<owner>/<name pattern>", GCP by image family and Azure by the "latest"
+// marketplace version.
+var builtinImages = map[string]map[string]string{
+	"ubuntu24": {
+		"azure": "Canonical:ubuntu-24_04-lts:server:latest",
+		"aws":   "099720109477/ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-amd64-server-*",
+		"gcp":   "projects/ubuntu-os-cloud/global/images/family/ubuntu-2404-lts-amd64",
+	},
+	"ubuntu22": {
+		"azure": "Canonical:0001-com-ubuntu-server-jammy:22_04-lts-gen2:latest",
+		"aws":   "099720109477/ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*",
+		"gcp":   "projects/ubuntu-os-cloud/global/images/family/ubuntu-2204-lts",
+	},
+	"debian12": {
+		"azure": "Debian:debian-12:12-gen2:latest",
+		"aws":   "136693071363/debian-12-amd64-*",
+		"gcp":   "projects/debian-cloud/global/images/family/debian-12",
+	},
+	"windows2022": {
+		"azure": "MicrosoftWindowsServer:WindowsServer:2022-datacenter-azure-edition:latest",
+		"aws":   "amazon/Windows_Server-2022-English-Full-Base-*",
+		"gcp":   "projects/windows-cloud/global/images/family/windows-2022",
+	},
+}
+
+// ImageAliases returns the image names a provider type resolves and their
+// references: the built-in images, the configured images and, for the
+// clouds, the customImages of the mappings, each overriding the one before.
+func (c *Config) ImageAliases(typ string) map[string]string {
+	aliases := make(map[string]string)
+	for _, catalog := range []map[string]map[string]string{builtinImages, c.Images} {
+		for name, refs := range catalog {
+			if ref := refs[typ]; ref != "" {
+				aliases[strings.ToLower(name)] = ref
+			}
+		}
+	}
+	var custom map[string]string
+	switch typ {
+	case "azure":
+		custom = c.Mappings.Azure.CustomImages
+	case "aws":
+		custom = c.Mappings.AWS.CustomImages
+	case "gcp":
+		custom = c.Mappings.GCP.CustomImages
+	}
+	for name, ref := range custom {
+		aliases[strings.ToLower(name)] = ref
+	}
+	return aliases
+}
+
+// File returns the path of the config file, set with ANYVM_CONFIG. Without
+// it AnyVM is configured through the environment only.
+func File() string {
+	return os.Getenv("ANYVM_CONFIG")
+}
+
+// Load builds the configuration from the built-in defaults, the YAML or JSON
+// file at path (if path isn't empty) and the environment, each overriding the
+// one before, and validates it. Aliases in the file's mappings are added to
+// the built-in ones.
+func Load(path string) (*Config, error) {
+	cfg := defaults()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	errs := cfg.applyEnv()
+	errs = append(errs, cfg.validate()...)
+	if len(errs) == 0 {
+		errs = cfg.buildInstances()
+	}
+	if err := errors.Join(errs...); err != nil {
+		if path != "" {
+			return nil, fmt.Errorf("invalid configuration in %s and the environment:\n%w", path, err)
+		}
+		return nil, fmt.Errorf("invalid configuration in the environment:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// current holds the configuration that was loaded last.
+var current atomic.Pointer[Config]
+
+// Current returns the configuration that was loaded last by LoadConfig or a
+// reload.
+func Current() *Config {
+	return current.Load()
+}
+
+// LoadConfig loads the configuration at startup and exits when it is invalid.
+func LoadConfig() *Config {
+	cfg, err := Load(File())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\r\n", err)
+		os.Exit(1)
+	}
+	current.Store(cfg)
+	return cfg
+}
+
+// applyEnv overrides the configuration with the environment variables that
+// are set.
+func (c *Config) applyEnv() []error {
+	var errs []error
+	setEnv(&c.Port, "PORT")
+	setEnv(&c.JWTSecret, "JWT_SECRET")
+	if disabled, ok := os.LookupEnv("AUTH_DISABLED"); ok {
+		c.Auth.Disabled = disabled == "true"
+	}
+	setEnv(&c.Auth.Issuer, "OIDC_ISSUER")
+	setEnv(&c.Auth.JWKSURL, "OIDC_JWKS_URL")
+	if audiences, ok := os.LookupEnv("OIDC_AUDIENCES"); ok {
+		c.Auth.Audiences = splitList(audiences)
+	}
+	setEnv(&c.Auth.APIKeysFile, "API_KEYS_FILE")
+	setEnv(&c.AuditLogFile, "AUDIT_LOG_FILE")
+
+	setEnv(&c.AzureCreds.TenantID, "AZURE_TENANT_ID")
+	setEnv(&c.AzureCreds.ClientID, "AZURE_CLIENT_ID")
+	setEnv(&c.AzureCreds.ClientSecret, "AZURE_CLIENT_SECRET")
+	setEnv(&c.AzureCreds.SubscriptionID, "AZURE_SUBSCRIPTION_ID")
+	setEnv(&c.Mappings.Azure.DefaultResourceGroup, "AZURE_DEFAULT_RESOURCE_GROUP")
+	setEnv(&c.Mappings.Azure.DefaultLocation, "AZURE_DEFAULT_LOCATION")
+
+	setEnv(&c.AWSCreds.AccessKey, "AWS_ACCESS_KEY")
+	setEnv(&c.AWSCreds.SecretKey, "AWS_SECRET_KEY")
+	setEnv(&c.AWSCreds.Region, "AWS_REGION")
+	if regions, ok := os.LookupEnv("AWS_REGIONS"); ok {
+		c.AWSCreds.Regions = splitList(regions)
+	}
+	setEnv(&c.Mappings.AWS.DefaultKeyName, "AWS_DEFAULT_KEYNAME")
+	setEnv(&c.Mappings.AWS.DefaultRegion, "AWS_DEFAULT_REGION")
+	if sg, ok := os.LookupEnv("AWS_DEFAULT_SECURITY_GROUP"); ok {
+		c.Mappings.AWS.DefaultSecurityGroupIDs = []string{sg}
+	}
+
+	setEnv(&c.GCPCreds.ProjectID, "GCP_PROJECT_ID")
+	setEnv(&c.GCPCreds.CredentialsFile, "GCP_CREDENTIALS_FILE")
+	setEnv(&c.Mappings.GCP.DefaultZone, "GCP_DEFAULT_ZONE")
+	setEnv(&c.Mappings.GCP.DefaultProject, "GCP_DEFAULT_PROJECT")
+
+	setEnv(&c.HyperVCreds.Host, "HYPERV_HOST")
+	setEnv(&c.HyperVCreds.Username, "HYPERV_USERNAME")
+	setEnv(&c.HyperVCreds.Password, "HYPERV_PASSWORD")
+	if port, ok := os.LookupEnv("HYPERV_PORT"); ok && port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("HYPERV_PORT: %q is not a number", port))
+		}
+		c.HyperVCreds.Port = p
+	}
+
+	setEnv(&c.NutanixCreds.APIURL, "NUTANIX_API_URL")
+	setEnv(&c.NutanixCreds.Username, "NUTANIX_USERNAME")
+	setEnv(&c.NutanixCreds.Password, "NUTANIX_PASSWORD")
+	if insecure, ok := os.LookupEnv("NUTANIX_INSECURE"); ok {
+		c.NutanixCreds.Insecure = insecure == "true"
+	}
+
+	setEnv(&c.ProxmoxCreds.APIURL, "PROXMOX_API_URL")
+	setEnv(&c.ProxmoxCreds.Username, "PROXMOX_USERNAME")
+	setEnv(&c.ProxmoxCreds.Password, "PROXMOX_PASSWORD")
+	setEnv(&c.ProxmoxCreds.Node, "PROXMOX_NODE")
+
+	setEnv(&c.VSphereCreds.URL, "VSPHERE_URL")
+	setEnv(&c.VSphereCreds.Username, "VSPHERE_USERNAME")
+	setEnv(&c.VSphereCreds.Password, "VSPHERE_PASSWORD")
+	setEnv(&c.VSphereCreds.Datacenter, "VSPHERE_DATACENTER")
+
+	return append(errs, c.resolveTimeouts()...)
+}
+
+// resolveTimeouts replaces the timeouts from the config file with the
+// deadlines of every provider, e.g. HYPERV_TIMEOUT=30s or
+// AZURE_OPERATION_TIMEOUT=20m. A provider's own setting wins over the default
+// for all providers, and the environment wins over the file.
+func (c *Config) resolveTimeouts() []error {
+	var errs []error
+	file := c.Timeouts
+	for name := range file {
+		if name != "default" && !isProviderName(name) {
+			errs = append(errs, fmt.Errorf("timeouts: unknown provider %q", name))
+		}
+	}
+
+	fallback := Timeouts{Read: time.Minute, Operation: 15 * time.Minute}
+	readTimeout := pickDuration(&errs, "PROVIDER_TIMEOUT", file["default"].Read, fallback.Read)
+	operationTimeout := pickDuration(&errs, "PROVIDER_OPERATION_TIMEOUT", file["default"].Operation, fallback.Operation)
+
+	c.Timeouts = make(map[string]Timeouts, len(ProviderNames))
+	for _, name := range ProviderNames {
+		prefix := strings.ToUpper(name)
+		c.Timeouts[name] = Timeouts{
+			Read:      pickDuration(&errs, prefix+"_TIMEOUT", file[name].Read, readTimeout),
+			Operation: pickDuration(&errs, prefix+"_OPERATION_TIMEOUT", file[name].Operation, operationTimeout),
+		}
+	}
+	return errs
+}
+
+// pickDuration returns the duration in the environment variable key, else
+// the value from the file, else fallback.
+func pickDuration(errs *[]error, key string, file, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists && value != "" {
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			return d
+		}
+		*errs = append(*errs, fmt.Errorf("%s: %v", key, err))
+	}
+	if file != 0 {
+		return file
 	}
 	return fallback
 }
+
+// validate checks the configuration for values the providers can't work with.
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "port: %q is not a valid port", c.Port)
+
+	for _, typ := range ProviderNames {
+		errs = append(errs, c.validateProvider(typ)...)
+	}
+	for key, value := range map[string]string{
+		"auth.issuer":  c.Auth.Issuer,
+		"auth.jwksUrl": c.Auth.JWKSURL,
+	} {
+		check(value == "" || isHTTPURL(value), "%s: %q is not an http(s) URL", key, value)
+	}
+
+	if c.Auth.Issuer != "" || c.Auth.JWKSURL != "" {
+		check(c.Auth.Issuer != "", "auth.issuer: required with auth.jwksUrl")
+		check(len(c.Auth.Audiences) > 0, "auth.audiences: at least one audience is required with auth.issuer")
+	}
+	for i, g := range c.Auth.Grants {
+		check(len(g.Groups) > 0 || len(g.Subjects) > 0, "auth.grants[%d]: groups or subjects are required", i)
+		if err := ValidateScope(g.Role, g.Providers, g.Actions); err != nil {
+			errs = append(errs, fmt.Errorf("auth.grants[%d]: %v", i, err))
+		}
+	}
+
+	for name, refs := range c.Images {
+		for typ, ref := range refs {
+			check(isProviderName(typ), "images.%s: unknown provider %q", name, typ)
+			check(ref != "", "images.%s.%s: no image reference", name, typ)
+		}
+	}
+
+	policyNames := make(map[string]bool)
+	for i, p := range c.Policies {
+		field := fmt.Sprintf("policies[%d]", i)
+		check(p.Name != "" && !policyNames[p.Name], "%s: a unique name is required", field)
+		policyNames[p.Name] = true
+		for _, patterns := range [][]string{p.Providers, p.AllowedSizes, p.AllowedImages, p.AllowedRegions} {
+			for _, pattern := range patterns {
+				_, err := path.Match(pattern, "")
+				check(err == nil && pattern != "", "%s: invalid pattern %q", field, pattern)
+			}
+		}
+		check(p.MaxVMsPerUser >= 0, "%s: maxVMsPerUser must not be negative", field)
+		if p.NamePattern != "" {
+			_, err := regexp.Compile(p.NamePattern)
+			check(err == nil, "%s: invalid namePattern: %v", field, err)
+		}
+	}
+
+	for _, name := range ProviderNames {
+		t := c.Timeouts[name]
+		check(t.Read >= 0 && t.Operation >= 0, "timeouts.%s: timeouts must not be negative", name)
+	}
+	return errs
+}
+
+// validateProvider checks the settings of provider type typ. Accounts are
+// checked with it, so errors of the top-level settings aren't repeated for
+// each of them.
+func (c *Config) validateProvider(typ string) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	requireAll(&errs, typ, c.credentialFields(typ))
+	var urlKey, urlValue string
+	var aliases map[string]map[string]string
+	switch typ {
+	case "aws":
+		validRegions := true
+		for _, region := range c.AWSCreds.Regions {
+			validRegions = validRegions && region != "" && (region != "all" || len(c.AWSCreds.Regions) == 1)
+		}
+		check(validRegions, "aws.regions: %q must be a list of regions or [all]", c.AWSCreds.Regions)
+		aliases = map[string]map[string]string{"customVmSizes": c.Mappings.AWS.CustomVMSizes, "customImages": c.Mappings.AWS.CustomImages}
+	case "azure":
+		fields := c.credentialFields(typ)
+		configured := len(missingFields(typ, fields)) < len(fields)
+		check(!configured || c.Mappings.Azure.DefaultResourceGroup != "", "mappings.azure.defaultResourceGroup is required when azure is configured")
+		aliases = map[string]map[string]string{"customVmSizes": c.Mappings.Azure.CustomVMSizes, "customImages": c.Mappings.Azure.CustomImages}
+	case "gcp":
+		aliases = map[string]map[string]string{"customVmSizes": c.Mappings.GCP.CustomVMSizes, "customImages": c.Mappings.GCP.CustomImages}
+	case "hyperv":
+		check(c.HyperVCreds.Port > 0 && c.HyperVCreds.Port < 65536, "hyperv.port: %d is not a valid port", c.HyperVCreds.Port)
+	case "nutanix":
+		urlKey, urlValue = "nutanix.apiUrl", c.NutanixCreds.APIURL
+	case "proxmox":
+		urlKey, urlValue = "proxmox.apiUrl", c.ProxmoxCreds.APIURL
+	case "vsphere":
+		urlKey, urlValue = "vsphere.url", c.VSphereCreds.URL
+	}
+	check(urlValue == "" || isHTTPURL(urlValue), "%s: %q is not an http(s) URL", urlKey, urlValue)
+	for _, field := range []string{"customVmSizes", "customImages"} {
+		for alias, value := range aliases[field] {
+			check(value != "", "mappings.%s.%s: alias %q has no value", typ, field, alias)
+		}
+	}
+	return errs
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// accountNamePattern matches account names, which are used in URL paths.
+var accountNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
+
+// buildInstances validates the accounts and lists every provider instance.
+func (c *Config) buildInstances() []error {
+	var errs []error
+	c.instances = nil
+	for _, name := range ProviderNames {
+		c.instances = append(c.instances, Instance{Name: name, Type: name, Config: c})
+	}
+	seen := make(map[string]bool)
+	for i := range c.Accounts {
+		a := &c.Accounts[i]
+		field := fmt.Sprintf("accounts[%d]", i)
+		if a.Name != "" {
+			field += " (" + a.Name + ")"
+		}
+		switch {
+		case !accountNamePattern.MatchString(a.Name):
+			errs = append(errs, fmt.Errorf("%s: name must be lowercase letters, digits and dashes", field))
+			continue
+		case isProviderName(a.Name):
+			errs = append(errs, fmt.Errorf("%s: name is reserved for the top-level %s settings", field, a.Name))
+			continue
+		case seen[a.Name]:
+			errs = append(errs, fmt.Errorf("%s: name is used more than once", field))
+			continue
+		case !isProviderName(a.Type):
+			errs = append(errs, fmt.Errorf("%s: type must be one of %s", field, strings.Join(ProviderNames, ", ")))
+			continue
+		}
+		seen[a.Name] = true
+
+		acfg, err := c.accountConfig(a)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+			continue
+		}
+		for _, err := range acfg.validateProvider(a.Type) {
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+		}
+		for _, typ := range ProviderNames {
+			if typ != a.Type && !reflect.ValueOf(a.credentials(typ)).IsZero() {
+				errs = append(errs, fmt.Errorf("%s: %s settings are not used by a %s account", field, typ, a.Type))
+			}
+		}
+		// Partially set credentials are reported by validate.
+		if fields := acfg.credentialFields(a.Type); len(missingFields(a.Type, fields)) == len(fields) {
+			errs = append(errs, fmt.Errorf("%s: %s credentials are required", field, a.Type))
+		}
+
+		timeouts := c.Timeouts[a.Type]
+		if a.Timeouts.Read != 0 {
+			timeouts.Read = a.Timeouts.Read
+		}
+		if a.Timeouts.Operation != 0 {
+			timeouts.Operation = a.Timeouts.Operation
+		}
+		if timeouts.Read < 0 || timeouts.Operation < 0 {
+			errs = append(errs, fmt.Errorf("%s: timeouts must not be negative", field))
+		}
+		c.Timeouts[a.Name] = timeouts
+		c.instances = append(c.instances, Instance{Name: a.Name, Type: a.Type, Config: acfg})
+	}
+	return errs
+}
+
+// accountConfig derives the configuration a provider is created from for an
+// account: the top-level configuration with the account's credentials, and
+// its mappings applied on top of the top-level ones.
+func (c *Config) accountConfig(a *Account) (*Config, error) {
+	acfg := *c
+	acfg.Accounts = nil
+	acfg.instances = nil
+	switch a.Type {
+	case "azure":
+		acfg.AzureCreds = a.Azure
+	case "aws":
+		acfg.AWSCreds = a.AWS
+		if acfg.AWSCreds.Region == "" {
+			acfg.AWSCreds.Region = c.AWSCreds.Region
+		}
+	case "gcp":
+		acfg.GCPCreds = a.GCP
+	case "hyperv":
+		acfg.HyperVCreds = a.HyperV
+		if acfg.HyperVCreds.Port == 0 {
+			acfg.HyperVCreds.Port = 5985
+		}
+	case "nutanix":
+		acfg.NutanixCreds = a.Nutanix
+	case "proxmox":
+		acfg.ProxmoxCreds = a.Proxmox
+	case "vsphere":
+		acfg.VSphereCreds = a.VSphere
+	}
+
+	// Copy the mappings so the account's aliases don't leak into the top level.
+	data, err := json.Marshal(c.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	acfg.Mappings = CloudMappings{}
+	if err := json.Unmarshal(data, &acfg.Mappings); err != nil {
+		return nil, err
+	}
+	if len(a.Mappings) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(a.Mappings))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&acfg.Mappings); err != nil {
+			return nil, fmt.Errorf("mappings: %v", err)
+		}
+	}
+	return &acfg, nil
+}
+
+// Instances lists the providers to register: every provider type under its
+// own name, which the provider leaves out when it isn't configured, followed
+// by the accounts.
+func (c *Config) Instances() []Instance {
+	return c.instances
+}
+
+// credentialFields returns the required credentials of a provider type by
+// their name in the config file.
+func (c *Config) credentialFields(typ string) map[string]string {
+	switch typ {
+	case "azure":
+		return map[string]string{
+			"tenantId": c.AzureCreds.TenantID, "clientId": c.AzureCreds.ClientID,
+			"clientSecret": c.AzureCreds.ClientSecret, "subscriptionId": c.AzureCreds.SubscriptionID,
+		}
+	case "aws":
+		return map[string]string{"accessKey": c.AWSCreds.AccessKey, "secretKey": c.AWSCreds.SecretKey}
+	case "gcp":
+		return map[string]string{"projectId": c.GCPCreds.ProjectID, "credentialsFile": c.GCPCreds.CredentialsFile}
+	case "hyperv":
+		return map[string]string{"host": c.HyperVCreds.Host, "username": c.HyperVCreds.Username, "password": c.HyperVCreds.Password}
+	case "nutanix":
+		return map[string]string{"apiUrl": c.NutanixCreds.APIURL, "username": c.NutanixCreds.Username, "password": c.NutanixCreds.Password}
+	case "proxmox":
+		return map[string]string{
+			"apiUrl": c.ProxmoxCreds.APIURL, "username": c.ProxmoxCreds.Username,
+			"password": c.ProxmoxCreds.Password, "node": c.ProxmoxCreds.Node,
+		}
+	case "vsphere":
+		return map[string]string{"url": c.VSphereCreds.URL, "username": c.VSphereCreds.Username, "password": c.VSphereCreds.Password}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated environment variable.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// missingFields returns the empty fields, sorted and prefixed with section.
+func missingFields(section string, fields map[string]string) []string {
+	var missing []string
+	for name, value := range fields {
+		if value == "" {
+			missing = append(missing, section+"."+name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// requireAll reports the fields of a provider section that are missing when
+// some, but not all, of them are set.
+func requireAll(errs *[]error, section string, fields map[string]string) {
+	if missing := missingFields(section, fields); len(missing) > 0 && len(missing) < len(fields) {
+		*errs = append(*errs, fmt.Errorf("%s is partially configured, missing %s", section, strings.Join(missing, ", ")))
+	}
+}
+
+// ProviderSettings returns everything the named provider is created from, so
+// a reload can tell which providers need to be re-initialized.
+func (c *Config) ProviderSettings(name string) interface{} {
+	switch name {
+	case "azure":
+		return []interface{}{c.AzureCreds, c.Mappings.Azure, c.ImageAliases(name)}
+	case "aws":
+		return []interface{}{c.AWSCreds, c.Mappings.AWS, c.ImageAliases(name)}
+	case "gcp":
+		return []interface{}{c.GCPCreds, c.Mappings.GCP, c.ImageAliases(name)}
+	case "hyperv":
+		return []interface{}{c.HyperVCreds, c.ImageAliases(name)}
+	case "nutanix":
+		return []interface{}{c.NutanixCreds, c.ImageAliases(name)}
+	case "proxmox":
+		return []interface{}{c.ProxmoxCreds, c.ImageAliases(name)}
+	case "vsphere":
+		return []interface{}{c.VSphereCreds, c.ImageAliases(name)}
+	}
+	return nil
+}
+
+func isProviderName(name string) bool {
+	return contains(ProviderNames, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func setEnv(dst *string, key string) {
+	if value, exists := os.LookupEnv(key); exists {
+		*dst = value
+	}
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeConfig(t, "anyvm.yaml", `
+port: "9090"
+proxmox:
+  apiUrl: https://pve:8006/api2/json
+  username: root@pam
+  password: secret
+  node: pve1
+mappings:
+  aws:
+    customImages:
+      debian12: ami-0123456789abcdef0
+images:
+  rocky9: {proxmox: rocky9-tmpl}
+timeouts:
+  default: {read: 30s}
+  proxmox: {operation: 40m}
+`)
+	t.Setenv("PROXMOX_NODE", "pve2")
+	t.Setenv("HYPERV_TIMEOUT", "10s")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9090" || cfg.ProxmoxCreds.Username != "root@pam" {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.ProxmoxCreds.Node != "pve2" {
+		t.Errorf("PROXMOX_NODE did not override the file, node = %q", cfg.ProxmoxCreds.Node)
+	}
+	if images := cfg.ImageAliases("aws"); images["debian12"] != "ami-0123456789abcdef0" || images["ubuntu24"] == "" {
+		t.Errorf("file aliases should be added to the built-in ones, got %v", images)
+	}
+	if images := cfg.ImageAliases("proxmox"); len(images) != 1 || images["rocky9"] != "rocky9-tmpl" {
+		t.Errorf("proxmox images = %v", images)
+	}
+
+	want := map[string]Timeouts{
+		"proxmox": {Read: 30 * time.Second, Operation: 40 * time.Minute},
+		"hyperv":  {Read: 10 * time.Second, Operation: 15 * time.Minute},
+		"azure":   {Read: 30 * time.Second, Operation: 15 * time.Minute},
+	}
+	for name, timeouts := range want {
+		if cfg.Timeouts[name] != timeouts {
+			t.Errorf("timeouts[%s] = %+v, want %+v", name, cfg.Timeouts[name], timeouts)
+		}
+	}
+	if _, ok := cfg.Timeouts["default"]; ok {
+		t.Error("the default timeouts should be folded into the providers' timeouts")
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	path := writeConfig(t, "anyvm.json", `{"hyperv": {"host": "hv01", "port": 5986, "username": "admin", "password": "secret"}}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HyperVCreds != (HyperVCredentials{Host: "hv01", Port: 5986, Username: "admin", Password: "secret"}) {
+		t.Errorf("unexpected Hyper-V credentials %+v", cfg.HyperVCreds)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"unknown field", "proxmox:\n  nodes: pve1\n", `unknown field "nodes"`},
+		{"partial credentials", "vsphere:\n  url: https://vcenter/sdk\n", "vsphere is partially configured, missing vsphere.password, vsphere.username"},
+		{"bad url", "nutanix: {apiUrl: prism:9440, username: admin, password: secret}\n", `nutanix.apiUrl: "prism:9440" is not an http(s) URL`},
+		{"bad port", "port: \"http\"\n", `port: "http" is not a valid port`},
+		{"bad duration", "timeouts:\n  aws: {read: soon}\n", `invalid duration "soon"`},
+		{"unknown timeout", "timeouts:\n  openstack: {read: 1m}\n", `timeouts: unknown provider "openstack"`},
+		{"all regions and more", "aws:\n  regions: [all, eu-west-1]\n", "aws.regions"},
+		{"issuer without audience", "auth: {issuer: \"https://login.example.com\"}\n", "auth.audiences: at least one audience is required"},
+		{"jwks without issuer", "auth: {jwksUrl: \"https://login.example.com/keys\", audiences: [anyvm]}\n", "auth.issuer: required with auth.jwksUrl"},
+		{"unknown role", "auth:\n  grants: [{role: owner, groups: [ops]}]\n", `auth.grants[0]: unknown role "owner"`},
+		{"action outside role", "auth:\n  grants: [{role: viewer, groups: [ops], actions: [delete]}]\n", `auth.grants[0]: role viewer has no action "delete"`},
+		{"grant without callers", "auth:\n  grants: [{role: admin}]\n", "auth.grants[0]: groups or subjects are required"},
+		{"policy without name", "policies:\n  - {allowedSizes: [small]}\n", "policies[0]: a unique name is required"},
+		{"bad name pattern", "policies:\n  - {name: naming, namePattern: \"web-(\"}\n", "policies[0]: invalid namePattern"},
+		{"unknown image provider", "images:\n  rocky9: {openstack: rocky}\n", `images.rocky9: unknown provider "openstack"`},
+		{"empty alias", "mappings:\n  gcp:\n    customVmSizes: {tiny: \"\"}\n", `mappings.gcp.customVmSizes: alias "tiny" has no value`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, "anyvm.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("AZURE_OPERATION_TIMEOUT", "forever")
+		if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "AZURE_OPERATION_TIMEOUT") {
+			t.Errorf("got error %v, want AZURE_OPERATION_TIMEOUT to be reported", err)
+		}
+	})
+}
+
+func TestProviderSettings(t *testing.T) {
+	old, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VSPHERE_DATACENTER", "DC1")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range ProviderNames {
+		changed := !reflect.DeepEqual(old.ProviderSettings(name), cfg.ProviderSettings(name))
+		if changed != (name == "vsphere") {
+			t.Errorf("%s changed = %v", name, changed)
+		}
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	path := writeConfig(t, "anyvm.yaml", `
+mappings:
+  aws:
+    defaultKeyName: ops
+timeouts:
+  aws: {read: 20s}
+accounts:
+  - name: aws-eu
+    type: aws
+    aws: {accessKey: AKIA1, secretKey: secret1, region: eu-west-1}
+    mappings:
+      aws:
+        customImages: {debian12: ami-0123456789abcdef0}
+    timeouts: {operation: 30m}
+  - name: pve-lab1
+    type: proxmox
+    proxmox: {apiUrl: "https://pve:8006/api2/json", username: root@pam, password: secret, node: pve1}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances := make(map[string]Instance)
+	for _, inst := range cfg.Instances() {
+		instances[inst.Name] = inst
+	}
+	if len(instances) != len(ProviderNames)+2 {
+		t.Fatalf("got %d instances, want one per provider type and account", len(instances))
+	}
+	eu := instances["aws-eu"]
+	if eu.Type != "aws" || eu.Config.AWSCreds.AccessKey != "AKIA1" || eu.Config.AWSCreds.Region != "eu-west-1" {
+		t.Errorf("unexpected aws-eu instance %+v", eu)
+	}
+	if m := eu.Config.Mappings.AWS; m.CustomImages["debian12"] == "" || eu.Config.ImageAliases("aws")["ubuntu24"] == "" || m.DefaultKeyName != "ops" {
+		t.Errorf("account mappings should be applied on top of the top-level ones, got %+v", m)
+	}
+	if cfg.Mappings.AWS.CustomImages["debian12"] != "" {
+		t.Error("account mappings leaked into the top-level mappings")
+	}
+	if got := cfg.Timeouts["aws-eu"]; got != (Timeouts{Read: 20 * time.Second, Operation: 30 * time.Minute}) {
+		t.Errorf("timeouts[aws-eu] = %+v", got)
+	}
+	if lab := instances["pve-lab1"]; lab.Config.ProxmoxCreds.Node != "pve1" || cfg.ProxmoxCreds.Node != "" {
+		t.Errorf("unexpected pve-lab1 instance %+v", lab.Config.ProxmoxCreds)
+	}
+}
+
+func TestLoadInvalidAccounts(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"bad name", "accounts:\n- {name: Azure_Prod, type: azure}\n", "name must be lowercase letters, digits and dashes"},
+		{"reserved name", "accounts:\n- {name: gcp, type: gcp}\n", "name is reserved"},
+		{"duplicate name", "accounts:\n- {name: hv, type: hyperv, hyperv: {host: a, username: u, password: p}}\n- {name: hv, type: hyperv, hyperv: {host: b, username: u, password: p}}\n", "name is used more than once"},
+		{"unknown type", "accounts:\n- {name: os1, type: openstack}\n", "type must be one of"},
+		{"no credentials", "accounts:\n- {name: az1, type: azure}\n", "azure credentials are required"},
+		{"partial credentials", "accounts:\n- {name: az1, type: azure, azure: {tenantId: t}}\n", "azure is partially configured"},
+		{"no resource group", "accounts:\n- {name: az1, type: azure, azure: {tenantId: t, clientId: c, clientSecret: s, subscriptionId: x}}\n", "mappings.azure.defaultResourceGroup is required"},
+		{"other section", "accounts:\n- {name: hv, type: hyperv, hyperv: {host: a, username: u, password: p}, aws: {region: x}}\n", "aws settings are not used by a hyperv account"},
+		{"bad mappings", "accounts:\n- {name: hv, type: hyperv, hyperv: {host: a, username: u, password: p}, mappings: {openstack: {}}}\n", `unknown field "openstack"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, "anyvm.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountErrorsReportedOnce(t *testing.T) {
+	content := "accounts:\n" +
+		"- {name: hv1, type: hyperv, hyperv: {host: a, username: u, password: p}}\n" +
+		"- {name: pve1, type: proxmox, proxmox: {apiUrl: \"ftp://pve\", username: u, password: p, node: n}}\n"
+	_, err := Load(writeConfig(t, "anyvm.yaml", content))
+	if err == nil {
+		t.Fatal("invalid config loaded")
+	}
+	want := `accounts[1] (pve1): proxmox.apiUrl: "ftp://pve" is not an http(s) URL`
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 2 || lines[1] != want {
+		t.Errorf("got error %v, want only %q", err, want)
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch reloads the configuration when the process receives SIGHUP or, when
+// path isn't empty, when the file's size or modification time changes, which
+// is checked every interval. A valid configuration becomes the current one and
+// is passed to onChange together with the one it replaces; an invalid one is
+// reported and ignored. Watch returns when ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func(old, cfg *Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := fileStamp(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			fmt.Printf("Received SIGHUP, reloading configuration\r\n")
+		case <-ticker.C:
+			stamp := fileStamp(path)
+			if path == "" || stamp == last {
+				continue
+			}
+			last = stamp
+			fmt.Printf("Configuration file %s changed, reloading\r\n", path)
+		}
+		// Pick up an edit made between the signal and the next tick only once.
+		last = fileStamp(path)
+
+		cfg, err := Load(path)
+		if err != nil {
+			fmt.Printf("Keeping the current configuration: %v\r\n", err)
+			continue
+		}
+		old := current.Swap(cfg)
+		if old != nil && old.Port != cfg.Port {
+			fmt.Printf("Port changes take effect after a restart\r\n")
+		}
+		onChange(old, cfg)
+	}
+}
+
+// fileStamp identifies the version of a file by size and modification time.
+func fileStamp(path string) string {
+	if path == "" {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano())
+}
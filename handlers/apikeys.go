@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fuddata/anyvm/apikeys"
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKeyRequest is the payload for creating an API key. A key without
+// expiresAt is valid until it is revoked.
+type CreateAPIKeyRequest struct {
+	Name      string               `json:"name"`
+	Scopes    []models.APIKeyScope `json:"scopes"`
+	ExpiresAt *time.Time           `json:"expiresAt,omitempty"`
+}
+
+// CreateAPIKeyHandler creates an API key. The response is HTTP 201 with the
+// key, which is the only time it is returned.
+func CreateAPIKeyHandler(keys *apikeys.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		if err := validateAPIKey(req, time.Now()); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var createdBy string
+		if id := middleware.IdentityFrom(r.Context()); id != nil {
+			createdBy = id.Subject
+		}
+		key, err := keys.Create(req.Name, createdBy, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, models.APIResponse{
+			Success: true,
+			Data:    key,
+		})
+	}
+}
+
+// validateAPIKey checks a create request: it needs a name, at least one valid
+// scope and an expiry in the future, if any.
+func validateAPIKey(req CreateAPIKeyRequest, now time.Time) error {
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for i, scope := range req.Scopes {
+		if err := config.ValidateScope(scope.Role, scope.Providers, scope.Actions); err != nil {
+			return fmt.Errorf("scopes[%d]: %v", i, err)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return errors.New("expiresAt must be in the future")
+	}
+	return nil
+}
+
+// ListAPIKeysHandler lists every API key with its scopes, expiry and last use,
+// but not the key itself.
+func ListAPIKeysHandler(keys *apikeys.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    keys.List(),
+		})
+	}
+}
+
+// RevokeAPIKeyHandler revokes an API key. Requests with it are rejected right
+// away.
+func RevokeAPIKeyHandler(keys *apikeys.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		key, err := keys.Revoke(mux.Vars(r)["id"])
+		if errors.Is(err, apikeys.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    key,
+		})
+	}
+}
+
+// authorizeAdmin answers the request with HTTP 403 and returns false unless
+// the caller is admin on every provider.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if middleware.PermissionsFrom(r.Context()).Admin() {
+		return true
+	}
+	writeError(w, http.StatusForbidden, "The admin role on every provider is required")
+	return false
+}
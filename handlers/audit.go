@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fuddata/anyvm/audit"
+	"github.com/fuddata/anyvm/models"
+)
+
+// defaultAuditLimit is the number of entries returned without ?limit=.
+const defaultAuditLimit = 100
+
+// ListAuditHandler returns the newest audit entries, newest first. The
+// subject, action, provider, vmId, operationId, since and until parameters
+// filter them; limit bounds their number.
+func ListAuditHandler(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		query := r.URL.Query()
+		filter, err := parseAuditFilter(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit := defaultAuditLimit
+		if v := query.Get("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 1 || limit > maxPageSize {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", maxPageSize))
+				return
+			}
+		}
+
+		entries, err := log.Query(filter, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if entries == nil {
+			entries = []models.AuditEntry{}
+		}
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    entries,
+		})
+	}
+}
+
+func parseAuditFilter(query url.Values) (models.AuditFilter, error) {
+	f := models.AuditFilter{
+		Subject:     query.Get("subject"),
+		Action:      query.Get("action"),
+		Provider:    query.Get("provider"),
+		VMID:        query.Get("vmId"),
+		OperationID: query.Get("operationId"),
+	}
+	for param, dst := range map[string]**time.Time{"since": &f.Since, "until": &f.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := parseTime(param, value)
+			if err != nil {
+				return f, err
+			}
+			*dst = &t
+		}
+	}
+	return f, nil
+}
+
+// VerifyAuditHandler checks the hash chain of the audit log and reports the
+// first entry that was changed, inserted or removed.
+func VerifyAuditHandler(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    log.Verify(),
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+)
+
+// ListImagesHandler lists the logical images of the catalog with their
+// reference in one provider (?provider=) or every provider the caller may
+// read. References are resolved to the native image
+// they currently stand for unless resolve=false is passed.
+func ListImagesHandler(cm *providers.CloudManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names, ok := listProviders(w, r, cm)
+		if !ok {
+			return
+		}
+		resolve := true
+		if v := r.URL.Query().Get("resolve"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid resolve parameter")
+				return
+			}
+			resolve = b
+		}
+
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    cm.ListImages(r.Context(), resolve, names...),
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
+
+	"github.com/gorilla/mux"
+)
+
+// writeAccepted answers a request that started an operation with HTTP 202 and
+// the operation, whose URL is also given in the Location header.
+func writeAccepted(w http.ResponseWriter, op models.Operation) {
+	w.Header().Set("Location", "/api/v1/operations/"+op.ID)
+	writeJSON(w, http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data:    op,
+	})
+}
+
+// GetOperationHandler reports the status, progress and result of an operation.
+func GetOperationHandler(ops *operations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, ok := ops.Get(mux.Vars(r)["id"])
+		if !ok {
+			writeError(w, http.StatusNotFound, "Operation not found")
+			return
+		}
+		if !authorize(w, r, config.ActionRead, op.Provider) {
+			return
+		}
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: op.Status != models.OperationFailed,
+			Data:    op,
+			Error:   op.Error,
+		})
+	}
+}
+
+// CancelOperationHandler cancels a running operation. AnyVM stops waiting for
+// the provider right away; whether the provider's own task is stopped as well
+// depends on the provider (Proxmox and vSphere tasks are).
+func CancelOperationHandler(ops *operations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, ok := ops.Get(mux.Vars(r)["id"])
+		if !ok {
+			writeError(w, http.StatusNotFound, "Operation not found")
+			return
+		}
+		if !authorize(w, r, operationAction(op.Type), op.Provider) {
+			return
+		}
+		if op.Status != models.OperationRunning {
+			writeError(w, http.StatusConflict, "Operation has already finished")
+			return
+		}
+		op, _ = ops.Cancel(op.ID)
+		writeJSON(w, http.StatusAccepted, models.APIResponse{
+			Success: true,
+			Data:    op,
+		})
+	}
+}
+
+// operationAction returns the action needed to start, and so to cancel, an
+// operation of type typ.
+func operationAction(typ string) string {
+	switch typ {
+	case "create":
+		return config.ActionCreate
+	case "delete":
+		return config.ActionDelete
+	}
+	return config.ActionPower
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+)
+
+// MyPermissionsHandler returns the roles of the caller and the actions it may
+// take on each registered provider.
+func MyPermissionsHandler(cm *providers.CloudManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		perms := middleware.PermissionsFrom(r.Context())
+		resp := models.Permissions{Roles: []string{}, Providers: make(map[string][]string)}
+		if id := middleware.IdentityFrom(r.Context()); id != nil {
+			resp.Subject, resp.Name = id.Subject, id.Name
+		}
+		if perms != nil {
+			resp.Roles = append(resp.Roles, perms.Roles...)
+		}
+		for name := range cm.GetAllProviders() {
+			if actions := perms.Actions(name); len(actions) > 0 {
+				resp.Providers[name] = actions
+			}
+		}
+
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    resp,
+		})
+	}
+}
+
+// authorize answers the request with HTTP 403 and returns false unless the
+// caller may take action on the VMs of provider.
+func authorize(w http.ResponseWriter, r *http.Request, action, provider string) bool {
+	if middleware.PermissionsFrom(r.Context()).Allows(action, provider) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, fmt.Sprintf("Permission %q is required on provider %s", action, provider))
+	return false
+}
+
+// listProviders returns the providers a list request is for: the one given
+// in ?provider=, or every registered provider the caller may read. It answers
+// the request and returns false if the caller may read none of them.
+func listProviders(w http.ResponseWriter, r *http.Request, cm *providers.CloudManager) ([]string, bool) {
+	if provider := r.URL.Query().Get("provider"); provider != "" {
+		provider = strings.ToLower(provider)
+		if cm.GetProvider(provider) == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
+			return nil, false
+		}
+		return []string{provider}, authorize(w, r, config.ActionRead, provider)
+	}
+
+	perms := middleware.PermissionsFrom(r.Context())
+	registered := cm.GetAllProviders()
+	var names []string
+	for name := range registered {
+		if perms.Allows(config.ActionRead, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 && len(registered) > 0 {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("Permission %q is required on at least one provider", config.ActionRead))
+		return nil, false
+	}
+	sort.Strings(names)
+	return names, true
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+)
+
+// stubProvider is never called: the requests of the test are rejected or
+// answered before they reach a provider.
+type stubProvider struct{ providers.CloudProvider }
+
+func TestPermissions(t *testing.T) {
+	cm := providers.NewCloudManager(nil)
+	for _, name := range []string{"azure-dev", "aws", "proxmox"} {
+		cm.RegisterProvider(name, name, stubProvider{})
+	}
+	cfg := &config.Config{JWTSecret: "s3cret", Auth: config.AuthConfig{
+		GroupsClaim: "groups",
+		Grants: []config.Grant{
+			{Role: "operator", Groups: []string{"team-x"}, Providers: []string{"azure-dev", "proxmox"}, Actions: []string{"create", "power"}},
+		},
+	}}
+	auth := middleware.NewAuthenticator(func() *config.Config { return cfg }, nil)
+	r := mux.NewRouter().SkipClean(true)
+	r.Use(auth.Middleware)
+	r.HandleFunc("/me/permissions", MyPermissionsHandler(cm)).Methods("GET")
+	r.HandleFunc("/vms/{provider}/{id:.+}", GetVMHandler(cm)).Methods("GET")
+	r.HandleFunc("/vms/{provider}/{id:.+}", DeleteVMHandler(cm, nil)).Methods("DELETE")
+	r.HandleFunc("/vms", ListVMsHandler(cm)).Methods("GET")
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "u1", "name": "Team X", "groups": []string{"team-x"}, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("GET", "/me/permissions")
+	var resp struct {
+		Data models.Permissions `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := models.Permissions{
+		Subject: "u1",
+		Name:    "Team X",
+		Roles:   []string{"operator"},
+		Providers: map[string][]string{
+			"azure-dev": {"read", "create", "power"},
+			"proxmox":   {"read", "create", "power"},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, want) {
+		t.Errorf("permissions %+v, want %+v", resp.Data, want)
+	}
+
+	for _, tt := range []struct{ method, target string }{
+		{"GET", "/vms/aws/i-1"},
+		{"GET", "/vms?provider=aws"},
+		{"DELETE", "/vms/proxmox/100"},
+	} {
+		if rec := do(tt.method, tt.target); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want 403", tt.method, tt.target, rec.Code)
+		}
+	}
+}
+
+// tagStub records the tag changes it is asked for.
+type tagStub struct {
+	stubProvider
+	set map[string]string
+}
+
+func (s *tagStub) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	s.set = set
+	return nil
+}
+
+func (s *tagStub) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	return &models.VM{ID: id, Tags: s.set}, nil
+}
+
+func TestOwnerTagAdminOnly(t *testing.T) {
+	cm := providers.NewCloudManager(nil)
+	stub := &tagStub{}
+	cm.RegisterProvider("aws", "aws", stub)
+	cfg := &config.Config{JWTSecret: "s3cret", Auth: config.AuthConfig{RolesClaim: "roles"}}
+	auth := middleware.NewAuthenticator(func() *config.Config { return cfg }, nil)
+	r := mux.NewRouter().SkipClean(true)
+	r.Use(auth.Middleware)
+	r.HandleFunc("/vms/{provider}/{id:.+}/tags", UpdateTagsHandler(cm)).Methods("PATCH")
+
+	patch := func(role, body string) int {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": "u1", "roles": []string{role}, "exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("s3cret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("PATCH", "/vms/aws/i-1/tags", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := patch("operator", `{"team": "x"}`); code != http.StatusOK {
+		t.Errorf("operator setting a tag: status %d", code)
+	}
+	for _, body := range []string{`{"anyvm-owner": "bob"}`, `{"Anyvm-Owner": null}`} {
+		if code := patch("operator", body); code != http.StatusForbidden {
+			t.Errorf("operator patching %s: status %d, want 403", body, code)
+		}
+	}
+	stub.set = nil
+	if code := patch("admin", `{"anyvm-owner": "bob"}`); code != http.StatusOK || stub.set["anyvm-owner"] != "bob" {
+		t.Errorf("admin setting the owner tag: status %d, tags %v", code, stub.set)
+	}
+}
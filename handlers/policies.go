@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/policy"
+	"github.com/fuddata/anyvm/providers"
+)
+
+// EvaluatePoliciesHandler checks a create request against the policies of
+// the configuration read with current and returns the result without
+// creating anything.
+func EvaluatePoliciesHandler(cm *providers.CloudManager, current func() *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateVMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		provider := strings.ToLower(req.Provider)
+		if cm.GetProvider(provider) == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
+			return
+		}
+		if !authorize(w, r, config.ActionRead, provider) {
+			return
+		}
+		spec := req.Spec()
+		if !checkSpec(w, cm, provider, spec) {
+			return
+		}
+		result := policy.Evaluate(r.Context(), cm, current().Policies, provider, &spec, caller(r))
+
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+// enforcePolicies checks a create request against policies and answers it
+// with HTTP 403 listing every violated rule if it isn't allowed. spec is
+// changed as the policies demand.
+func enforcePolicies(w http.ResponseWriter, r *http.Request, cm *providers.CloudManager, policies []config.Policy, provider string, spec *models.VMSpec) bool {
+	result := policy.Evaluate(r.Context(), cm, policies, provider, spec, caller(r))
+	if result.Allowed {
+		return true
+	}
+	writeJSON(w, http.StatusForbidden, models.APIResponse{
+		Success: false,
+		Error:   fmt.Sprintf("Request violates %d policy rules", len(result.Violations)),
+		Data:    result,
+	})
+	return false
+}
+
+// caller returns who makes the request for the policies, or nil when
+// authentication is disabled.
+func caller(r *http.Request) *policy.Caller {
+	id := middleware.IdentityFrom(r.Context())
+	if id == nil {
+		return nil
+	}
+	return &policy.Caller{Subject: id.Subject, Name: id.Name, Groups: id.Groups}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+)
+
+func writeJSON(w http.ResponseWriter, status int, resp models.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, models.APIResponse{Success: false, Error: msg})
+}
+
+// writeProviderError maps errors returned by providers to HTTP status codes.
+func writeProviderError(w http.ResponseWriter, err error) {
+	var notSupported *providers.NotSupportedError
+	if errors.As(err, &notSupported) {
+		writeError(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+)
+
+// ListSizesHandler lists the sizes of one provider (?provider=) or of all of
+// them the caller may read, smallest first. Clouds list the sizes of
+// ?region=, by default of the region VMs are created in; providers without
+// fixed sizes list the names they accept as size. With cpu, memoryGiB or arch only the sizes meeting
+// these requirements are returned (see CloudManager.ListSizes).
+func ListSizesHandler(cm *providers.CloudManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		names, ok := listProviders(w, r, cm)
+		if !ok {
+			return
+		}
+		req, err := parseRequirements(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    cm.ListSizes(r.Context(), query.Get("region"), req, names...),
+		})
+	}
+}
+
+// parseRequirements reads the cpu, memoryGiB and arch parameters. It returns
+// nil when none of them is given.
+func parseRequirements(query url.Values) (*models.SizeRequirements, error) {
+	if query.Get("cpu") == "" && query.Get("memoryGiB") == "" && query.Get("arch") == "" {
+		return nil, nil
+	}
+	req := &models.SizeRequirements{Arch: query.Get("arch")}
+	if v := query.Get("cpu"); v != "" {
+		cpu, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu %q", v)
+		}
+		req.CPU = cpu
+	}
+	if v := query.Get("memoryGiB"); v != "" {
+		memory, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memoryGiB %q", v)
+		}
+		req.MemoryGiB = memory
+	}
+	return req, validateRequirements(req)
+}
+
+// validateRequirements rejects negative resources and unknown architectures.
+func validateRequirements(req *models.SizeRequirements) error {
+	switch {
+	case req.CPU < 0:
+		return fmt.Errorf("cpu must not be negative")
+	case req.MemoryGiB < 0:
+		return fmt.Errorf("memoryGiB must not be negative")
+	case req.Arch != "" && req.Arch != "x86_64" && req.Arch != "arm64":
+		return fmt.Errorf("arch must be x86_64 or arm64")
+	}
+	return nil
+}
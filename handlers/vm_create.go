@@ -1,94 +1,133 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
 
 	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 	"github.com/fuddata/anyvm/providers"
-	// Azure SDK helpers
-	// AWS SDK
-	// GCP SDK
 )
 
-// CreateVMRequest defines the unified request payload for creating a VM.
+// CreateVMRequest defines the unified request payload for creating a VM. The
+// embedded VMSpec holds the provider-neutral fields; the provider-specific
+// fields below are kept for compatibility and fill in the spec when it leaves
+// them empty.
 type CreateVMRequest struct {
 	Provider string `json:"provider"`
 	VMName   string `json:"vmName"`
+	models.VMSpec
 
 	// Azure-specific fields
 	ResourceGroupName string `json:"resourceGroupName,omitempty"`
 	Location          string `json:"location,omitempty"`
 	VMSize            string `json:"vmSize,omitempty"`
-	AdminUsername     string `json:"adminUsername,omitempty"`
-	AdminPassword     string `json:"adminPassword,omitempty"`
 	NICID             string `json:"nicId,omitempty"`
-	// (Optionally, you can allow specifying an image key)
 
 	// AWS-specific fields
-	ImageID          string   `json:"imageId,omitempty"`
-	InstanceType     string   `json:"instanceType,omitempty"`
-	KeyName          string   `json:"keyName,omitempty"`
-	SecurityGroupIDs []string `json:"securityGroupIds,omitempty"`
+	ImageID      string `json:"imageId,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"`
 
 	// GCP-specific fields
-	ProjectID   string `json:"projectId,omitempty"`
 	Zone        string `json:"zone,omitempty"`
 	MachineType string `json:"machineType,omitempty"`
 	SourceImage string `json:"sourceImage,omitempty"`
 }
 
-// CreateVMHandler handles VM creation requests for Azure, AWS, and GCP.
-// It uses unified mappings from the configuration to convert custom identifiers
-// to the actual cloud-specific values.
-func CreateVMHandler(cm *providers.CloudManager, cfg *config.Config) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
+// Spec returns the provider-neutral spec described by the request.
+func (req CreateVMRequest) Spec() models.VMSpec {
+	spec := req.VMSpec
+	spec.Name = firstNonEmpty(spec.Name, req.VMName)
+	spec.Region = firstNonEmpty(spec.Region, req.Location, req.Zone)
+	spec.Size = firstNonEmpty(spec.Size, req.VMSize, req.InstanceType, req.MachineType)
+	spec.Image = firstNonEmpty(spec.Image, req.ImageID, req.SourceImage)
+	spec.Network = firstNonEmpty(spec.Network, req.NICID)
+	spec.ResourceGroup = firstNonEmpty(spec.ResourceGroup, req.ResourceGroupName)
+	return spec
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
 
+// CreateVMHandler handles VM creation requests. Creation is dispatched to the
+// provider through the CloudManager and runs as an operation; the response is
+// HTTP 202 with the operation. Providers that can't create VMs answer with
+// HTTP 501. Requests that violate the policies of the configuration read with
+// current are rejected with HTTP 403 before the provider is called.
+func CreateVMHandler(cm *providers.CloudManager, ops *operations.Manager, current func() *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		var req CreateVMRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.APIResponse{
-				Success: false,
-				Error:   "Invalid request payload",
-			})
+			writeError(w, http.StatusBadRequest, "Invalid request payload")
 			return
 		}
 
 		provider := strings.ToLower(req.Provider)
-		ctx := r.Context()
-		var err error
-
-		switch provider {
-		case "azure":
-			err = createAzureVM(ctx, req, cm, cfg)
-		case "aws":
-			err = createAWSVM(ctx, req, cm, cfg)
-		case "gcp":
-			err = createGCPVM(ctx, req, cm, cfg)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.APIResponse{
-				Success: false,
-				Error:   "VM creation is only supported for Azure, AWS, and GCP",
-			})
+		p := cm.GetProvider(provider)
+		if p == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
 			return
 		}
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.APIResponse{
-				Success: false,
-				Error:   err.Error(),
-			})
+		if !authorize(w, r, config.ActionCreate, provider) {
+			return
+		}
+		if _, ok := p.(providers.VMCreator); !ok {
+			writeProviderError(w, &providers.NotSupportedError{Provider: provider, Operation: "VM creation"})
+			return
+		}
+		spec := req.Spec()
+		if !checkSpec(w, cm, provider, spec) {
+			return
+		}
+		for key := range spec.Tags {
+			if !authorizeOwnerTag(w, r, key) {
+				return
+			}
+		}
+		if !enforcePolicies(w, r, cm, current().Policies, provider, &spec) {
 			return
 		}
 
-		json.NewEncoder(w).Encode(models.APIResponse{
-			Success: true,
-			Data:    "VM creation initiated successfully",
+		op := ops.Start("create", provider, "", func(ctx context.Context) (interface{}, error) {
+			ctx, cancel := cm.OperationContext(ctx, provider)
+			defer cancel()
+			return cm.CreateVM(ctx, provider, spec)
 		})
+		writeAccepted(w, op)
+	}
+}
+
+// checkSpec answers the request with HTTP 400 and returns false if spec can't
+// be created with the named provider.
+func checkSpec(w http.ResponseWriter, cm *providers.CloudManager, provider string, spec models.VMSpec) bool {
+	if spec.Name == "" {
+		writeError(w, http.StatusBadRequest, "VM name is required")
+		return false
+	}
+	if spec.Requirements != nil {
+		if spec.Size != "" {
+			writeError(w, http.StatusBadRequest, "Size and requirements can't be given together")
+			return false
+		}
+		if err := validateRequirements(spec.Requirements); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+	}
+	if len(spec.Tags) > 0 {
+		if err := providers.ValidateTags(cm.ProviderType(provider), spec.Tags); err != nil {
+			writeTagError(w, err)
+			return false
+		}
 	}
+	return true
 }
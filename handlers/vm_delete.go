@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/operations"
+	"github.com/fuddata/anyvm/providers"
+
+	"github.com/gorilla/mux"
+)
+
+// DeleteVMHandler deletes a VM. With ?cascade=true its disks, NICs and public
+// IPs are removed as well. The deletion runs as an operation whose result
+// lists every resource that was removed or failed to be removed.
+func DeleteVMHandler(cm *providers.CloudManager, ops *operations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		name := strings.ToLower(vars["provider"])
+		p := cm.GetProvider(name)
+		if p == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
+			return
+		}
+		if !authorize(w, r, config.ActionDelete, name) {
+			return
+		}
+
+		id := vars["id"]
+		cascade := r.URL.Query().Get("cascade") == "true"
+		op := ops.Start("delete", name, id, func(ctx context.Context) (interface{}, error) {
+			ctx, cancel := cm.OperationContext(ctx, name)
+			defer cancel()
+			return p.DeleteVM(ctx, id, cascade)
+		})
+		writeAccepted(w, op)
+	}
+}
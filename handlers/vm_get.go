@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+
+	"github.com/gorilla/mux"
+)
+
+// GetVMHandler returns the details of a single VM.
+func GetVMHandler(cm *providers.CloudManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		name := strings.ToLower(vars["provider"])
+		if cm.GetProvider(name) == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
+			return
+		}
+		if !authorize(w, r, config.ActionRead, name) {
+			return
+		}
+
+		ctx, cancel := cm.ReadContext(r.Context(), name)
+		defer cancel()
+		vm, err := cm.GetVM(ctx, name, vars["id"])
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    vm,
+		})
+	}
+}
@@ -1,54 +1,261 @@
 package handlers
 
 import (
-	"encoding/json"
+	"encoding/base64"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fuddata/anyvm/models"
 	"github.com/fuddata/anyvm/providers"
 )
 
+// maxPageSize bounds the limit parameter of the list endpoint.
+const maxPageSize = 1000
+
+// ListVMsHandler lists VMs from one provider (?provider=) or from all of them
+// the caller may read. Providers are queried concurrently and the response
+// reports per provider whether it succeeded, how many VMs it returned and how
+// long it took.
+//
+// The status, region, name, nameRegex, size, tag, createdAfter and
+// createdBefore parameters filter the VMs (see parseFilter). VMs are sorted by
+// account and ID, or by the field given in ?sort= (descending with a leading
+// "-"). With ?limit= at most that many are returned together with a
+// nextCursor, which is passed as ?cursor= to get the next page.
 func ListVMsHandler(cm *providers.CloudManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-
-		provider := r.URL.Query().Get("provider")
-		var vms []models.VM
-
-		if provider != "" {
-			p := cm.GetProvider(strings.ToLower(provider))
-			if p == nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(models.APIResponse{
-					Success: false,
-					Error:   "Invalid provider specified",
-				})
-				return
-			}
-			result, err := p.ListVMs()
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(models.APIResponse{
-					Success: false,
-					Error:   err.Error(),
-				})
-				return
-			}
-			vms = result
-		} else {
-			for _, p := range cm.GetAllProviders() {
-				result, err := p.ListVMs()
-				if err != nil {
-					continue // In production, log and handle errors
-				}
-				vms = append(vms, result...)
+		query := r.URL.Query()
+		names, ok := listProviders(w, r, cm)
+		if !ok {
+			return
+		}
+		filter, err := parseFilter(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		order, err := parseSort(query.Get("sort"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, after, err := parsePage(order, query.Get("limit"), query.Get("cursor"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		vms, results := cm.ListAllVMs(r.Context(), filter, names...)
+
+		// Only fail the request when no provider could answer at all.
+		failed := 0
+		var errs []string
+		for _, res := range results {
+			if !res.Success {
+				failed++
+				errs = append(errs, res.Provider+": "+res.Error)
 			}
 		}
+		if len(results) > 0 && failed == len(results) {
+			writeJSON(w, http.StatusBadGateway, models.APIResponse{
+				Success:   false,
+				Error:     strings.Join(errs, "; "),
+				Providers: results,
+			})
+			return
+		}
 
-		json.NewEncoder(w).Encode(models.APIResponse{
-			Success: true,
-			Data:    vms,
+		total := len(vms)
+		page, next := paginate(vms, order, limit, after)
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success:    true,
+			Data:       page,
+			Providers:  results,
+			Total:      &total,
+			NextCursor: next,
 		})
 	}
 }
+
+// parseFilter reads the list filter from the query:
+//
+//	status=running,stopped             one of the normalized statuses
+//	region=eu-west-3                   region prefix, so zones of the region match too
+//	name=web-*                         case-insensitive glob
+//	nameRegex=^web-\d+$                regular expression
+//	size=t3.micro                      native size name
+//	tag=env=prod, tag=owner            tag value, or only the key; repeatable
+//	createdAfter=2025-01-01            RFC 3339 time or date
+//	createdBefore=2025-06-30T12:00:00Z
+func parseFilter(query url.Values) (models.VMFilter, error) {
+	var f models.VMFilter
+	if statuses := query.Get("status"); statuses != "" {
+		for _, s := range strings.Split(statuses, ",") {
+			status := models.VMStatus(strings.ToLower(strings.TrimSpace(s)))
+			switch status {
+			case models.StatusRunning, models.StatusStopped, models.StatusDeallocated, models.StatusStarting,
+				models.StatusStopping, models.StatusSuspended, models.StatusTerminated, models.StatusUnknown:
+				f.Statuses = append(f.Statuses, status)
+			default:
+				return f, fmt.Errorf("unknown status %q", s)
+			}
+		}
+	}
+	f.Region = query.Get("region")
+	f.Name = query.Get("name")
+	if _, err := path.Match(f.Name, ""); err != nil {
+		return f, fmt.Errorf("invalid name pattern %q", f.Name)
+	}
+	if expr := query.Get("nameRegex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, fmt.Errorf("invalid nameRegex: %v", err)
+		}
+		f.NameRegex = re
+	}
+	f.Size = query.Get("size")
+	f.ResourceGroup = query.Get("resourceGroup")
+	for _, tag := range query["tag"] {
+		key, value, _ := strings.Cut(tag, "=")
+		if key == "" {
+			return f, fmt.Errorf("invalid tag filter %q", tag)
+		}
+		if f.Tags == nil {
+			f.Tags = make(map[string]string)
+		}
+		f.Tags[key] = value
+	}
+	for param, dst := range map[string]**time.Time{"createdAfter": &f.CreatedAfter, "createdBefore": &f.CreatedBefore} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTime(param, value)
+		if err != nil {
+			return f, err
+		}
+		*dst = &t
+	}
+	return f, nil
+}
+
+// parseTime reads a time parameter given as RFC 3339 time or date.
+func parseTime(param, value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, value); err != nil {
+			return t, fmt.Errorf("%s: %q is not an RFC 3339 time or date", param, value)
+		}
+	}
+	return t, nil
+}
+
+// vmSortFields give for each sortable field a string whose byte order is the
+// order of the field's values.
+var vmSortFields = map[string]func(vm models.VM) string{
+	"id":       func(vm models.VM) string { return vm.ID },
+	"name":     func(vm models.VM) string { return strings.ToLower(vm.Name) },
+	"provider": func(vm models.VM) string { return vm.Provider },
+	"account":  func(vm models.VM) string { return vm.Account },
+	"region":   func(vm models.VM) string { return strings.ToLower(vm.Region) },
+	"status":   func(vm models.VM) string { return string(vm.Status) },
+	"size":     func(vm models.VM) string { return strings.ToLower(vm.Size) },
+	"cpus":     func(vm models.VM) string { return fmt.Sprintf("%020d", vm.CPUs) },
+	"memoryMb": func(vm models.VM) string { return fmt.Sprintf("%020d", vm.MemoryMB) },
+	"image":    func(vm models.VM) string { return vm.Image },
+	"osType":   func(vm models.VM) string { return vm.OSType },
+	"createdAt": func(vm models.VM) string {
+		if vm.CreatedAt == nil {
+			return ""
+		}
+		return vm.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	},
+}
+
+// vmOrder is the order of a VM list. VMs with the same value are ordered by
+// account and ID, which identify a VM, so the order is total and a cursor
+// stays valid when VMs are added or removed between pages.
+type vmOrder struct {
+	spec  string // the sort parameter
+	value func(vm models.VM) string
+	desc  bool
+}
+
+// parseSort parses a sort parameter: a field of the VM, "tags.<key>" or
+// empty for account and ID, optionally prefixed with "-" for descending order.
+func parseSort(spec string) (vmOrder, error) {
+	order := vmOrder{spec: spec, value: func(models.VM) string { return "" }}
+	field := strings.TrimPrefix(spec, "-")
+	order.desc = field != spec
+	switch {
+	case spec == "":
+	case strings.HasPrefix(field, "tags.") && len(field) > len("tags."):
+		key := field[len("tags."):]
+		order.value = func(vm models.VM) string { return vm.Tags[key] }
+	case vmSortFields[field] != nil:
+		order.value = vmSortFields[field]
+	default:
+		return order, fmt.Errorf("cannot sort by %q", spec)
+	}
+	return order, nil
+}
+
+// key returns the sort key of vm.
+func (o vmOrder) key(vm models.VM) string {
+	return o.value(vm) + "\x00" + vm.Account + "\x00" + vm.ID
+}
+
+// before reports whether sort key a comes before sort key b.
+func (o vmOrder) before(a, b string) bool {
+	if o.desc {
+		return a > b
+	}
+	return a < b
+}
+
+// parsePage validates the limit and cursor parameters. A limit of 0 means no
+// limit; the cursor is decoded to the sort key of the last VM already returned.
+func parsePage(order vmOrder, limitParam, cursor string) (limit int, after string, err error) {
+	if limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, "", fmt.Errorf("limit must be a number between 1 and %d", maxPageSize)
+		}
+	}
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		spec, key, found := strings.Cut(string(decoded), "\x00")
+		if err != nil || !found || strings.Count(key, "\x00") != 2 {
+			return 0, "", fmt.Errorf("invalid cursor")
+		}
+		if spec != order.spec {
+			return 0, "", fmt.Errorf("the cursor belongs to a list sorted by %q", spec)
+		}
+		after = key
+	}
+	return limit, after, nil
+}
+
+// paginate sorts vms and returns the ones after the key after, at most limit
+// of them, and the cursor of the next page if there is one.
+func paginate(vms []models.VM, order vmOrder, limit int, after string) ([]models.VM, string) {
+	sort.Slice(vms, func(i, j int) bool { return order.before(order.key(vms[i]), order.key(vms[j])) })
+	if after != "" {
+		vms = vms[sort.Search(len(vms), func(i int) bool { return order.before(after, order.key(vms[i])) }):]
+	}
+	if vms == nil {
+		vms = []models.VM{}
+	}
+	if limit == 0 || len(vms) <= limit {
+		return vms, ""
+	}
+	vms = vms[:limit]
+	return vms, base64.RawURLEncoding.EncodeToString([]byte(order.spec + "\x00" + order.key(vms[limit-1])))
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+func TestPaginate(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	vms := []models.VM{
+		{Account: "proxmox", ID: "101", CPUs: 8},
+		{Account: "aws", ID: "i-2", CPUs: 2, CreatedAt: day(3)},
+		{Account: "proxmox", ID: "100", CPUs: 16},
+		{Account: "aws", ID: "i-1", CPUs: 2, CreatedAt: day(1)},
+		{Account: "aws-eu", ID: "i-1", CPUs: 4, CreatedAt: day(2)},
+	}
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", []string{"aws/i-1", "aws/i-2", "aws-eu/i-1", "proxmox/100", "proxmox/101"}},
+		{"cpus", []string{"aws/i-1", "aws/i-2", "aws-eu/i-1", "proxmox/101", "proxmox/100"}},
+		{"-createdAt", []string{"aws/i-2", "aws-eu/i-1", "aws/i-1", "proxmox/101", "proxmox/100"}},
+	}
+	for _, tt := range tests {
+		order, err := parseSort(tt.sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			limit, after, err := parsePage(order, "2", cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, next := paginate(append([]models.VM(nil), vms...), order, limit, after)
+			for _, vm := range page {
+				got = append(got, vm.Account+"/"+vm.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("sort %q: got %v, want %v", tt.sort, got, tt.want)
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Fatalf("sort %q: got %v, want %v", tt.sort, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestParsePageInvalid(t *testing.T) {
+	order, _ := parseSort("")
+	byName, _ := parseSort("name")
+	_, nameCursor := paginate([]models.VM{{ID: "1"}, {ID: "2"}}, byName, 1, "")
+	for _, tt := range []struct{ limit, cursor string }{
+		{"0", ""}, {"-1", ""}, {"1001", ""}, {"ten", ""}, {"", "not base64!"}, {"", "YXdz"}, {"", nameCursor},
+	} {
+		if _, _, err := parsePage(order, tt.limit, tt.cursor); err == nil {
+			t.Errorf("limit %q, cursor %q was accepted", tt.limit, tt.cursor)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	query, _ := url.ParseQuery("status=running,Stopped&region=eu-west-3&name=WEB-*&size=t3.micro&tag=env=prod&tag=owner&createdAfter=2025-01-01")
+	f, err := parseFilter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	vm := models.VM{
+		Name: "web-01", Region: "eu-west-3a", Status: models.StatusStopped, Size: "t3.micro",
+		Tags: map[string]string{"env": "prod", "owner": "ops"}, CreatedAt: &created,
+	}
+	if !f.Match(vm) {
+		t.Errorf("%+v does not match %+v", vm, f)
+	}
+	vm.Tags["env"] = "dev"
+	if f.Match(vm) {
+		t.Error("tag value was ignored")
+	}
+
+	for _, q := range []string{"status=off", "name=[", "nameRegex=(", "tag==x", "createdBefore=yesterday"} {
+		query, _ := url.ParseQuery(q)
+		if _, err := parseFilter(query); err == nil {
+			t.Errorf("%s was accepted", q)
+		}
+	}
+	for _, spec := range []string{"color", "tags.", "-"} {
+		if _, err := parseSort(spec); err == nil {
+			t.Errorf("sort %q was accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/operations"
+	"github.com/fuddata/anyvm/providers"
+
+	"github.com/gorilla/mux"
+)
+
+// PowerVMHandler handles start/stop/restart/suspend requests for a single VM.
+// Stop and restart are graceful unless the request has ?force=true. The
+// action runs as an operation; actions the provider doesn't support are
+// refused with HTTP 501 before it is started.
+func PowerVMHandler(cm *providers.CloudManager, ops *operations.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		name := strings.ToLower(vars["provider"])
+		p := cm.GetProvider(name)
+		if p == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
+			return
+		}
+		if !authorize(w, r, config.ActionPower, name) {
+			return
+		}
+
+		id := vars["id"]
+		force := r.URL.Query().Get("force") == "true"
+
+		var action func(ctx context.Context) error
+		switch vars["action"] {
+		case "start":
+			action = func(ctx context.Context) error { return p.StartVM(ctx, id) }
+		case "stop":
+			action = func(ctx context.Context) error { return p.StopVM(ctx, id, force) }
+		case "restart":
+			action = func(ctx context.Context) error { return p.RestartVM(ctx, id, force) }
+		case "suspend":
+			action = func(ctx context.Context) error { return p.SuspendVM(ctx, id) }
+		default:
+			writeError(w, http.StatusBadRequest, "Invalid action specified")
+			return
+		}
+		if checker, ok := p.(providers.PowerChecker); ok {
+			if err := checker.CheckPower(vars["action"], force); err != nil {
+				writeProviderError(w, err)
+				return
+			}
+		}
+
+		op := ops.Start(vars["action"], name, id, func(ctx context.Context) (interface{}, error) {
+			ctx, cancel := cm.OperationContext(ctx, name)
+			defer cancel()
+			return nil, action(ctx)
+		})
+		writeAccepted(w, op)
+	}
+}
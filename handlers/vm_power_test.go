@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/operations"
+	"github.com/fuddata/anyvm/providers"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+)
+
+// powerStub can start VMs but not suspend them.
+type powerStub struct{ stubProvider }
+
+func (powerStub) StartVM(ctx context.Context, id string) error { return nil }
+
+func (powerStub) CheckPower(action string, force bool) error {
+	if action == "suspend" {
+		return &providers.NotSupportedError{Provider: "nutanix", Operation: "suspend"}
+	}
+	return nil
+}
+
+func TestPowerNotSupported(t *testing.T) {
+	cm := providers.NewCloudManager(nil)
+	cm.RegisterProvider("nutanix", "nutanix", powerStub{})
+	cfg := &config.Config{JWTSecret: "s3cret", Auth: config.AuthConfig{RolesClaim: "roles"}}
+	auth := middleware.NewAuthenticator(func() *config.Config { return cfg }, nil)
+	r := mux.NewRouter().SkipClean(true)
+	r.Use(auth.Middleware)
+	r.HandleFunc("/vms/{provider}/{id}/{action}", PowerVMHandler(cm, operations.NewManager())).Methods("POST")
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "u1", "roles": []string{"admin"}, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(target string) int {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do("/vms/nutanix/vm1/suspend"); code != http.StatusNotImplemented {
+		t.Errorf("suspend: status %d, want 501", code)
+	}
+	if code := do("/vms/nutanix/vm1/start"); code != http.StatusAccepted {
+		t.Errorf("start: status %d, want 202", code)
+	}
+}
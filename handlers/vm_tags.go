@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/policy"
+	"github.com/fuddata/anyvm/providers"
+
+	"github.com/gorilla/mux"
+)
+
+// UpdateTagsHandler changes the tags of a VM. The body is a JSON merge patch
+// of the tags: keys with a string value are set, keys with null are removed
+// and tags that aren't mentioned are kept. The response carries the VM with
+// its updated tags. Only admins may change the owner tag VMs are counted by
+// for policies.
+func UpdateTagsHandler(cm *providers.CloudManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		name := strings.ToLower(vars["provider"])
+		p := cm.GetProvider(name)
+		if p == nil {
+			writeError(w, http.StatusBadRequest, "Invalid provider specified")
+			return
+		}
+		if !authorize(w, r, config.ActionTags, name) {
+			return
+		}
+		updater, ok := p.(providers.TagUpdater)
+		if !ok {
+			writeProviderError(w, &providers.NotSupportedError{Provider: name, Operation: "tags"})
+			return
+		}
+
+		var patch map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		set := make(map[string]string)
+		var remove []string
+		for key, value := range patch {
+			if value == nil {
+				remove = append(remove, key)
+			} else {
+				set[key] = *value
+			}
+		}
+		for key := range patch {
+			if !authorizeOwnerTag(w, r, key) {
+				return
+			}
+		}
+		if err := providers.ValidateTags(cm.ProviderType(name), set); err != nil {
+			writeTagError(w, err)
+			return
+		}
+
+		id := vars["id"]
+		ctx, cancel := cm.OperationContext(r.Context(), name)
+		defer cancel()
+		if err := updater.UpdateTags(ctx, id, set, remove); err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		vm, err := cm.GetVM(ctx, name, id)
+		if err != nil {
+			writeJSON(w, http.StatusOK, models.APIResponse{
+				Success: true,
+				Error:   fmt.Sprintf("tags updated, but the VM could not be read: %v", err),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    vm,
+		})
+	}
+}
+
+// authorizeOwnerTag answers the request with HTTP 403 and returns false if
+// key is the owner tag and the caller isn't admin, so callers can't take
+// their VMs out of the count for maxVMsPerUser or charge them to others.
+func authorizeOwnerTag(w http.ResponseWriter, r *http.Request, key string) bool {
+	if !strings.EqualFold(key, policy.OwnerTag) || middleware.PermissionsFrom(r.Context()).Admin() {
+		return true
+	}
+	writeError(w, http.StatusForbidden, fmt.Sprintf("Only admins may set or remove the tag %s", policy.OwnerTag))
+	return false
+}
+
+// writeTagError answers a request with tags the provider doesn't accept.
+func writeTagError(w http.ResponseWriter, err error) {
+	var notSupported *providers.NotSupportedError
+	if errors.As(err, &notSupported) {
+		writeProviderError(w, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/fuddata/anyvm/apikeys"
+	"github.com/fuddata/anyvm/audit"
 	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/handlers"
+	"github.com/fuddata/anyvm/middleware"
+	"github.com/fuddata/anyvm/operations"
 	"github.com/fuddata/anyvm/providers"
 
 	"github.com/gorilla/mux"
@@ -15,50 +22,65 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
-	// Initialize cloud manager
-	cm := providers.NewCloudManager()
+	// Initialize cloud manager and the providers that are configured. When
+	// the configuration is reloaded only the providers it changes are
+	// re-initialized.
+	cm := providers.NewCloudManager(cfg.Timeouts)
+	cm.ApplyConfig(nil, cfg)
+	go config.Watch(context.Background(), config.File(), 5*time.Second, cm.ApplyConfig)
 
-	azureProvider, azureEnable := providers.NewAzureProvider(cfg)
-	if azureEnable {
-		cm.RegisterProvider("azure", azureProvider)
-	}
-	awsProvider, awsEnable := providers.NewAWSProvider(cfg)
-	if awsEnable {
-		cm.RegisterProvider("aws", awsProvider)
-	}
-	gcpProvider, gcpEnable := providers.NewGCPProvider(cfg)
-	if gcpEnable {
-		cm.RegisterProvider("gcp", gcpProvider)
-	}
+	// Long-running actions run in the background and are tracked here.
+	ops := operations.NewManager()
 
-	hypervProvider, hypervEnable := providers.NewHyperVProvider(cfg)
-	if hypervEnable {
-		cm.RegisterProvider("hyperv", hypervProvider)
+	// API keys let automation authenticate without OIDC.
+	keys, err := apikeys.Open(cfg.Auth.APIKeysFile)
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
 	}
-	nutanixProvider, nutanixEnable := providers.NewNutanixProvider(cfg)
-	if nutanixEnable {
-		cm.RegisterProvider("nutanix", nutanixProvider)
+	if cfg.Auth.APIKeysFile == "" {
+		fmt.Printf("auth.apiKeysFile is not set. API keys are kept in memory and lost on restart.\r\n")
 	}
-	proxmoxProvider, proxmoxEnable := providers.NewProxmoxVEProvider(cfg)
-	if proxmoxEnable {
-		cm.RegisterProvider("proxmox", proxmoxProvider)
+
+	// Requests that change something and the outcome of their operations are
+	// recorded in the audit log.
+	auditLog, err := audit.Open(cfg.AuditLogFile)
+	if err != nil {
+		log.Fatalf("Failed to open the audit log: %v", err)
 	}
-	vsphereProvider, vsphereEnable := providers.NewVSphereProvider(cfg)
-	if vsphereEnable {
-		cm.RegisterProvider("vsphere", vsphereProvider)
+	if cfg.AuditLogFile == "" {
+		fmt.Printf("auditLogFile is not set. The audit log is kept in memory and lost on restart.\r\n")
 	}
+	ops.OnFinish(auditLog.OperationFinished)
 
-	// Set up router
-	r := mux.NewRouter()
+	// Set up router. Path cleaning is disabled so Azure resource IDs, which
+	// start with a slash, can be used as the {id} path segment.
+	r := mux.NewRouter().SkipClean(true)
 
-	// API routes with auth middleware
+	// API routes. Every request needs a valid bearer token unless auth is
+	// disabled in the configuration; the handlers check the caller's
+	// permissions on the provider. Requests other than GET are audited under
+	// the name of their route.
 	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(middleware.NewAuthenticator(config.Current, keys).Middleware)
+	api.Use(auditLog.Middleware)
 
-	// FixMe: Enable authentication
-	// api.Use(middleware.AuthMiddleware)
-
-	api.HandleFunc("/vms/create", handlers.CreateVMHandler(cm, cfg)).Methods("POST")
+	api.HandleFunc("/vms/create", handlers.CreateVMHandler(cm, ops, config.Current)).Methods("POST").Name("create")
 	api.HandleFunc("/vms", handlers.ListVMsHandler(cm)).Methods("GET")
+	api.HandleFunc("/vms/{provider}/{id:.+}/{action:start|stop|restart|suspend}", handlers.PowerVMHandler(cm, ops)).Methods("POST").Name("power")
+	api.HandleFunc("/vms/{provider}/{id:.+}/tags", handlers.UpdateTagsHandler(cm)).Methods("PATCH").Name("tags")
+	api.HandleFunc("/vms/{provider}/{id:.+}", handlers.GetVMHandler(cm)).Methods("GET")
+	api.HandleFunc("/vms/{provider}/{id:.+}", handlers.DeleteVMHandler(cm, ops)).Methods("DELETE").Name("delete")
+	api.HandleFunc("/images", handlers.ListImagesHandler(cm)).Methods("GET")
+	api.HandleFunc("/sizes", handlers.ListSizesHandler(cm)).Methods("GET")
+	api.HandleFunc("/operations/{id}", handlers.GetOperationHandler(ops)).Methods("GET")
+	api.HandleFunc("/operations/{id}", handlers.CancelOperationHandler(ops)).Methods("DELETE").Name("cancel")
+	api.HandleFunc("/me/permissions", handlers.MyPermissionsHandler(cm)).Methods("GET")
+	api.HandleFunc("/policies/evaluate", handlers.EvaluatePoliciesHandler(cm, config.Current)).Methods("POST").Name("policy.evaluate")
+	api.HandleFunc("/apikeys", handlers.CreateAPIKeyHandler(keys)).Methods("POST").Name("apikey.create")
+	api.HandleFunc("/apikeys", handlers.ListAPIKeysHandler(keys)).Methods("GET")
+	api.HandleFunc("/apikeys/{id}", handlers.RevokeAPIKeyHandler(keys)).Methods("DELETE").Name("apikey.revoke")
+	api.HandleFunc("/audit", handlers.ListAuditHandler(auditLog)).Methods("GET")
+	api.HandleFunc("/audit/verify", handlers.VerifyAuditHandler(auditLog)).Methods("GET")
 
 	// Start server
 	log.Printf("Server starting on :%s", cfg.Port)
@@ -1,37 +1,226 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fuddata/anyvm/apikeys"
 	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// clockSkew is the difference between the clocks of AnyVM and the issuer
+// that is tolerated when checking exp, nbf and iat.
+const clockSkew = time.Minute
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string        `json:"subject"`
+	Issuer  string        `json:"issuer,omitempty"`
+	Name    string        `json:"name,omitempty"`   // preferred_username, email or name claim
+	APIKey  string        `json:"apiKey,omitempty"` // ID of the API key used instead of a token
+	Groups  []string      `json:"groups,omitempty"` // values of the groups claim
+	Claims  jwt.MapClaims `json:"-"`                // all claims of the token
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx that carries id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the caller authenticated for the request with ctx, or
+// nil when authentication is disabled.
+func IdentityFrom(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// oidcMethods are the signing algorithms accepted for tokens of the issuer.
+var oidcMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Authenticator checks the bearer token of every request against the auth
+// settings of the current configuration, so reloads take effect right away.
+// API keys of the store are accepted as bearer tokens as well.
+type Authenticator struct {
+	config  func() *config.Config
+	apiKeys *apikeys.Store
+
+	mu       sync.Mutex
+	settings config.AuthConfig // settings keys was created for
+	keys     *keySet
+}
+
+// NewAuthenticator returns an Authenticator that reads the configuration with
+// current, e.g. config.Current, and checks API keys against keys, which may
+// be nil.
+func NewAuthenticator(current func() *config.Config, keys *apikeys.Store) *Authenticator {
+	a := &Authenticator{config: current, apiKeys: keys}
+	if cfg := current(); !cfg.Auth.Disabled && cfg.Auth.Issuer == "" && cfg.JWTSecret == "" && (keys == nil || len(keys.List()) == 0) {
+		fmt.Printf("Authentication is not configured. Every API request is rejected until auth.issuer or jwtSecret is set.\r\n")
+	}
+	return a
+}
+
+// Middleware rejects requests without a valid bearer token with HTTP 401
+// and passes the caller's Identity and Permissions on in the request context.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		cfg := a.config()
+		if cfg.Auth.Disabled {
+			next.ServeHTTP(w, r.WithContext(WithPermissions(r.Context(), FullAccess())))
 			return
 		}
+		id, perms, err := a.authenticate(r, cfg)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", "invalid_token", err.Error()))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(models.APIResponse{Success: false, Error: err.Error()})
+			return
+		}
+		ctx := WithIdentity(r.Context(), id)
+		ctx = WithPermissions(ctx, perms)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		cfg := config.LoadConfig()
+// authenticate verifies the bearer token or API key of r and returns the
+// caller's identity and permissions.
+func (a *Authenticator) authenticate(r *http.Request, cfg *config.Config) (*Identity, *Permissions, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, nil, errors.New("missing bearer token")
+	}
+	if strings.HasPrefix(token, apikeys.Prefix) && a.apiKeys != nil {
+		key, err := a.apiKeys.Authenticate(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &Identity{Subject: "apikey:" + key.ID, Name: key.Name, APIKey: key.ID}, keyPermissions(key), nil
+	}
+	if cfg.Auth.Issuer == "" && cfg.JWTSecret == "" {
+		return nil, nil, errors.New("authentication is not configured")
+	}
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
+	methods := oidcMethods
+	if cfg.Auth.Issuer == "" {
+		methods = nil
+	}
+	if cfg.JWTSecret != "" {
+		methods = append(methods[:len(methods):len(methods)], "HS256")
+	}
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(methods), jwt.WithoutClaimsValidation())
+	parsed, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
 			return []byte(cfg.JWTSecret), nil
-		})
+		}
+		kid, _ := t.Header["kid"].(string)
+		return a.keySet(cfg.Auth).key(r.Context(), kid)
+	})
+	if err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && verr.Inner != nil {
+			err = verr.Inner
+		}
+		return nil, nil, fmt.Errorf("invalid token: %v", err)
+	}
+	_, shared := parsed.Method.(*jwt.SigningMethodHMAC)
+	if err := validateClaims(claims, cfg.Auth, !shared, time.Now()); err != nil {
+		return nil, nil, fmt.Errorf("invalid token: %v", err)
+	}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
+	id := &Identity{Claims: claims}
+	id.Subject, _ = claims["sub"].(string)
+	id.Issuer, _ = claims["iss"].(string)
+	id.Groups = claimValues(claims, cfg.Auth.GroupsClaim)
+	for _, claim := range []string{"preferred_username", "email", "name"} {
+		if name, _ := claims[claim].(string); name != "" {
+			id.Name = name
+			break
 		}
+	}
+	return id, permissionsFor(claims, cfg.Auth), nil
+}
 
-		next.ServeHTTP(w, r)
-	})
+// keySet returns the signing keys for the auth settings, starting over when
+// they changed.
+func (a *Authenticator) keySet(settings config.AuthConfig) *keySet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys == nil || !reflect.DeepEqual(a.settings, settings) {
+		a.settings, a.keys = settings, newKeySet(settings.Issuer, settings.JWKSURL)
+	}
+	return a.keys
+}
+
+// validateClaims checks the time claims, which every token needs to have an
+// expiry, and the subject. Tokens of the OIDC issuer must also name it as iss
+// and one of the audiences as aud; for HS256 tokens the audience is checked
+// when audiences are configured.
+func validateClaims(claims jwt.MapClaims, settings config.AuthConfig, oidc bool, now time.Time) error {
+	exp, ok, err := timeClaim(claims, "exp")
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return errors.New("token has no expiry")
+	case !now.Before(exp.Add(clockSkew)):
+		return errors.New("token is expired")
+	}
+	if nbf, ok, err := timeClaim(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(clockSkew).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	if iat, ok, err := timeClaim(claims, "iat"); err != nil {
+		return err
+	} else if ok && now.Add(clockSkew).Before(iat) {
+		return errors.New("token is issued in the future")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("token has no subject")
+	}
+	if oidc {
+		if iss, _ := claims["iss"].(string); iss != settings.Issuer {
+			return fmt.Errorf("token is issued by %q", iss)
+		}
+	}
+	if oidc || len(settings.Audiences) > 0 {
+		for _, aud := range settings.Audiences {
+			if claims.VerifyAudience(aud, true) {
+				return nil
+			}
+		}
+		return errors.New("token is not meant for this audience")
+	}
+	return nil
+}
+
+// timeClaim returns a NumericDate claim; ok is false if it isn't set.
+func timeClaim(claims jwt.MapClaims, name string) (t time.Time, ok bool, err error) {
+	switch v := claims[name].(type) {
+	case nil:
+		return time.Time{}, false, nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), true, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err == nil {
+			return time.Unix(0, int64(f*float64(time.Second))), true, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("claim %s is not a date", name)
 }
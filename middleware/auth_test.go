@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/apikeys"
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// fakeIssuer is a local OIDC issuer serving its discovery document and JWKS.
+type fakeIssuer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     map[string]interface{} // private keys by key ID
+	jwksHits int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	f := &fakeIssuer{keys: make(map[string]interface{})}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": f.URL, "jwks_uri": f.URL + "/keys"})
+		case "/keys":
+			f.jwksHits++
+			var keys []map[string]string
+			for kid, key := range f.keys {
+				keys = append(keys, publicJWK(kid, key))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) addKey(t *testing.T, kid string, ec bool) {
+	var key interface{}
+	var err error
+	if ec {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[kid] = key
+}
+
+func (f *fakeIssuer) token(t *testing.T, kid string, claims jwt.MapClaims) string {
+	f.mu.Lock()
+	key := f.keys[kid]
+	f.mu.Unlock()
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func publicJWK(kid string, key interface{}) map[string]string {
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N), "e": b64(big.NewInt(int64(k.E)))}
+	case *ecdsa.PrivateKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X), "y": b64(k.Y)}
+	}
+	return nil
+}
+
+// serve runs a request with the given token through the middleware and
+// returns the status and the identity the handler saw.
+func serve(a *Authenticator, token string) (int, *Identity) {
+	var id *Identity
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = IdentityFrom(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/vms", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, id
+}
+
+func TestAuthenticatorOIDC(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	issuer.addKey(t, "rsa1", false)
+	issuer.addKey(t, "ec1", true)
+	cfg := &config.Config{Auth: config.AuthConfig{Issuer: issuer.URL, Audiences: []string{"anyvm"}}}
+	a := NewAuthenticator(func() *config.Config { return cfg }, nil)
+
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": issuer.URL, "aud": []string{"other", "anyvm"}, "sub": "u123",
+			"email": "ops@example.com", "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"RS256", issuer.token(t, "rsa1", valid()), http.StatusOK},
+		{"ES256", issuer.token(t, "ec1", valid()), http.StatusOK},
+		{"expired within clock skew", issuer.token(t, "rsa1", with("exp", now.Add(-30*time.Second).Unix())), http.StatusOK},
+		{"no token", "", http.StatusUnauthorized},
+		{"expired", issuer.token(t, "rsa1", with("exp", now.Add(-2*time.Minute).Unix())), http.StatusUnauthorized},
+		{"no expiry", issuer.token(t, "rsa1", with("exp", nil)), http.StatusUnauthorized},
+		{"not yet valid", issuer.token(t, "rsa1", with("nbf", now.Add(5*time.Minute).Unix())), http.StatusUnauthorized},
+		{"other issuer", issuer.token(t, "rsa1", with("iss", "https://evil.example.com")), http.StatusUnauthorized},
+		{"other audience", issuer.token(t, "rsa1", with("aud", "other")), http.StatusUnauthorized},
+		{"no subject", issuer.token(t, "rsa1", with("sub", nil)), http.StatusUnauthorized},
+		{"HS256 without secret", hs256(t, "secret", valid()), http.StatusUnauthorized},
+		{"unsigned", unsigned(t, valid()), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		status, id := serve(a, tt.token)
+		if status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.status)
+		}
+		if status == http.StatusOK && (id == nil || id.Subject != "u123" || id.Name != "ops@example.com" || id.Issuer != issuer.URL) {
+			t.Errorf("%s: identity %+v", tt.name, id)
+		}
+	}
+	if issuer.jwksHits != 1 {
+		t.Errorf("JWKS fetched %d times, want once", issuer.jwksHits)
+	}
+}
+
+func TestAuthenticatorKeyRotation(t *testing.T) {
+	defer func(d time.Duration) { keyRefreshInterval = d }(keyRefreshInterval)
+	issuer := newFakeIssuer(t)
+	issuer.addKey(t, "old", false)
+	cfg := &config.Config{Auth: config.AuthConfig{Issuer: issuer.URL, Audiences: []string{"anyvm"}}}
+	a := NewAuthenticator(func() *config.Config { return cfg }, nil)
+	claims := jwt.MapClaims{"iss": issuer.URL, "aud": "anyvm", "sub": "u1", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if status, _ := serve(a, issuer.token(t, "old", claims)); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	issuer.addKey(t, "new", true)
+	newToken := issuer.token(t, "new", claims)
+
+	// Right after a fetch an unknown key doesn't make the keys be fetched again.
+	if status, _ := serve(a, newToken); status != http.StatusUnauthorized {
+		t.Errorf("token of a new key accepted before the keys could be refreshed, status %d", status)
+	}
+	keyRefreshInterval = 0
+	if status, _ := serve(a, newToken); status != http.StatusOK {
+		t.Errorf("token of the rotated key: status %d", status)
+	}
+	if issuer.jwksHits != 2 {
+		t.Errorf("JWKS fetched %d times, want twice", issuer.jwksHits)
+	}
+}
+
+func TestAuthenticatorSharedSecret(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "s3cret"}
+	a := NewAuthenticator(func() *config.Config { return cfg }, nil)
+	claims := jwt.MapClaims{"sub": "ci", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if status, id := serve(a, hs256(t, "s3cret", claims)); status != http.StatusOK || id.Subject != "ci" {
+		t.Errorf("status %d, identity %+v", status, id)
+	}
+	if status, _ := serve(a, hs256(t, "wrong", claims)); status != http.StatusUnauthorized {
+		t.Errorf("token with the wrong secret: status %d", status)
+	}
+
+	cfg = &config.Config{}
+	if status, _ := serve(a, hs256(t, "s3cret", claims)); status != http.StatusUnauthorized {
+		t.Errorf("without any auth settings: status %d", status)
+	}
+	cfg = &config.Config{Auth: config.AuthConfig{Disabled: true}}
+	if status, id := serve(a, ""); status != http.StatusOK || id != nil {
+		t.Errorf("auth disabled: status %d, identity %+v", status, id)
+	}
+}
+
+func hs256(t *testing.T, secret string, claims jwt.MapClaims) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func unsigned(t *testing.T, claims jwt.MapClaims) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthenticatorAPIKey(t *testing.T) {
+	keys, err := apikeys.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.Create("ci", "u1", []models.APIKeyScope{{Role: "operator", Providers: []string{"proxmox"}, Actions: []string{"power"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// API keys work without any other auth settings.
+	cfg := &config.Config{}
+	a := NewAuthenticator(func() *config.Config { return cfg }, keys)
+
+	var perms *Permissions
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perms = PermissionsFrom(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/vms/proxmox/100/start", nil)
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !perms.Allows(config.ActionPower, "proxmox") || perms.Allows(config.ActionCreate, "proxmox") || perms.Allows(config.ActionRead, "aws") {
+		t.Errorf("status %d, actions on proxmox %v", rec.Code, perms.Actions("proxmox"))
+	}
+
+	keys.Revoke(key.ID)
+	if status, _ := serve(a, key.Key); status != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d", status)
+	}
+}
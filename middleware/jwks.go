@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// keyMaxAge is how long fetched signing keys are used before they are
+	// fetched again.
+	keyMaxAge = time.Hour
+	// keyRefreshInterval limits how often an unknown key ID makes the keys be
+	// fetched again, so tokens with made-up key IDs can't flood the issuer.
+	keyRefreshInterval = time.Minute
+)
+
+// keySet holds the signing keys of an OIDC issuer. They are fetched from the
+// JWKS named in the issuer's discovery document, or from jwksURL, on first
+// use and again when they are old or a token is signed with a new key.
+type keySet struct {
+	issuer  string
+	jwksURL string
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time                   // last successful fetch
+	tried   time.Time                   // last fetch, successful or not
+}
+
+func newKeySet(issuer, jwksURL string) *keySet {
+	return &keySet{issuer: issuer, jwksURL: jwksURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// key returns the key with the given ID. A token without key ID can only be
+// checked when the issuer has a single key.
+func (s *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, known := s.lookup(kid)
+	stale := time.Since(s.fetched) > keyMaxAge
+	if (stale || !known) && time.Since(s.tried) >= keyRefreshInterval {
+		s.tried = time.Now()
+		keys, err := s.fetch(ctx)
+		if err != nil {
+			if s.keys == nil {
+				return nil, fmt.Errorf("fetching the signing keys of %s: %w", s.issuer, err)
+			}
+			// Keep using the previous keys until the issuer is back.
+			fmt.Printf("Refreshing the signing keys of %s failed. Will continue with the previous keys. Error: %v\r\n", s.issuer, err)
+		} else {
+			s.keys, s.fetched = keys, time.Now()
+			key, known = s.lookup(kid)
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// fetch reads the keys of the JWKS, discovering its URL first if needed.
+func (s *keySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if s.jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := s.get(ctx, strings.TrimSuffix(s.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.Issuer != s.issuer {
+			return nil, fmt.Errorf("discovery document is for issuer %q", discovery.Issuer)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("discovery document has no jwks_uri")
+		}
+		s.jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := s.get(ctx, s.jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Keys of unsupported types don't make the others unusable.
+			fmt.Printf("Skipping signing key %q of %s: %v\r\n", k.Kid, s.issuer, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no usable signing keys", s.jwksURL)
+	}
+	return keys, nil
+}
+
+func (s *keySet) get(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jwk is a JSON Web Key (RFC 7517) with the fields of RSA and EC public keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
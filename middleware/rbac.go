@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Permissions are the actions a caller may take on the VMs of each provider.
+type Permissions struct {
+	Roles []string // roles given to the caller on some or all providers
+	rules []rule
+}
+
+// rule allows actions on the providers matching one of the patterns, or on
+// every provider when there are none.
+type rule struct {
+	providers []string
+	actions   []string
+}
+
+func (r rule) matches(provider string) bool {
+	if len(r.providers) == 0 {
+		return true
+	}
+	for _, pattern := range r.providers {
+		if ok, _ := path.Match(pattern, provider); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FullAccess allows every action on every provider. Callers get it when
+// authentication is disabled.
+func FullAccess() *Permissions {
+	p := &Permissions{}
+	p.add("admin", rule{actions: config.Roles["admin"]})
+	return p
+}
+
+// permissionsFor maps the roles and groups of a token to permissions: roles
+// in the roles claim apply to every provider, grants to the groups and
+// subjects they name.
+func permissionsFor(claims jwt.MapClaims, settings config.AuthConfig) *Permissions {
+	p := &Permissions{}
+	for _, role := range claimValues(claims, settings.RolesClaim) {
+		if actions, ok := config.Roles[role]; ok {
+			p.add(role, rule{actions: actions})
+		}
+	}
+	sub, _ := claims["sub"].(string)
+	groups := claimValues(claims, settings.GroupsClaim)
+	for _, g := range settings.Grants {
+		if !contains(g.Subjects, sub) && !containsAny(g.Groups, groups) {
+			continue
+		}
+		p.add(g.Role, grantRule(g))
+	}
+	return p
+}
+
+// keyPermissions returns the permissions of the scopes of an API key.
+func keyPermissions(key models.APIKey) *Permissions {
+	p := &Permissions{}
+	for _, scope := range key.Scopes {
+		p.add(scope.Role, grantRule(config.Grant{Role: scope.Role, Providers: scope.Providers, Actions: scope.Actions}))
+	}
+	return p
+}
+
+// grantRule returns the rule of a grant. Actions narrow the role down but
+// always include read.
+func grantRule(g config.Grant) rule {
+	actions := config.Roles[g.Role]
+	if len(g.Actions) > 0 {
+		actions = append([]string{config.ActionRead}, g.Actions...)
+	}
+	return rule{providers: g.Providers, actions: actions}
+}
+
+func (p *Permissions) add(role string, r rule) {
+	if !contains(p.Roles, role) {
+		p.Roles = append(p.Roles, role)
+		sort.Strings(p.Roles)
+	}
+	p.rules = append(p.rules, r)
+}
+
+// Allows reports whether action may be taken on the VMs of provider. A nil
+// Permissions allows nothing.
+func (p *Permissions) Allows(action, provider string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.rules {
+		if r.matches(provider) && contains(r.actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin reports whether every action is allowed on every provider, which
+// managing API keys requires.
+func (p *Permissions) Admin() bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.rules {
+		if len(r.providers) == 0 && containsAll(r.actions, config.Roles["admin"]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Actions returns the actions allowed on provider, from the least to the
+// most privileged.
+func (p *Permissions) Actions(provider string) []string {
+	var actions []string
+	for _, action := range config.Roles["admin"] {
+		if p.Allows(action, provider) {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+type permissionsKey struct{}
+
+// WithPermissions returns a copy of ctx that carries p.
+func WithPermissions(ctx context.Context, p *Permissions) context.Context {
+	return context.WithValue(ctx, permissionsKey{}, p)
+}
+
+// PermissionsFrom returns the permissions of the caller of the request with
+// ctx, or nil if it didn't pass the Authenticator.
+func PermissionsFrom(ctx context.Context) *Permissions {
+	p, _ := ctx.Value(permissionsKey{}).(*Permissions)
+	return p
+}
+
+// claimValues returns the strings of a claim that is a string or a list of
+// strings. Nested claims are given as a dotted path.
+func claimValues(claims jwt.MapClaims, name string) []string {
+	if name == "" {
+		return nil
+	}
+	var v interface{} = map[string]interface{}(claims)
+	for _, key := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(list, values []string) bool {
+	for _, v := range values {
+		if !contains(list, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestPermissionsFor(t *testing.T) {
+	settings := config.AuthConfig{
+		RolesClaim:  "realm_access.roles",
+		GroupsClaim: "groups",
+		Grants: []config.Grant{
+			{Role: "operator", Groups: []string{"team-x"}, Providers: []string{"azure-dev", "proxmox*"}, Actions: []string{"create", "power"}},
+			{Role: "admin", Subjects: []string{"u42"}, Providers: []string{"aws"}},
+			{Role: "admin", Groups: []string{"platform"}},
+		},
+	}
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		roles  []string
+		want   map[string][]string // allowed actions by provider
+	}{
+		{
+			name:   "grant to group",
+			claims: jwt.MapClaims{"sub": "u1", "groups": []interface{}{"team-y", "team-x"}},
+			roles:  []string{"operator"},
+			want: map[string][]string{
+				"azure-dev":   {"read", "create", "power"},
+				"proxmox-lab": {"read", "create", "power"},
+				"azure":       nil,
+			},
+		},
+		{
+			name:   "role claim and grant to subject",
+			claims: jwt.MapClaims{"sub": "u42", "realm_access": map[string]interface{}{"roles": []interface{}{"viewer", "offline_access"}}},
+			roles:  []string{"admin", "viewer"},
+			want: map[string][]string{
+				"aws":   {"read", "create", "power", "tags", "delete"},
+				"gcp":   {"read"},
+				"azure": {"read"},
+			},
+		},
+		{
+			name:   "single group as string",
+			claims: jwt.MapClaims{"sub": "u2", "groups": "platform"},
+			roles:  []string{"admin"},
+			want:   map[string][]string{"hyperv": {"read", "create", "power", "tags", "delete"}},
+		},
+		{
+			name:   "nothing granted",
+			claims: jwt.MapClaims{"sub": "u3", "roles": []interface{}{"admin"}, "groups": []interface{}{"team-z"}},
+			want:   map[string][]string{"aws": nil},
+		},
+	}
+	for _, tt := range tests {
+		p := permissionsFor(tt.claims, settings)
+		if !reflect.DeepEqual(p.Roles, tt.roles) {
+			t.Errorf("%s: roles %v, want %v", tt.name, p.Roles, tt.roles)
+		}
+		for provider, want := range tt.want {
+			if got := p.Actions(provider); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: actions on %s %v, want %v", tt.name, provider, got, want)
+			}
+		}
+	}
+
+	var none *Permissions
+	if none.Allows(config.ActionRead, "aws") {
+		t.Error("nil permissions allow reading")
+	}
+}
+
+func TestAuthenticatorPermissions(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "s3cret", Auth: config.AuthConfig{
+		RolesClaim: "roles",
+		Grants:     []config.Grant{{Role: "viewer", Subjects: []string{"ci"}}},
+	}}
+	a := NewAuthenticator(func() *config.Config { return cfg }, nil)
+	var perms *Permissions
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perms = PermissionsFrom(r.Context())
+	}))
+	serve := func(token string) {
+		perms = nil
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/me/permissions", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(hs256(t, "s3cret", jwt.MapClaims{"sub": "ci", "roles": "operator", "exp": time.Now().Add(time.Hour).Unix()}))
+	if !perms.Allows(config.ActionPower, "aws") || perms.Allows(config.ActionDelete, "aws") {
+		t.Errorf("operator: actions %v", perms.Actions("aws"))
+	}
+
+	cfg = &config.Config{Auth: config.AuthConfig{Disabled: true}}
+	serve("")
+	if !perms.Allows(config.ActionDelete, "vsphere") {
+		t.Errorf("auth disabled: actions %v", perms.Actions("vsphere"))
+	}
+}
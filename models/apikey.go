@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived credential for automation, e.g. CI pipelines that
+// can't log in interactively. Only a hash of the key is stored; the key itself
+// is returned once, when it is created.
+type APIKey struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	CreatedBy  string        `json:"createdBy,omitempty"` // subject of the caller that created it
+	Scopes     []APIKeyScope `json:"scopes"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	ExpiresAt  *time.Time    `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time    `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time    `json:"revokedAt,omitempty"`
+	Key        string        `json:"key,omitempty"` // only set in the response to the create request
+}
+
+// APIKeyScope is a role the key has, optionally only on some providers
+// (names or patterns like "azure-*") and for some of the role's actions, like
+// a grant in the auth settings.
+type APIKeyScope struct {
+	Role      string   `json:"role"`
+	Providers []string `json:"providers,omitempty"`
+	Actions   []string `json:"actions,omitempty"`
+}
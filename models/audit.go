@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry records a request that changed something, or the outcome of the
+// operation such a request started. Each entry carries the hash of the one
+// before it, so changing or removing entries breaks the chain.
+type AuditEntry struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"` // "request" or "operation"
+
+	// Action is what was done: "create", "start", "stop", "restart",
+	// "suspend", "tags", "delete", "cancel", "apikey.create" or
+	// "apikey.revoke". Operations have the action of the request that
+	// started them.
+	Action      string `json:"action"`
+	Provider    string `json:"provider,omitempty"`
+	VMID        string `json:"vmId,omitempty"`
+	OperationID string `json:"operationId,omitempty"`
+
+	// Caller and request. Only set for requests.
+	Subject      string          `json:"subject,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	APIKey       string          `json:"apiKey,omitempty"`
+	SourceIP     string          `json:"sourceIp,omitempty"`
+	ForwardedFor string          `json:"forwardedFor,omitempty"` // X-Forwarded-For header as sent
+	Method       string          `json:"method,omitempty"`
+	Path         string          `json:"path,omitempty"`
+	Body         json.RawMessage `json:"body,omitempty"` // with passwords and other secrets redacted
+	Status       int             `json:"status,omitempty"`
+
+	Success    bool            `json:"success"`
+	Error      string          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"` // result of an operation, e.g. the created VM
+	DurationMs int64           `json:"durationMs"`
+
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"` // hex SHA-256 of the entry with an empty hash
+}
+
+// AuditFilter selects audit entries. Empty fields match every entry.
+type AuditFilter struct {
+	Subject     string
+	Action      string
+	Provider    string
+	VMID        string
+	OperationID string
+	Since       *time.Time
+	Until       *time.Time
+}
+
+// Match reports whether e is selected by the filter.
+func (f AuditFilter) Match(e AuditEntry) bool {
+	switch {
+	case f.Subject != "" && e.Subject != f.Subject,
+		f.Action != "" && e.Action != f.Action,
+		f.Provider != "" && e.Provider != f.Provider,
+		f.VMID != "" && e.VMID != f.VMID,
+		f.OperationID != "" && e.OperationID != f.OperationID,
+		f.Since != nil && e.Time.Before(*f.Since),
+		f.Until != nil && !e.Time.Before(*f.Until):
+		return false
+	}
+	return true
+}
+
+// AuditVerification is the result of checking the hash chain of the audit log.
+type AuditVerification struct {
+	Valid   bool   `json:"valid"`
+	Entries int64  `json:"entries"`
+	Error   string `json:"error,omitempty"` // first break of the chain
+}
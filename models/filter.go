@@ -0,0 +1,94 @@
+package models
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// VMFilter selects VMs of a list. Empty fields match every VM; a VM has to
+// match all fields that are set.
+type VMFilter struct {
+	Statuses  []VMStatus
+	Region    string         // region, zone or datacenter, matched as case-insensitive prefix
+	Name      string         // case-insensitive glob, e.g. "web-*"
+	NameRegex *regexp.Regexp // matched against the name as is
+	Size      string         // native size name, case-insensitive
+
+	// ResourceGroup selects the Azure VMs of a resource group,
+	// case-insensitively. VMs of other providers don't match.
+	ResourceGroup string
+
+	// Tags the VM has to carry. An empty value only requires the key.
+	Tags map[string]string
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// IsZero reports whether the filter matches every VM.
+func (f VMFilter) IsZero() bool {
+	return len(f.Statuses) == 0 && f.Region == "" && f.Name == "" && f.NameRegex == nil && f.Size == "" &&
+		f.ResourceGroup == "" && len(f.Tags) == 0 && f.CreatedAfter == nil && f.CreatedBefore == nil
+}
+
+// Match reports whether vm is selected by the filter. VMs without a creation
+// time don't match a creation date filter.
+func (f VMFilter) Match(vm VM) bool {
+	if len(f.Statuses) > 0 && !f.HasStatus(vm.Status) {
+		return false
+	}
+	if f.Region != "" && !strings.HasPrefix(strings.ToLower(vm.Region), strings.ToLower(f.Region)) {
+		return false
+	}
+	if f.Name != "" {
+		if ok, _ := path.Match(strings.ToLower(f.Name), strings.ToLower(vm.Name)); !ok {
+			return false
+		}
+	}
+	if f.NameRegex != nil && !f.NameRegex.MatchString(vm.Name) {
+		return false
+	}
+	if f.Size != "" && !strings.EqualFold(f.Size, vm.Size) {
+		return false
+	}
+	if f.ResourceGroup != "" && !strings.EqualFold(f.ResourceGroup, resourceGroup(vm.ID)) {
+		return false
+	}
+	for key, value := range f.Tags {
+		if v, ok := vm.Tags[key]; !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+	if f.CreatedAfter != nil && (vm.CreatedAt == nil || vm.CreatedAt.Before(*f.CreatedAfter)) {
+		return false
+	}
+	if f.CreatedBefore != nil && (vm.CreatedAt == nil || !vm.CreatedAt.Before(*f.CreatedBefore)) {
+		return false
+	}
+	return true
+}
+
+// resourceGroup returns the resource group of an Azure resource ID
+// ("/subscriptions/<id>/resourceGroups/<group>/providers/..."), or "" for
+// other IDs.
+func resourceGroup(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// HasStatus reports whether status is one of the filter's statuses.
+func (f VMFilter) HasStatus(status VMStatus) bool {
+	for _, s := range f.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
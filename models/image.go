@@ -0,0 +1,15 @@
+package models
+
+// Image is a logical image of the catalog, e.g. "ubuntu24", and what it
+// refers to in each provider.
+type Image struct {
+	Name      string                    `json:"name"`
+	Providers map[string]ImageReference `json:"providers"` // by provider or account name
+}
+
+type ImageReference struct {
+	Type      string `json:"type"`               // provider type, e.g. "aws"
+	Reference string `json:"reference"`          // as configured, e.g. an AMI name pattern
+	Resolved  string `json:"resolved,omitempty"` // native image the reference currently resolves to, if different
+	Error     string `json:"error,omitempty"`
+}
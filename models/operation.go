@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// OperationStatus is the state of an asynchronous operation.
+type OperationStatus string
+
+const (
+	OperationRunning   OperationStatus = "running"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+	OperationCancelled OperationStatus = "cancelled"
+)
+
+// Operation tracks a long-running action (create, delete or a power action)
+// that runs in the background after the request that started it returned.
+type Operation struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"` // "create", "delete", "start", "stop", "restart" or "suspend"
+	Provider string          `json:"provider"`
+	VMID     string          `json:"vmId,omitempty"`
+	Status   OperationStatus `json:"status"`
+
+	// Progress is the last progress reported by the provider. Percent is only
+	// set by providers whose tasks report it (GCP, vSphere, Nutanix).
+	Progress string `json:"progress,omitempty"`
+	Percent  int    `json:"percent,omitempty"`
+
+	Result interface{} `json:"result,omitempty"` // the created VM or the deleted resources
+	Error  string      `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
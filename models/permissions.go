@@ -0,0 +1,10 @@
+package models
+
+// Permissions tells callers what they may do, so UIs can hide the actions
+// they aren't allowed to take.
+type Permissions struct {
+	Subject   string              `json:"subject,omitempty"` // empty when authentication is disabled
+	Name      string              `json:"name,omitempty"`
+	Roles     []string            `json:"roles"`
+	Providers map[string][]string `json:"providers"` // allowed actions by provider or account name
+}
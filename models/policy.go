@@ -0,0 +1,16 @@
+package models
+
+// PolicyViolation is a rule of a policy a create request doesn't meet.
+type PolicyViolation struct {
+	Policy  string `json:"policy"`
+	Rule    string `json:"rule"` // e.g. "allowedSizes"
+	Message string `json:"message"`
+}
+
+// PolicyResult is the outcome of evaluating a create request against the
+// policies.
+type PolicyResult struct {
+	Allowed    bool              `json:"allowed"`
+	Policies   []string          `json:"policies"` // policies that apply to the request
+	Violations []PolicyViolation `json:"violations"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "math"
+
+// Size is a VM size (Azure VM size, AWS instance type, GCP machine type) or,
+// for providers without fixed sizes, a named pair of cores and memory.
+type Size struct {
+	Name           string   `json:"name,omitempty"`
+	CPUs           int      `json:"cpus"`
+	MemoryMB       int64    `json:"memoryMb"`
+	Arch           string   `json:"arch,omitempty"`           // "x86_64" or "arm64"
+	HourlyPriceUSD float64  `json:"hourlyPriceUsd,omitempty"` // Linux on-demand price, where known
+	Aliases        []string `json:"aliases,omitempty"`        // size aliases (e.g. "small") mapped to it
+}
+
+// ProviderSizes are the sizes one provider offers.
+type ProviderSizes struct {
+	Provider string `json:"provider"` // name the provider is registered under
+	Type     string `json:"type"`
+	Sizes    []Size `json:"sizes"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SizeRequirements describe a VM size by the resources it needs.
+type SizeRequirements struct {
+	CPU       int     `json:"cpu"`
+	MemoryGiB float64 `json:"memoryGiB"`
+	Arch      string  `json:"arch,omitempty"` // "x86_64" (default) or "arm64"
+}
+
+// MemoryMB returns the required memory in MB, rounded up.
+func (r SizeRequirements) MemoryMB() int64 {
+	return int64(math.Ceil(r.MemoryGiB * 1024))
+}
+
+// Match reports whether size has at least the required cores and memory and
+// the required architecture. Sizes of unknown architecture match any.
+func (r SizeRequirements) Match(size Size) bool {
+	arch := r.Arch
+	if arch == "" {
+		arch = "x86_64"
+	}
+	return size.CPUs >= r.CPU && size.MemoryMB >= r.MemoryMB() && (size.Arch == "" || size.Arch == arch)
+}
@@ -1,15 +1,117 @@
 package models
 
+import "time"
+
+// VMStatus is the provider-neutral power state of a VM.
+type VMStatus string
+
+const (
+	StatusRunning     VMStatus = "running"
+	StatusStopped     VMStatus = "stopped"
+	StatusDeallocated VMStatus = "deallocated" // stopped and not billed for compute (Azure)
+	StatusStarting    VMStatus = "starting"
+	StatusStopping    VMStatus = "stopping"
+	StatusSuspended   VMStatus = "suspended" // paused, saved or hibernated
+	StatusTerminated  VMStatus = "terminated"
+	StatusUnknown     VMStatus = "unknown"
+)
+
 type VM struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Provider string `json:"provider"`
-	Region   string `json:"region"`
-	Status   string `json:"status"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Provider  string   `json:"provider"` // provider type, e.g. "azure"
+	Account   string   `json:"account"`  // name the provider is registered under, e.g. "azure-prod"
+	Region    string   `json:"region"`
+	Status    VMStatus `json:"status"`
+	RawStatus string   `json:"rawStatus,omitempty"` // state as reported by the provider
+
+	// Details. Listing fills in what the provider returns cheaply; the
+	// single-VM endpoint fills in everything the provider knows.
+	Size       string            `json:"size,omitempty"`
+	CPUs       int               `json:"cpus,omitempty"`
+	MemoryMB   int64             `json:"memoryMb,omitempty"`
+	Image      string            `json:"image,omitempty"`
+	OSType     string            `json:"osType,omitempty"`
+	Disks      []Disk            `json:"disks,omitempty"`
+	PrivateIPs []string          `json:"privateIps,omitempty"`
+	PublicIPs  []string          `json:"publicIps,omitempty"`
+	CreatedAt  *time.Time        `json:"createdAt,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+type Disk struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	SizeGB int64  `json:"sizeGb,omitempty"`
+	Boot   bool   `json:"boot,omitempty"`
+}
+
+// VMSpec is the provider-neutral description of a VM to create. Providers
+// ignore fields that don't apply to them.
+type VMSpec struct {
+	Name       string `json:"name"`
+	Region     string `json:"region,omitempty"` // Azure location, GCP zone, vSphere cluster, ...
+	Size       string `json:"size,omitempty"`   // size alias (e.g. "small") or native size name
+	CPUs       int    `json:"cpus,omitempty"`   // on-premises providers without fixed sizes
+	MemoryMB   int64  `json:"memoryMb,omitempty"`
+	Image      string `json:"image,omitempty"` // image alias (e.g. "ubuntu24"), native image or template
+	DiskSizeGB int64  `json:"diskSizeGb,omitempty"`
+	Network    string `json:"network,omitempty"` // NIC, subnet, network or virtual switch
+
+	// PublicIP requests a public IP address (true) or none (false). By
+	// default the network decides. Only AWS and GCP take it into account.
+	PublicIP *bool `json:"publicIp,omitempty"`
+
+	AdminUsername string   `json:"adminUsername,omitempty"`
+	AdminPassword string   `json:"adminPassword,omitempty"`
+	SSHKeys       []string `json:"sshKeys,omitempty"` // public keys authorized for the admin user
+
+	Tags map[string]string `json:"tags,omitempty"` // AWS/Azure tags, GCP labels, Proxmox tags (keys only), ...
+
+	// Requirements select the size instead of Size: the smallest size that
+	// meets them, or exactly the required cores and memory for providers
+	// without fixed sizes.
+	Requirements *SizeRequirements `json:"requirements,omitempty"`
+
+	// Provider-specific settings.
+	ResourceGroup    string   `json:"resourceGroup,omitempty"` // Azure
+	KeyName          string   `json:"keyName,omitempty"`       // AWS
+	SecurityGroupIDs []string `json:"securityGroupIds,omitempty"`
+	ProjectID        string   `json:"projectId,omitempty"`   // GCP
+	Storage          string   `json:"storage,omitempty"`     // Proxmox target storage of a full clone, Hyper-V VHDX folder
+	LinkedClone      bool     `json:"linkedClone,omitempty"` // Proxmox linked clone, Hyper-V differencing disk
+	IPConfig         string   `json:"ipConfig,omitempty"`    // Proxmox cloud-init ipconfig0, e.g. "ip=dhcp"
+	Generation       int      `json:"generation,omitempty"`  // Hyper-V VM generation, 1 or 2 (default)
+	SkipStart        bool     `json:"skipStart,omitempty"`   // Hyper-V: leave the new VM off
+}
+
+// DeletedResource reports the outcome of removing one resource while deleting a VM.
+type DeletedResource struct {
+	Type    string `json:"type"` // "vm", "disk", "nic" or "publicIp"
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProviderResult reports how one provider answered a request that fans out to
+// several providers.
+type ProviderResult struct {
+	Provider  string `json:"provider"` // name the provider is registered under
+	Type      string `json:"type,omitempty"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	VMCount   int    `json:"vmCount"` // VMs matching the list filter
+	LatencyMs int64  `json:"latencyMs"`
 }
 
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success   bool             `json:"success"`
+	Data      interface{}      `json:"data,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Providers []ProviderResult `json:"providers,omitempty"`
+
+	// Paged lists: the number of items across all pages and the cursor of the
+	// next page, empty on the last one.
+	Total      *int   `json:"total,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
 }
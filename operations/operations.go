@@ -0,0 +1,166 @@
+// Package operations runs long-running provider calls in the background and
+// keeps track of their progress and outcome.
+package operations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// retention is how long finished operations can still be queried.
+const retention = time.Hour
+
+// Func is the work an operation performs. ctx is cancelled when the operation
+// is cancelled; the returned value becomes the operation's result.
+type Func func(ctx context.Context) (interface{}, error)
+
+type entry struct {
+	op     models.Operation
+	cancel context.CancelFunc
+}
+
+// Manager keeps the operations started by this process in memory.
+type Manager struct {
+	mu       sync.Mutex
+	ops      map[string]*entry
+	onFinish []func(models.Operation)
+}
+
+func NewManager() *Manager {
+	return &Manager{ops: make(map[string]*entry)}
+}
+
+// Start runs fn in a new goroutine and returns the operation tracking it.
+// fn doesn't inherit a request context, so it keeps running after the HTTP
+// request that started it has completed.
+func (m *Manager) Start(typ, provider, vmID string, fn Func) models.Operation {
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{
+		op: models.Operation{
+			ID:        newID(),
+			Type:      typ,
+			Provider:  provider,
+			VMID:      vmID,
+			Status:    models.OperationRunning,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	m.prune(now)
+	m.ops[e.op.ID] = e
+	op := e.op
+	m.mu.Unlock()
+
+	ctx = context.WithValue(ctx, reporterKey{}, func(percent int, msg string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		e.op.Progress = msg
+		if percent > 0 {
+			e.op.Percent = percent
+		}
+		e.op.UpdatedAt = time.Now()
+	})
+
+	go func() {
+		defer cancel()
+		result, err := fn(ctx)
+		m.finish(ctx, e, result, err)
+	}()
+	return op
+}
+
+// OnFinish registers fn to be called with every operation that finished.
+func (m *Manager) OnFinish(fn func(models.Operation)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onFinish = append(m.onFinish, fn)
+}
+
+func (m *Manager) finish(ctx context.Context, e *entry, result interface{}, err error) {
+	m.mu.Lock()
+	now := time.Now()
+	e.op.Result = result
+	e.op.UpdatedAt = now
+	e.op.FinishedAt = &now
+	switch {
+	case err == nil:
+		e.op.Status = models.OperationSucceeded
+		e.op.Percent = 100
+	case errors.Is(ctx.Err(), context.Canceled):
+		e.op.Status = models.OperationCancelled
+		e.op.Error = err.Error()
+	default:
+		e.op.Status = models.OperationFailed
+		e.op.Error = err.Error()
+	}
+	fmt.Printf("Operation %s (%s %s) %s\r\n", e.op.ID, e.op.Type, e.op.Provider, e.op.Status)
+	op, callbacks := e.op, m.onFinish
+	m.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(op)
+	}
+}
+
+// prune drops operations that finished longer than retention ago. m.mu must be held.
+func (m *Manager) prune(now time.Time) {
+	for id, e := range m.ops {
+		if e.op.FinishedAt != nil && now.Sub(*e.op.FinishedAt) > retention {
+			delete(m.ops, id)
+		}
+	}
+}
+
+// Get returns a snapshot of the operation with the given ID.
+func (m *Manager) Get(id string) (models.Operation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.ops[id]
+	if !ok {
+		return models.Operation{}, false
+	}
+	return e.op, true
+}
+
+// Cancel cancels the context of a running operation. The operation is marked
+// cancelled once the provider call has returned.
+func (m *Manager) Cancel(id string) (models.Operation, bool) {
+	m.mu.Lock()
+	e, ok := m.ops[id]
+	m.mu.Unlock()
+	if !ok {
+		return models.Operation{}, false
+	}
+	e.cancel()
+	return m.Get(id)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type reporterKey struct{}
+
+// Progress records the progress of the operation running with ctx. percent is
+// ignored unless positive. Outside an operation it does nothing, so providers
+// can report progress unconditionally.
+func Progress(ctx context.Context, percent int, format string, args ...interface{}) {
+	if report, ok := ctx.Value(reporterKey{}).(func(int, string)); ok {
+		report(percent, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,127 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// finished returns a manager whose finished operations are sent on the
+// returned channel.
+func finished() (*Manager, <-chan models.Operation) {
+	m := NewManager()
+	done := make(chan models.Operation, 10)
+	m.OnFinish(func(op models.Operation) { done <- op })
+	return m, done
+}
+
+func wait(t *testing.T, done <-chan models.Operation) models.Operation {
+	t.Helper()
+	select {
+	case op := <-done:
+		return op
+	case <-time.After(5 * time.Second):
+		t.Fatal("operation did not finish")
+		return models.Operation{}
+	}
+}
+
+func TestOperationSucceeds(t *testing.T) {
+	m, done := finished()
+	release := make(chan struct{})
+	reported := make(chan struct{})
+	op := m.Start("create", "aws", "", func(ctx context.Context) (interface{}, error) {
+		Progress(ctx, 40, "waiting for %s", "instance")
+		close(reported)
+		<-release
+		return "i-1", nil
+	})
+	if op.Status != models.OperationRunning || op.FinishedAt != nil || op.Type != "create" || op.Provider != "aws" {
+		t.Fatalf("unexpected started operation %+v", op)
+	}
+
+	<-reported
+	running, ok := m.Get(op.ID)
+	if !ok || running.Status != models.OperationRunning || running.Percent != 40 || running.Progress != "waiting for instance" {
+		t.Errorf("unexpected running operation %+v", running)
+	}
+	close(release)
+
+	got := wait(t, done)
+	if got.ID != op.ID || got.Status != models.OperationSucceeded || got.Percent != 100 || got.Result != "i-1" || got.Error != "" || got.FinishedAt == nil {
+		t.Errorf("unexpected finished operation %+v", got)
+	}
+	if stored, _ := m.Get(op.ID); stored.Status != models.OperationSucceeded {
+		t.Errorf("stored operation is %s", stored.Status)
+	}
+}
+
+func TestOperationFails(t *testing.T) {
+	m, done := finished()
+	m.Start("delete", "gcp", "vm1", func(ctx context.Context) (interface{}, error) {
+		Progress(ctx, 0, "deleting")
+		return nil, errors.New("quota exceeded")
+	})
+	got := wait(t, done)
+	if got.Status != models.OperationFailed || got.Error != "quota exceeded" || got.Percent != 0 || got.VMID != "vm1" {
+		t.Errorf("unexpected finished operation %+v", got)
+	}
+}
+
+func TestOperationCancel(t *testing.T) {
+	m, done := finished()
+	started := make(chan struct{})
+	op := m.Start("create", "azure", "", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	if _, ok := m.Cancel(op.ID); !ok {
+		t.Fatal("operation not found")
+	}
+	got := wait(t, done)
+	if got.Status != models.OperationCancelled || got.Error != context.Canceled.Error() {
+		t.Errorf("unexpected cancelled operation %+v", got)
+	}
+	if _, ok := m.Cancel("unknown"); ok {
+		t.Error("unknown operation cancelled")
+	}
+}
+
+func TestOperationPrune(t *testing.T) {
+	m, done := finished()
+	old := m.Start("create", "aws", "", func(ctx context.Context) (interface{}, error) { return nil, nil })
+	recent := m.Start("create", "aws", "", func(ctx context.Context) (interface{}, error) { return nil, nil })
+	wait(t, done)
+	wait(t, done)
+
+	m.mu.Lock()
+	expired := time.Now().Add(-retention - time.Minute)
+	m.ops[old.ID].op.FinishedAt = &expired
+	m.mu.Unlock()
+
+	block := make(chan struct{})
+	running := m.Start("create", "aws", "", func(ctx context.Context) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	defer close(block)
+	if _, ok := m.Get(old.ID); ok {
+		t.Error("operation finished before the retention was kept")
+	}
+	if _, ok := m.Get(recent.ID); !ok {
+		t.Error("recently finished operation was pruned")
+	}
+	if _, ok := m.Get(running.ID); !ok {
+		t.Error("running operation not found")
+	}
+}
+
+func TestProgressOutsideOperation(t *testing.T) {
+	// Must not panic.
+	Progress(context.Background(), 50, "nothing to report to")
+}
@@ -0,0 +1,219 @@
+// Package policy checks create requests against the policies of the
+// configuration before a provider is called.
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+)
+
+// OwnerTag records who created a VM, so the VMs of a caller can be counted
+// for maxVMsPerUser.
+const OwnerTag = "anyvm-owner"
+
+// Caller is who makes a create request.
+type Caller struct {
+	Subject string
+	Name    string
+	Groups  []string
+}
+
+var ownerPattern = regexp.MustCompile(`^[a-z0-9_-]{1,63}$`)
+
+// Owner returns the OwnerTag value of the caller's VMs. It is the subject,
+// which unlike the name can't be changed by the caller and is unique, or a
+// hash of it if it doesn't fit the rules of GCP label values.
+func (c Caller) Owner() string {
+	if ownerPattern.MatchString(c.Subject) {
+		return c.Subject
+	}
+	sum := sha256.Sum256([]byte(c.Subject))
+	return "sha256-" + hex.EncodeToString(sum[:20])
+}
+
+// String returns the name of the caller for messages, or its subject if it
+// has none.
+func (c Caller) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Subject
+}
+
+// Evaluate checks a request to create spec with the named provider against
+// every policy that applies to it and reports every rule it doesn't meet.
+// caller is nil when authentication is disabled; policies for groups don't
+// apply then.
+//
+// spec is changed the way the policies demand: requirements are resolved to
+// a size where sizes are restricted, so the size that is checked is the one
+// created, public IPs are turned off where they are forbidden and VMs get the
+// owner tag where VMs per user are limited.
+func Evaluate(ctx context.Context, cm *providers.CloudManager, policies []config.Policy, provider string, spec *models.VMSpec, caller *Caller) models.PolicyResult {
+	result := models.PolicyResult{Policies: []string{}, Violations: []models.PolicyViolation{}}
+	violate := func(p config.Policy, rule, format string, args ...interface{}) {
+		result.Violations = append(result.Violations, models.PolicyViolation{Policy: p.Name, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var applicable []config.Policy
+	var sizeErr error
+	for _, p := range policies {
+		if !applies(p, provider, caller) {
+			continue
+		}
+		applicable = append(applicable, p)
+		result.Policies = append(result.Policies, p.Name)
+		if len(p.AllowedSizes) > 0 && spec.Requirements != nil && sizeErr == nil {
+			sctx, cancel := cm.ReadContext(ctx, provider)
+			sizeErr = cm.ApplySize(sctx, provider, spec)
+			cancel()
+		}
+	}
+
+	for _, p := range applicable {
+		switch {
+		case len(p.AllowedSizes) == 0:
+		case sizeErr != nil:
+			violate(p, "allowedSizes", "no size could be chosen for the requirements: %v", sizeErr)
+		case !matchAny(p.AllowedSizes, spec.Size):
+			violate(p, "allowedSizes", "%s is not one of the allowed sizes %s", describe("size", spec.Size), strings.Join(p.AllowedSizes, ", "))
+		}
+		if len(p.AllowedImages) > 0 && !matchAny(p.AllowedImages, spec.Image) {
+			violate(p, "allowedImages", "%s is not one of the allowed images %s", describe("image", spec.Image), strings.Join(p.AllowedImages, ", "))
+		}
+		if len(p.AllowedRegions) > 0 && !matchAny(p.AllowedRegions, spec.Region) {
+			violate(p, "allowedRegions", "%s is not one of the allowed regions %s", describe("region", spec.Region), strings.Join(p.AllowedRegions, ", "))
+		}
+		var missing []string
+		for _, key := range p.RequiredTags {
+			if _, ok := spec.Tags[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			violate(p, "requiredTags", "tags %s are required", strings.Join(missing, ", "))
+		}
+		if p.ForbidPublicIP && spec.PublicIP != nil && *spec.PublicIP {
+			violate(p, "forbidPublicIp", "public IP addresses are not allowed")
+		}
+		if p.NamePattern != "" {
+			re, err := regexp.Compile(`^(?:` + p.NamePattern + `)$`)
+			if err != nil || !re.MatchString(spec.Name) {
+				violate(p, "namePattern", "name %q doesn't match %s", spec.Name, p.NamePattern)
+			}
+		}
+		if p.MaxVMsPerUser > 0 && caller != nil {
+			if err := checkVMCount(ctx, cm, p, provider, *caller); err != nil {
+				violate(p, "maxVMsPerUser", "%v", err)
+			}
+		}
+	}
+
+	for _, p := range applicable {
+		if p.ForbidPublicIP && spec.PublicIP == nil {
+			off := false
+			spec.PublicIP = &off
+		}
+		if p.MaxVMsPerUser > 0 && caller != nil {
+			spec.Tags = copyTags(spec.Tags)
+			spec.Tags[OwnerTag] = caller.Owner()
+		}
+	}
+	result.Allowed = len(result.Violations) == 0
+	return result
+}
+
+// applies reports whether policy p applies to a request of caller for the
+// named provider.
+func applies(p config.Policy, provider string, caller *Caller) bool {
+	if len(p.Providers) > 0 && !matchAny(p.Providers, provider) {
+		return false
+	}
+	if len(p.Groups) == 0 {
+		return true
+	}
+	if caller == nil {
+		return false
+	}
+	for _, g := range caller.Groups {
+		for _, want := range p.Groups {
+			if g == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkVMCount returns an error if caller can't create another VM under
+// policy p: it already has MaxVMsPerUser VMs that aren't terminated on the
+// policy's providers, the VMs can't be counted or provider can't carry the
+// owner tag, so the new VM wouldn't be counted.
+func checkVMCount(ctx context.Context, cm *providers.CloudManager, p config.Policy, provider string, caller Caller) error {
+	owner := caller.Owner()
+	if err := providers.ValidateTags(cm.ProviderType(provider), map[string]string{OwnerTag: owner}); err != nil {
+		return fmt.Errorf("VMs per user can't be counted on provider %s: %v", provider, err)
+	}
+	var names []string
+	for name := range cm.GetAllProviders() {
+		if len(p.Providers) == 0 || matchAny(p.Providers, name) {
+			names = append(names, name)
+		}
+	}
+	vms, results := cm.ListAllVMs(ctx, models.VMFilter{Tags: map[string]string{OwnerTag: owner}}, names...)
+	for _, res := range results {
+		if !res.Success {
+			return fmt.Errorf("VMs of %s could not be counted: %s: %s", caller, res.Provider, res.Error)
+		}
+	}
+	count := 0
+	for _, vm := range vms {
+		if vm.Status != models.StatusTerminated {
+			count++
+		}
+	}
+	if count >= p.MaxVMsPerUser {
+		return fmt.Errorf("%s already has %d VMs, at most %d are allowed", caller, count, p.MaxVMsPerUser)
+	}
+	return nil
+}
+
+// matchAny reports whether value matches one of the patterns,
+// case-insensitively. An empty value matches none.
+func matchAny(patterns []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// describe names a requested value for a violation message.
+func describe(field, value string) string {
+	if value == "" {
+		return "the default " + field
+	}
+	return fmt.Sprintf("%s %q", field, value)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
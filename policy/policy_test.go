@@ -0,0 +1,123 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fuddata/anyvm/config"
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/providers"
+)
+
+// fakeProvider lists vms and offers sizes; the other methods are never called.
+type fakeProvider struct {
+	providers.CloudProvider
+	vms   []models.VM
+	sizes []models.Size
+	err   error
+}
+
+func (f *fakeProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	return f.vms, f.err
+}
+
+func (f *fakeProvider) ListSizes(ctx context.Context, region string) ([]models.Size, error) {
+	return f.sizes, nil
+}
+
+func TestEvaluate(t *testing.T) {
+	aws := &fakeProvider{
+		vms: []models.VM{
+			{Name: "a", Status: models.StatusRunning, Tags: map[string]string{OwnerTag: "u1"}},
+			{Name: "b", Status: models.StatusTerminated, Tags: map[string]string{OwnerTag: "u1"}},
+			{Name: "c", Status: models.StatusRunning, Tags: map[string]string{OwnerTag: "bob"}},
+		},
+		sizes: []models.Size{{Name: "t3.small", CPUs: 2, MemoryMB: 2048}, {Name: "m5.xlarge", CPUs: 4, MemoryMB: 16384}},
+	}
+	cm := providers.NewCloudManager(nil)
+	cm.RegisterProvider("aws", "aws", aws)
+	policies := []config.Policy{
+		{Name: "all", NamePattern: "[a-z][a-z0-9-]*", RequiredTags: []string{"team"}},
+		{Name: "dev", Groups: []string{"dev"}, Providers: []string{"aws*"}, AllowedSizes: []string{"t3.*"},
+			AllowedRegions: []string{"eu-*"}, MaxVMsPerUser: 2, ForbidPublicIP: true},
+		{Name: "azure", Providers: []string{"azure"}, AllowedImages: []string{"ubuntu"}},
+	}
+	alice := &Caller{Subject: "u1", Name: "Alice", Groups: []string{"dev"}}
+	public := true
+
+	tests := []struct {
+		name   string
+		spec   models.VMSpec
+		caller *Caller
+		rules  []string
+	}{
+		{"allowed", models.VMSpec{Name: "web-1", Size: "T3.small", Region: "eu-west-1", Tags: map[string]string{"team": "x"}}, alice, nil},
+		{"requirements resolved to an allowed size", models.VMSpec{Name: "web-1", Requirements: &models.SizeRequirements{CPU: 2, MemoryGiB: 2},
+			Region: "eu-west-1", Tags: map[string]string{"team": "x"}}, alice, nil},
+		{"every rule violated", models.VMSpec{Name: "Web_1", Size: "m5.xlarge", Region: "us-east-1", PublicIP: &public}, alice,
+			[]string{"requiredTags", "namePattern", "allowedSizes", "allowedRegions", "forbidPublicIp"}},
+		{"default region", models.VMSpec{Name: "web-1", Size: "t3.small", Tags: map[string]string{"team": "x"}}, alice, []string{"allowedRegions"}},
+		{"group policy without auth", models.VMSpec{Name: "web-1", Size: "m5.xlarge", Tags: map[string]string{"team": "x"}}, nil, nil},
+	}
+	for _, tt := range tests {
+		spec := tt.spec
+		result := Evaluate(context.Background(), cm, policies, "aws", &spec, tt.caller)
+		var rules []string
+		for _, v := range result.Violations {
+			rules = append(rules, v.Rule)
+		}
+		if !reflect.DeepEqual(rules, tt.rules) || result.Allowed != (len(tt.rules) == 0) {
+			t.Errorf("%s: violations %+v, allowed %v", tt.name, result.Violations, result.Allowed)
+		}
+	}
+
+	spec := models.VMSpec{Name: "web-1", Size: "t3.small", Region: "eu-west-1", Tags: map[string]string{"team": "x"}}
+	tags := spec.Tags
+	result := Evaluate(context.Background(), cm, policies, "aws", &spec, alice)
+	if !reflect.DeepEqual(result.Policies, []string{"all", "dev"}) {
+		t.Errorf("policies %v", result.Policies)
+	}
+	if spec.PublicIP == nil || *spec.PublicIP || spec.Tags[OwnerTag] != "u1" || len(tags) != 1 {
+		t.Errorf("spec not changed as the policies demand: public IP %v, tags %v, request tags %v", spec.PublicIP, spec.Tags, tags)
+	}
+
+	aws.vms = append(aws.vms, models.VM{Name: "d", Status: models.StatusStopped, Tags: map[string]string{OwnerTag: "u1"}})
+	spec = models.VMSpec{Name: "web-1", Size: "t3.small", Region: "eu-west-1", Tags: map[string]string{"team": "x"}}
+	if result := Evaluate(context.Background(), cm, policies, "aws", &spec, alice); len(result.Violations) != 1 || result.Violations[0].Rule != "maxVMsPerUser" {
+		t.Errorf("third VM: violations %+v", result.Violations)
+	}
+	aws.err = errors.New("unavailable")
+	if result := Evaluate(context.Background(), cm, policies, "aws", &spec, &Caller{Subject: "u2", Groups: []string{"dev"}}); result.Allowed {
+		t.Error("VMs that can't be counted allowed")
+	}
+}
+
+func TestCallerOwner(t *testing.T) {
+	tests := []struct {
+		caller Caller
+		want   string
+	}{
+		{Caller{Subject: "2f4c9a1e-77b0-4c1d-9e8f-0a1b2c3d4e5f", Name: "Alice"}, "2f4c9a1e-77b0-4c1d-9e8f-0a1b2c3d4e5f"},
+		{Caller{Subject: "apikey:0123"}, "sha256-"},
+		{Caller{Subject: "Alice"}, "sha256-"},
+	}
+	owners := make(map[string]bool)
+	for _, tt := range tests {
+		got := tt.caller.Owner()
+		if !strings.HasPrefix(got, tt.want) || !ownerPattern.MatchString(got) || owners[got] {
+			t.Errorf("%+v: owner %q, want %q", tt.caller, got, tt.want)
+		}
+		owners[got] = true
+	}
+	// Names don't make owners: different users with similar names don't share
+	// one, and changing the name doesn't give a new one.
+	if a, b := (Caller{Subject: "u1", Name: "J Smith"}).Owner(), (Caller{Subject: "u2", Name: "j.smith"}).Owner(); a == b {
+		t.Errorf("users with similar names share owner %q", a)
+	}
+	if a, b := (Caller{Subject: "u1", Name: "old"}).Owner(), (Caller{Subject: "u1", Name: "new"}).Owner(); a != b {
+		t.Errorf("renamed user got owner %q instead of %q", b, a)
+	}
+}
@@ -1,19 +1,48 @@
 package providers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/pricing"
 )
 
 type AWSProvider struct {
-	Client *ec2.EC2
+	Client  *ec2.EC2 // client of the session region
+	sess    *session.Session
+	mapping config.AWSMapping
+	images  map[string]string // image names -> AMI ID or "<owner>/<name pattern>"
+	regions []string          // configured regions to list; ["all"] for every enabled region
+
+	// clients holds an EC2 client per region, regionCache the enabled regions
+	// and instanceRegions the region of each instance seen, all filled on use.
+	clientMu        sync.Mutex
+	clients         map[string]*ec2.EC2
+	regionCache     []string
+	instanceRegions map[string]string
+
+	// types holds the vCPU/memory details of "<region>/<instance type>",
+	// filled on first use.
+	types sizeCache[*ec2.InstanceTypeInfo]
+
+	// priceCache maps region -> instance type -> hourly Linux on-demand
+	// price in USD, filled on first use.
+	priceCache sizeCache[map[string]float64]
 }
 
 func NewAWSProvider(cfg *config.Config) (*AWSProvider, bool) {
@@ -25,31 +54,622 @@ func NewAWSProvider(cfg *config.Config) (*AWSProvider, bool) {
 		fmt.Printf("Failed to active AWS provider. Will continue without it. Error: %v\r\n", err)
 		return nil, false
 	}
-	return &AWSProvider{Client: ec2.New(sess)}, true
+	client := ec2.New(sess)
+	return &AWSProvider{
+		Client:          client,
+		sess:            sess,
+		mapping:         cfg.Mappings.AWS,
+		images:          cfg.ImageAliases("aws"),
+		regions:         cfg.AWSCreds.Regions,
+		clients:         map[string]*ec2.EC2{cfg.AWSCreds.Region: client},
+		instanceRegions: make(map[string]string),
+	}, true
+}
+
+// client returns the EC2 client of a region; "" is the session region.
+func (p *AWSProvider) client(region string) *ec2.EC2 {
+	if region == "" {
+		return p.Client
+	}
+	p.clientMu.Lock()
+	defer p.clientMu.Unlock()
+	c, ok := p.clients[region]
+	if !ok {
+		c = ec2.New(p.sess, aws.NewConfig().WithRegion(region))
+		p.clients[region] = c
+	}
+	return c
+}
+
+// listRegions returns the regions instances are listed in: the configured
+// ones, every region enabled for the account, or the session region.
+func (p *AWSProvider) listRegions(ctx context.Context) ([]string, error) {
+	if len(p.regions) == 0 {
+		return []string{aws.StringValue(p.Client.Config.Region)}, nil
+	}
+	if len(p.regions) != 1 || p.regions[0] != "all" {
+		return p.regions, nil
+	}
+
+	p.clientMu.Lock()
+	cached := p.regionCache
+	p.clientMu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+	// Without AllRegions only the regions enabled for the account are returned.
+	out, err := p.Client.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+	var regions []string
+	for _, r := range out.Regions {
+		regions = append(regions, aws.StringValue(r.RegionName))
+	}
+	sort.Strings(regions)
+	p.clientMu.Lock()
+	p.regionCache = regions
+	p.clientMu.Unlock()
+	return regions, nil
 }
 
 // POST https://ec2.eu-west-3.amazonaws.com
 // Action=DescribeInstances&Version=2016-11-15
-func (p *AWSProvider) ListVMs() ([]models.VM, error) {
-	result, err := p.Client.DescribeInstances(nil)
+//
+// ListVMs queries every region concurrently. Regions that fail, e.g. because
+// a policy denies them, are logged and skipped unless all of them fail.
+func (p *AWSProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	regions, err := p.listRegions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.listVMs(ctx, regions, nil)
+}
+
+// ListVMsFiltered only queries the regions the filter's region can be in and
+// passes its statuses, availability zone, instance type and tags on as
+// DescribeInstances filters.
+func (p *AWSProvider) ListVMsFiltered(ctx context.Context, filter models.VMFilter) ([]models.VM, error) {
+	regions, err := p.listRegions(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if filter.Region != "" {
+		zone := strings.ToLower(filter.Region)
+		var matching []string
+		for _, region := range regions {
+			if strings.HasPrefix(region, zone) || strings.HasPrefix(zone, region) {
+				matching = append(matching, region)
+			}
+		}
+		if len(matching) == 0 {
+			return nil, nil
+		}
+		regions = matching
+	}
+
+	var filters []*ec2.Filter
+	add := func(name string, values ...string) {
+		filters = append(filters, &ec2.Filter{Name: aws.String(name), Values: aws.StringSlice(values)})
+	}
+	if len(filter.Statuses) > 0 {
+		var states []string
+		for _, state := range ec2.InstanceStateName_Values() {
+			if filter.HasStatus(awsStatus(state)) {
+				states = append(states, state)
+			}
+		}
+		if len(states) == 0 {
+			return nil, nil
+		}
+		add("instance-state-name", states...)
+	}
+	if filter.Region != "" {
+		add("availability-zone", strings.ToLower(filter.Region)+"*")
+	}
+	if filter.Size != "" {
+		add("instance-type", strings.ToLower(filter.Size))
+	}
+	for key, value := range filter.Tags {
+		if value == "" {
+			add("tag-key", key)
+		} else {
+			add("tag:"+key, value)
+		}
+	}
+	return p.listVMs(ctx, regions, filters)
+}
+
+// listVMs lists the instances matching filters in regions.
+func (p *AWSProvider) listVMs(ctx context.Context, regions []string, filters []*ec2.Filter) ([]models.VM, error) {
+
+	vmsByRegion := make([][]models.VM, len(regions))
+	errs := make([]error, len(regions))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+			err := p.client(region).DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{Filters: filters},
+				func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+					for _, res := range page.Reservations {
+						for _, inst := range res.Instances {
+							p.rememberRegion(aws.StringValue(inst.InstanceId), region)
+							vmsByRegion[i] = append(vmsByRegion[i], p.toModel(ctx, region, inst))
+						}
+					}
+					return true
+				})
+			if err != nil {
+				// Don't report a region's instances when only some pages arrived.
+				vmsByRegion[i] = nil
+				errs[i] = fmt.Errorf("%s: %w", region, err)
+			}
+		}(i, region)
+	}
+	wg.Wait()
+
 	var vms []models.VM
-	for _, res := range result.Reservations {
-		for _, inst := range res.Instances {
-			vms = append(vms, models.VM{
-				ID:       *inst.InstanceId,
-				Name:     getTagValue(inst.Tags, "Name"),
-				Provider: "aws",
-				Region:   *inst.Placement.AvailabilityZone,
-				Status:   *inst.State.Name,
-			})
+	failed := 0
+	for i := range regions {
+		if errs[i] != nil {
+			failed++
+			fmt.Printf("Listing AWS instances in %v\r\n", errs[i])
 		}
+		vms = append(vms, vmsByRegion[i]...)
+	}
+	if failed == len(regions) {
+		return nil, errors.Join(errs...)
 	}
 	return vms, nil
 }
 
+func (p *AWSProvider) rememberRegion(id, region string) {
+	p.clientMu.Lock()
+	defer p.clientMu.Unlock()
+	p.instanceRegions[id] = region
+}
+
+// instanceClient finds the region of an instance and returns its client.
+// Instances that haven't been listed yet are looked up in every region.
+func (p *AWSProvider) instanceClient(ctx context.Context, id string) (*ec2.EC2, error) {
+	p.clientMu.Lock()
+	region, ok := p.instanceRegions[id]
+	p.clientMu.Unlock()
+	if ok {
+		return p.client(region), nil
+	}
+
+	regions, err := p.listRegions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := make(chan string, len(regions))
+	var wg sync.WaitGroup
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			out, err := p.client(region).DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+				InstanceIds: aws.StringSlice([]string{id}),
+			})
+			if err == nil && len(out.Reservations) > 0 {
+				found <- region
+			}
+		}(region)
+	}
+	wg.Wait()
+	close(found)
+	region, ok = <-found
+	if !ok {
+		return nil, fmt.Errorf("AWS instance %q not found in %s", id, strings.Join(regions, ", "))
+	}
+	p.rememberRegion(id, region)
+	return p.client(region), nil
+}
+
+// awsRegionPattern matches the region at the start of an availability or local zone.
+var awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+`)
+
+// awsRegion returns the region of a region, availability zone or local zone name.
+func awsRegion(zone string) string {
+	return awsRegionPattern.FindString(zone)
+}
+
+// CreateVM launches a single instance from an image name, AMI ID or
+// "<owner>/<name pattern>" reference (see resolveImage) in the region or
+// availability zone spec.Region, by default the mapping's default region or
+// else the session region.
+func (p *AWSProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	zone := spec.Region
+	if zone == "" {
+		zone = p.mapping.DefaultRegion
+	}
+	region := awsRegion(zone)
+	if zone != "" && region == "" {
+		return nil, fmt.Errorf("invalid AWS region or availability zone %q", zone)
+	}
+	client := p.client(region)
+
+	// Supply defaults if not provided.
+	imageID := spec.Image
+	if imageID == "" {
+		imageID = "ubuntu24"
+	}
+	instanceType := spec.Size
+	if instanceType == "" {
+		instanceType = "small"
+	}
+
+	// Map custom instance type and image ID.
+	if mapped, ok := p.mapping.CustomVMSizes[strings.ToLower(instanceType)]; ok {
+		instanceType = mapped
+	}
+	imageID, err := p.resolveImage(ctx, client, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.RunInstancesInput{
+		ImageId:      aws.String(imageID),
+		InstanceType: aws.String(instanceType),
+		MinCount:     aws.Int64(1),
+		MaxCount:     aws.Int64(1),
+		// The Name tag is what the console and ListVMs show as the name.
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Tags:         awsTags(mergeTags(spec.Tags, map[string]string{"Name": spec.Name}, nil)),
+		}},
+	}
+	if zone != region {
+		input.Placement = &ec2.Placement{AvailabilityZone: aws.String(zone)}
+	}
+	// Without a key pair or security groups EC2 launches the instance without
+	// a key and in the VPC's default security group.
+	keyName := spec.KeyName
+	if keyName == "" {
+		keyName = p.mapping.DefaultKeyName
+	}
+	if keyName != "" {
+		input.KeyName = aws.String(keyName)
+	}
+	securityGroups := spec.SecurityGroupIDs
+	if len(securityGroups) == 0 {
+		securityGroups = p.mapping.DefaultSecurityGroupIDs
+	}
+	if len(securityGroups) > 0 {
+		input.SecurityGroupIds = aws.StringSlice(securityGroups)
+	}
+	if spec.Network != "" {
+		input.SubnetId = aws.String(spec.Network)
+	}
+	if spec.PublicIP != nil {
+		// A public IP can only be requested for a network interface, which
+		// then takes the subnet and security groups.
+		input.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex:              aws.Int64(0),
+			AssociatePublicIpAddress: spec.PublicIP,
+			SubnetId:                 input.SubnetId,
+			Groups:                   input.SecurityGroupIds,
+		}}
+		input.SubnetId, input.SecurityGroupIds = nil, nil
+	}
+	if spec.DiskSizeGB > 0 {
+		// The root volume is resized through the AMI's root device name.
+		images, err := client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+			ImageIds: aws.StringSlice([]string{imageID}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(images.Images) == 0 {
+			return nil, fmt.Errorf("image %s not found", imageID)
+		}
+		input.BlockDeviceMappings = []*ec2.BlockDeviceMapping{{
+			DeviceName: images.Images[0].RootDeviceName,
+			Ebs:        &ec2.EbsBlockDevice{VolumeSize: aws.Int64(spec.DiskSizeGB)},
+		}}
+	}
+
+	res, err := client.RunInstancesWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Instances) == 0 {
+		return nil, fmt.Errorf("RunInstances returned no instance")
+	}
+	id := aws.StringValue(res.Instances[0].InstanceId)
+	p.rememberRegion(id, aws.StringValue(client.Config.Region))
+	if err := p.waitInstance(ctx, id, "running", client.WaitUntilInstanceRunningWithContext); err != nil {
+		return nil, err
+	}
+	return p.GetVM(ctx, id)
+}
+
+// Images returns the image names and their AMI IDs or name patterns.
+func (p *AWSProvider) Images() map[string]string {
+	return p.images
+}
+
+// ResolveImage returns the AMI an image name or reference stands for in the
+// session region.
+func (p *AWSProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	return p.resolveImage(ctx, p.Client, image)
+}
+
+// POST https://ec2.eu-west-3.amazonaws.com
+// Action=DescribeImages&Owner.1=099720109477&Filter.1.Name=name&Filter.1.Value.1=ubuntu/images/...
+//
+// resolveImage looks up "<owner>/<name pattern>" references in the region
+// of client and returns the newest available AMI matching the pattern; AMIs
+// differ per region. AMI IDs are returned as they are.
+func (p *AWSProvider) resolveImage(ctx context.Context, client *ec2.EC2, image string) (string, error) {
+	ref := imageAlias(p.images, image)
+	owner, name, ok := strings.Cut(ref, "/")
+	if !ok {
+		return ref, nil
+	}
+	out, err := client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		Owners: aws.StringSlice([]string{owner}),
+		Filters: []*ec2.Filter{
+			{Name: aws.String("name"), Values: aws.StringSlice([]string{name})},
+			{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.ImageStateAvailable})},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	var newest *ec2.Image
+	for _, img := range out.Images {
+		// CreationDate is an ISO 8601 timestamp, so it sorts as a string.
+		if newest == nil || aws.StringValue(img.CreationDate) > aws.StringValue(newest.CreationDate) {
+			newest = img
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no AMI of owner %s matches %q in %s", owner, name, aws.StringValue(client.Config.Region))
+	}
+	return aws.StringValue(newest.ImageId), nil
+}
+
+// GetVM returns the instance including the sizes of its EBS volumes.
+func (p *AWSProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("AWS instance %q not found", id)
+	}
+	vm := p.toModel(ctx, aws.StringValue(client.Config.Region), out.Reservations[0].Instances[0])
+
+	var volumeIDs []string
+	for _, d := range vm.Disks {
+		volumeIDs = append(volumeIDs, d.ID)
+	}
+	if len(volumeIDs) > 0 {
+		vols, err := client.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice(volumeIDs)})
+		if err == nil {
+			for _, vol := range vols.Volumes {
+				for i := range vm.Disks {
+					if vm.Disks[i].ID == aws.StringValue(vol.VolumeId) {
+						vm.Disks[i].SizeGB = aws.Int64Value(vol.Size)
+					}
+				}
+			}
+		}
+	}
+	return &vm, nil
+}
+
+// toModel converts an EC2 instance of the given region to the unified model.
+// Region is set to the instance's availability zone.
+func (p *AWSProvider) toModel(ctx context.Context, region string, inst *ec2.Instance) models.VM {
+	vm := models.VM{
+		ID:        aws.StringValue(inst.InstanceId),
+		Name:      getTagValue(inst.Tags, "Name"),
+		Provider:  "aws",
+		Size:      aws.StringValue(inst.InstanceType),
+		Image:     aws.StringValue(inst.ImageId),
+		OSType:    "linux",
+		CreatedAt: inst.LaunchTime,
+	}
+	if inst.Placement != nil {
+		vm.Region = aws.StringValue(inst.Placement.AvailabilityZone)
+	}
+	if inst.State != nil {
+		vm.RawStatus = aws.StringValue(inst.State.Name)
+		vm.Status = awsStatus(vm.RawStatus)
+	}
+	if aws.StringValue(inst.Platform) == "windows" {
+		vm.OSType = "windows"
+	}
+	if info := p.lookupInstanceType(ctx, region, vm.Size); info != nil {
+		if info.VCpuInfo != nil {
+			vm.CPUs = int(aws.Int64Value(info.VCpuInfo.DefaultVCpus))
+		}
+		if info.MemoryInfo != nil {
+			vm.MemoryMB = aws.Int64Value(info.MemoryInfo.SizeInMiB)
+		}
+	}
+	for _, bdm := range inst.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+		vm.Disks = append(vm.Disks, models.Disk{
+			ID:   aws.StringValue(bdm.Ebs.VolumeId),
+			Name: aws.StringValue(bdm.DeviceName),
+			Boot: aws.StringValue(bdm.DeviceName) == aws.StringValue(inst.RootDeviceName),
+		})
+	}
+	for _, eni := range inst.NetworkInterfaces {
+		for _, addr := range eni.PrivateIpAddresses {
+			vm.PrivateIPs = append(vm.PrivateIPs, aws.StringValue(addr.PrivateIpAddress))
+			if addr.Association != nil && addr.Association.PublicIp != nil {
+				vm.PublicIPs = append(vm.PublicIPs, aws.StringValue(addr.Association.PublicIp))
+			}
+		}
+	}
+	if len(inst.Tags) > 0 {
+		vm.Tags = make(map[string]string, len(inst.Tags))
+		for _, tag := range inst.Tags {
+			vm.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return vm
+}
+
+// lookupInstanceType returns the vCPU and memory details of an instance type.
+func (p *AWSProvider) lookupInstanceType(ctx context.Context, region, instanceType string) *ec2.InstanceTypeInfo {
+	info, _ := p.types.get(ctx, region+"/"+instanceType, func() (*ec2.InstanceTypeInfo, error) {
+		out, err := p.client(region).DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{
+			InstanceTypes: aws.StringSlice([]string{instanceType}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(out.InstanceTypes) == 0 {
+			return nil, fmt.Errorf("instance type %s not found", instanceType)
+		}
+		return out.InstanceTypes[0], nil
+	})
+	return info
+}
+
+// POST https://ec2.eu-west-3.amazonaws.com
+// Action=DescribeInstanceTypes&Version=2016-11-15
+//
+// ListSizes returns the instance types of the region or availability zone
+// region, by default the mapping's default region or else the session region,
+// with their on-demand Linux prices where the Price List API allows reading
+// them.
+func (p *AWSProvider) ListSizes(ctx context.Context, region string) ([]models.Size, error) {
+	if region == "" {
+		region = p.mapping.DefaultRegion
+	}
+	client := p.client(awsRegion(region))
+	region = aws.StringValue(client.Config.Region)
+
+	aliases := sizeAliases(p.mapping.CustomVMSizes)
+	var sizes []models.Size
+	err := client.DescribeInstanceTypesPagesWithContext(ctx, &ec2.DescribeInstanceTypesInput{},
+		func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+			for _, info := range page.InstanceTypes {
+				name := aws.StringValue(info.InstanceType)
+				p.types.set(region+"/"+name, info)
+				size := models.Size{Name: name, Arch: awsArch(info), Aliases: aliases[name]}
+				if info.VCpuInfo != nil {
+					size.CPUs = int(aws.Int64Value(info.VCpuInfo.DefaultVCpus))
+				}
+				if info.MemoryInfo != nil {
+					size.MemoryMB = aws.Int64Value(info.MemoryInfo.SizeInMiB)
+				}
+				sizes = append(sizes, size)
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	prices := p.prices(ctx, region)
+	for i := range sizes {
+		sizes[i].HourlyPriceUSD = prices[sizes[i].Name]
+	}
+	return sizes, nil
+}
+
+// awsArch returns the architecture of an instance type, preferring x86_64
+// for types that also run 32-bit images.
+func awsArch(info *ec2.InstanceTypeInfo) string {
+	if info.ProcessorInfo == nil || len(info.ProcessorInfo.SupportedArchitectures) == 0 {
+		return ""
+	}
+	archs := aws.StringValueSlice(info.ProcessorInfo.SupportedArchitectures)
+	for _, arch := range archs {
+		if arch == ec2.ArchitectureTypeX8664 || arch == ec2.ArchitectureTypeArm64 {
+			return arch
+		}
+	}
+	return archs[0]
+}
+
+// awsPricingRegion is a region that serves the AWS Price List API.
+const awsPricingRegion = "us-east-1"
+
+// POST https://api.pricing.us-east-1.amazonaws.com
+// X-Amz-Target: AWSPriceListService.GetProducts
+//
+// prices returns the hourly on-demand price of each instance type in region
+// for Linux on shared hosts. Failures, e.g. missing pricing:GetProducts
+// permissions, are logged and leave the prices unknown until the lookup is
+// retried.
+func (p *AWSProvider) prices(ctx context.Context, region string) map[string]float64 {
+	prices, _ := p.priceCache.get(ctx, region, func() (map[string]float64, error) {
+		return p.fetchPrices(ctx, region)
+	})
+	return prices
+}
+
+// fetchPrices reads the prices of region from the Price List API.
+func (p *AWSProvider) fetchPrices(ctx context.Context, region string) (map[string]float64, error) {
+	filter := func(field, value string) *pricing.Filter {
+		return &pricing.Filter{Type: aws.String(pricing.FilterTypeTermMatch), Field: aws.String(field), Value: aws.String(value)}
+	}
+	prices := make(map[string]float64)
+	err := pricing.New(p.sess, aws.NewConfig().WithRegion(awsPricingRegion)).GetProductsPagesWithContext(ctx,
+		&pricing.GetProductsInput{
+			ServiceCode: aws.String("AmazonEC2"),
+			Filters: []*pricing.Filter{
+				filter("regionCode", region),
+				filter("operatingSystem", "Linux"),
+				filter("tenancy", "Shared"),
+				filter("preInstalledSw", "NA"),
+				filter("licenseModel", "No License required"),
+				filter("capacitystatus", "Used"),
+			},
+		},
+		func(page *pricing.GetProductsOutput, lastPage bool) bool {
+			for _, product := range page.PriceList {
+				if instanceType, price, ok := awsOnDemandPrice(product); ok {
+					prices[instanceType] = price
+				}
+			}
+			return true
+		})
+	if err != nil {
+		fmt.Printf("Reading AWS prices of %s failed. Will continue without them. Error: %v\r\n", region, err)
+		return nil, err
+	}
+	return prices, nil
+}
+
+// awsOnDemandPrice returns the instance type and hourly on-demand price in
+// USD of a Price List product.
+func awsOnDemandPrice(product aws.JSONValue) (instanceType string, price float64, ok bool) {
+	field := func(v interface{}, key string) interface{} {
+		m, _ := v.(map[string]interface{})
+		return m[key]
+	}
+	instanceType, _ = field(field(product["product"], "attributes"), "instanceType").(string)
+	onDemand, _ := field(product["terms"], "OnDemand").(map[string]interface{})
+	for _, term := range onDemand {
+		dimensions, _ := field(term, "priceDimensions").(map[string]interface{})
+		for _, dimension := range dimensions {
+			usd, _ := field(field(dimension, "pricePerUnit"), "USD").(string)
+			if price, err := strconv.ParseFloat(usd, 64); err == nil && price > 0 {
+				return instanceType, price, instanceType != ""
+			}
+		}
+	}
+	return "", 0, false
+}
+
 func getTagValue(tags []*ec2.Tag, key string) string {
 	for _, tag := range tags {
 		if *tag.Key == key {
@@ -58,3 +678,232 @@ func getTagValue(tags []*ec2.Tag, key string) string {
 	}
 	return ""
 }
+
+// waitInstance blocks on one of the SDK's instance state waiters, reporting
+// the state it waits for to the operation running with ctx.
+func (p *AWSProvider) waitInstance(ctx context.Context, id, state string, wait func(aws.Context, *ec2.DescribeInstancesInput, ...request.WaiterOption) error) error {
+	operations.Progress(ctx, 0, "waiting for instance %s to be %s", id, state)
+	return wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{id})})
+}
+
+func (p *AWSProvider) StartVM(ctx context.Context, id string) error {
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = client.StartInstancesWithContext(ctx, &ec2.StartInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+	})
+	if err != nil {
+		return err
+	}
+	return p.waitInstance(ctx, id, "running", client.WaitUntilInstanceRunningWithContext)
+}
+
+func (p *AWSProvider) StopVM(ctx context.Context, id string, force bool) error {
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+		Force:       aws.Bool(force),
+	})
+	if err != nil {
+		return err
+	}
+	return p.waitInstance(ctx, id, "stopped", client.WaitUntilInstanceStoppedWithContext)
+}
+
+// CheckPower refuses hard restarts, which EC2 doesn't have.
+func (p *AWSProvider) CheckPower(action string, force bool) error {
+	if action == "restart" && force {
+		return notSupported("aws", "hard restart")
+	}
+	return nil
+}
+
+// RestartVM reboots the instance through the guest OS. EC2 has no hard reset.
+func (p *AWSProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	if err := p.CheckPower("restart", force); err != nil {
+		return err
+	}
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = client.RebootInstancesWithContext(ctx, &ec2.RebootInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+	})
+	return err
+}
+
+// UpdateTags creates or overwrites the tags in set and deletes the keys in remove.
+func (p *AWSProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(remove) > 0 {
+		var tags []*ec2.Tag
+		for _, key := range remove {
+			tags = append(tags, &ec2.Tag{Key: aws.String(key)})
+		}
+		if _, err := client.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+			Resources: aws.StringSlice([]string{id}),
+			Tags:      tags,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(set) > 0 {
+		if _, err := client.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: aws.StringSlice([]string{id}),
+			Tags:      awsTags(set),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awsTags converts tags to EC2 tags, sorted by key.
+func awsTags(tags map[string]string) []*ec2.Tag {
+	var out []*ec2.Tag
+	for _, key := range sortedKeys(tags) {
+		out = append(out, &ec2.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return out
+}
+
+// SuspendVM hibernates the instance. Hibernation must be enabled at launch.
+func (p *AWSProvider) SuspendVM(ctx context.Context, id string) error {
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+		Hibernate:   aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	return p.waitInstance(ctx, id, "stopped", client.WaitUntilInstanceStoppedWithContext)
+}
+
+// DeleteVM terminates the instance. With cascade every EBS volume and network
+// interface is flagged DeleteOnTermination first, and Elastic IPs associated
+// with the instance are released.
+func (p *AWSProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	client, err := p.instanceClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("AWS instance %q not found", id)
+	}
+	inst := out.Reservations[0].Instances[0]
+
+	var results []models.DeletedResource
+	var addresses []*ec2.Address
+	if cascade {
+		var mappings []*ec2.InstanceBlockDeviceMappingSpecification
+		for _, bdm := range inst.BlockDeviceMappings {
+			if bdm.Ebs != nil && !aws.BoolValue(bdm.Ebs.DeleteOnTermination) {
+				mappings = append(mappings, &ec2.InstanceBlockDeviceMappingSpecification{
+					DeviceName: bdm.DeviceName,
+					Ebs:        &ec2.EbsInstanceBlockDeviceSpecification{DeleteOnTermination: aws.Bool(true)},
+				})
+			}
+		}
+		if len(mappings) > 0 {
+			if _, err := client.ModifyInstanceAttributeWithContext(ctx, &ec2.ModifyInstanceAttributeInput{
+				InstanceId:          inst.InstanceId,
+				BlockDeviceMappings: mappings,
+			}); err != nil {
+				return nil, fmt.Errorf("failed to set DeleteOnTermination on volumes: %w", err)
+			}
+			for _, bdm := range inst.BlockDeviceMappings {
+				if bdm.Ebs != nil {
+					bdm.Ebs.DeleteOnTermination = aws.Bool(true)
+				}
+			}
+		}
+		for _, eni := range inst.NetworkInterfaces {
+			if eni.Attachment == nil || aws.BoolValue(eni.Attachment.DeleteOnTermination) {
+				continue
+			}
+			_, err := client.ModifyNetworkInterfaceAttributeWithContext(ctx, &ec2.ModifyNetworkInterfaceAttributeInput{
+				NetworkInterfaceId: eni.NetworkInterfaceId,
+				Attachment: &ec2.NetworkInterfaceAttachmentChanges{
+					AttachmentId:        eni.Attachment.AttachmentId,
+					DeleteOnTermination: aws.Bool(true),
+				},
+			})
+			if err != nil {
+				results = append(results, deletedResource("nic", aws.StringValue(eni.NetworkInterfaceId), err))
+				continue
+			}
+			eni.Attachment.DeleteOnTermination = aws.Bool(true)
+		}
+		addrs, err := client.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice([]string{id})}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe Elastic IPs: %w", err)
+		}
+		addresses = addrs.Addresses
+	}
+
+	if _, err := client.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: aws.StringSlice([]string{id}),
+	}); err != nil {
+		return []models.DeletedResource{deletedResource("vm", id, err)}, err
+	}
+	if err := p.waitInstance(ctx, id, "terminated", client.WaitUntilInstanceTerminatedWithContext); err != nil {
+		return []models.DeletedResource{deletedResource("vm", id, err)}, err
+	}
+	results = append([]models.DeletedResource{deletedResource("vm", id, nil)}, results...)
+
+	// Everything flagged DeleteOnTermination is removed by EC2 along with the instance.
+	for _, bdm := range inst.BlockDeviceMappings {
+		if bdm.Ebs != nil && aws.BoolValue(bdm.Ebs.DeleteOnTermination) {
+			results = append(results, deletedResource("disk", aws.StringValue(bdm.Ebs.VolumeId), nil))
+		}
+	}
+	for _, eni := range inst.NetworkInterfaces {
+		if eni.Attachment != nil && aws.BoolValue(eni.Attachment.DeleteOnTermination) {
+			results = append(results, deletedResource("nic", aws.StringValue(eni.NetworkInterfaceId), nil))
+		}
+	}
+	// Termination already removed the associations of the Elastic IPs.
+	for _, addr := range addresses {
+		_, err := client.ReleaseAddressWithContext(ctx, &ec2.ReleaseAddressInput{AllocationId: addr.AllocationId})
+		results = append(results, deletedResource("publicIp", aws.StringValue(addr.PublicIp), err))
+	}
+	return results, nil
+}
+
+// awsStatus maps an EC2 instance state name to the normalized status.
+func awsStatus(state string) models.VMStatus {
+	switch state {
+	case ec2.InstanceStateNamePending:
+		return models.StatusStarting
+	case ec2.InstanceStateNameRunning:
+		return models.StatusRunning
+	case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameStopping:
+		return models.StatusStopping
+	case ec2.InstanceStateNameStopped:
+		return models.StatusStopped
+	case ec2.InstanceStateNameTerminated:
+		return models.StatusTerminated
+	}
+	return models.StatusUnknown
+}
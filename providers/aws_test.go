@@ -0,0 +1,19 @@
+package providers
+
+import "testing"
+
+func TestAWSRegion(t *testing.T) {
+	for zone, want := range map[string]string{
+		"eu-west-3":        "eu-west-3",
+		"eu-west-3a":       "eu-west-3",
+		"us-gov-west-1b":   "us-gov-west-1",
+		"us-west-2-lax-1a": "us-west-2",
+		"ap-southeast-4c":  "ap-southeast-4",
+		"westeurope":       "",
+		"":                 "",
+	} {
+		if got := awsRegion(zone); got != want {
+			t.Errorf("awsRegion(%q) = %q, want %q", zone, got, want)
+		}
+	}
+}
@@ -2,73 +2,872 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+	"github.com/Azure/go-autorest/autorest/to"
 )
 
 type AzureProvider struct {
-	client *armcompute.VirtualMachinesClient
+	client    *armcompute.VirtualMachinesClient
+	sizes     *armcompute.VirtualMachineSizesClient
+	disks     *armcompute.DisksClient
+	nics      *armnetwork.InterfacesClient
+	publicIPs *armnetwork.PublicIPAddressesClient
+	versions  *armcompute.VirtualMachineImagesClient
+	mapping   config.AzureMapping
+	images    map[string]string // image names -> "Publisher:Offer:SKU:Version"
+
+	// sizeDetails maps location -> VM size name -> size, filled on first use.
+	sizeDetails sizeCache[map[string]*armcompute.VirtualMachineSize]
+
+	// priceCache maps location -> VM size name -> hourly Linux price in USD,
+	// filled on first use.
+	priceCache sizeCache[map[string]float64]
 }
 
 func NewAzureProvider(cfg *config.Config) (*AzureProvider, bool) {
 	cred, err := azidentity.NewClientSecretCredential(cfg.AzureCreds.TenantID, cfg.AzureCreds.ClientID, cfg.AzureCreds.ClientSecret, nil)
 	if err != nil {
-		fmt.Printf("Failed to active Azure provider. Will continue without it. Error: %v\r\n", err)
-		return nil, false
+		return azureInitFailed(err)
 	}
 	subscriptionID := cfg.AzureCreds.SubscriptionID
 	if subscriptionID == "" {
-		panic("Azure subscription ID is not provided")
+		fmt.Printf("Azure subscription ID is not provided. Will continue without it.\r\n")
+		return nil, false
 	}
 	client, err := armcompute.NewVirtualMachinesClient(subscriptionID, cred, nil)
 	if err != nil {
-		panic(err)
+		return azureInitFailed(err)
+	}
+	sizes, err := armcompute.NewVirtualMachineSizesClient(subscriptionID, cred, nil)
+	if err != nil {
+		return azureInitFailed(err)
+	}
+	disks, err := armcompute.NewDisksClient(subscriptionID, cred, nil)
+	if err != nil {
+		return azureInitFailed(err)
+	}
+	nics, err := armnetwork.NewInterfacesClient(subscriptionID, cred, nil)
+	if err != nil {
+		return azureInitFailed(err)
+	}
+	publicIPs, err := armnetwork.NewPublicIPAddressesClient(subscriptionID, cred, nil)
+	if err != nil {
+		return azureInitFailed(err)
+	}
+	versions, err := armcompute.NewVirtualMachineImagesClient(subscriptionID, cred, nil)
+	if err != nil {
+		return azureInitFailed(err)
 	}
-	return &AzureProvider{client: client}, true
+	return &AzureProvider{
+		client:    client,
+		sizes:     sizes,
+		disks:     disks,
+		nics:      nics,
+		publicIPs: publicIPs,
+		versions:  versions,
+		mapping:   cfg.Mappings.Azure,
+		images:    cfg.ImageAliases("azure"),
+	}, true
 }
 
-// GET https://management.azure.com/subscriptions/<subcription id>/providers/Microsoft.Compute/virtualMachines?api-version=2022-03-01
-func (p *AzureProvider) ListVMs() ([]models.VM, error) {
-	ctx := context.Background()
-	var vms []models.VM
+// azureInitFailed reports why the Azure provider is left out.
+func azureInitFailed(err error) (*AzureProvider, bool) {
+	fmt.Printf("Failed to active Azure provider. Will continue without it. Error: %v\r\n", err)
+	return nil, false
+}
 
+// GET https://management.azure.com/subscriptions/<subcription id>/providers/Microsoft.Compute/virtualMachines?api-version=2022-03-01
+func (p *AzureProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	var vms []*armcompute.VirtualMachine
 	pager := p.client.NewListAllPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, page.Value...)
+	}
+	powerStates, err := p.allPowerStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.toModels(ctx, vms, powerStates), nil
+}
+
+// ListVMsFiltered lists only the VMs of the filter's resource group or, when
+// it has none, of the location named by its region, which has to be a whole
+// location name such as "westeurope". Other filters list the subscription.
+func (p *AzureProvider) ListVMsFiltered(ctx context.Context, filter models.VMFilter) ([]models.VM, error) {
+	var vms []*armcompute.VirtualMachine
+	switch {
+	case filter.ResourceGroup != "":
+		pager := p.client.NewListPager(filter.ResourceGroup, nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			vms = append(vms, page.Value...)
+		}
+	case filter.Region != "":
+		pager := p.client.NewListByLocationPager(strings.ToLower(filter.Region), nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			vms = append(vms, page.Value...)
+		}
+	default:
+		return p.ListVMs(ctx)
+	}
+
+	var powerStates map[string]string
+	var err error
+	if len(vms) > azureInstanceViewLimit {
+		powerStates, err = p.allPowerStates(ctx)
+	} else {
+		powerStates, err = p.powerStates(ctx, vms)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.toModels(ctx, vms, powerStates), nil
+}
+
+// azureInstanceViewLimit is the number of VMs up to which a filtered list
+// reads the power state of each VM instead of those of the whole subscription.
+const azureInstanceViewLimit = 20
+
+// allPowerStates returns the power states of every VM of the subscription by
+// lowercase VM ID. The VM model doesn't carry the power state; statusOnly=true
+// returns the instance view of every VM in one paged call instead of one call
+// per VM.
+func (p *AzureProvider) allPowerStates(ctx context.Context) (map[string]string, error) {
+	powerStates := make(map[string]string)
+	pager := p.client.NewListAllPager(&armcompute.VirtualMachinesClientListAllOptions{StatusOnly: to.StringPtr("true")})
 	for pager.More() {
 		page, err := pager.NextPage(ctx)
 		if err != nil {
 			return nil, err
 		}
 		for _, vm := range page.Value {
-			vms = append(vms, models.VM{
-				ID:       *vm.ID,
-				Name:     *vm.Name,
-				Provider: "azure",
-				Region:   *vm.Location,
-				Status:   "running", // Simplified; real status requires additional API call
-			})
+			if vm.ID != nil && vm.Properties != nil && vm.Properties.InstanceView != nil {
+				powerStates[strings.ToLower(*vm.ID)] = azurePowerState(vm.Properties.InstanceView.Statuses)
+			}
+		}
+	}
+	return powerStates, nil
+}
+
+// powerStates reads the instance views of vms concurrently and returns their
+// power states by lowercase VM ID.
+func (p *AzureProvider) powerStates(ctx context.Context, vms []*armcompute.VirtualMachine) (map[string]string, error) {
+	var mu sync.Mutex
+	var firstErr error
+	powerStates := make(map[string]string, len(vms))
+	var wg sync.WaitGroup
+	for _, vm := range vms {
+		id := to.String(vm.ID)
+		rg, name, err := parseAzureVMID(id)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := p.client.InstanceView(ctx, rg, name, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			powerStates[strings.ToLower(id)] = azurePowerState(resp.Statuses)
+		}()
+	}
+	wg.Wait()
+	return powerStates, firstErr
+}
+
+// toModels converts listed VMs to the unified model with their power states.
+func (p *AzureProvider) toModels(ctx context.Context, vms []*armcompute.VirtualMachine, powerStates map[string]string) []models.VM {
+	list := make([]models.VM, 0, len(vms))
+	for _, vm := range vms {
+		m := p.toModel(ctx, vm)
+		m.RawStatus = powerStates[strings.ToLower(m.ID)]
+		m.Status = azureStatus(m.RawStatus)
+		list = append(list, m)
+	}
+	return list
+}
+
+// CreateVM creates a VM from an image name or a "Publisher:Offer:SKU:Version"
+// reference, by default "ubuntu24", and attaches the NIC given as spec.Network.
+func (p *AzureProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	if spec.Network == "" {
+		return nil, fmt.Errorf("Azure VM creation requires a NIC ID in network")
+	}
+
+	// Map custom VM size and image, defaulting to "small".
+	actualSize := spec.Size
+	if actualSize == "" {
+		actualSize = "small"
+	}
+	if mapped, ok := p.mapping.CustomVMSizes[strings.ToLower(actualSize)]; ok {
+		actualSize = mapped
+	}
+	// Azure resolves the "latest" version itself.
+	actualImage := spec.Image
+	if actualImage == "" {
+		actualImage = "ubuntu24"
+	}
+	imageRef, err := parseAzureImageReference(imageAlias(p.images, actualImage))
+	if err != nil {
+		return nil, err
+	}
+
+	// Use defaults if resource group or location are not provided.
+	resourceGroup := spec.ResourceGroup
+	if resourceGroup == "" {
+		resourceGroup = p.mapping.DefaultResourceGroup
+	}
+	location := spec.Region
+	if location == "" {
+		location = p.mapping.DefaultLocation
+	}
+	diskSizeGB := int32(30)
+	if spec.DiskSizeGB > 0 {
+		diskSizeGB = int32(spec.DiskSizeGB)
+	}
+
+	vmSize := armcompute.VirtualMachineSizeTypes(actualSize)
+	createOption := armcompute.DiskCreateOptionTypesFromImage
+	parameters := armcompute.VirtualMachine{
+		Location: &location,
+		Tags:     azureTags(spec.Tags),
+		Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile: &armcompute.HardwareProfile{
+				VMSize: &vmSize,
+			},
+			StorageProfile: &armcompute.StorageProfile{
+				ImageReference: imageRef,
+				OSDisk: &armcompute.OSDisk{
+					CreateOption: &createOption,
+					DiskSizeGB:   &diskSizeGB,
+				},
+			},
+			OSProfile: &armcompute.OSProfile{
+				ComputerName:  &spec.Name,
+				AdminUsername: &spec.AdminUsername,
+				AdminPassword: &spec.AdminPassword,
+			},
+			NetworkProfile: &armcompute.NetworkProfile{
+				NetworkInterfaces: []*armcompute.NetworkInterfaceReference{
+					{
+						ID: &spec.Network,
+						Properties: &armcompute.NetworkInterfaceReferenceProperties{
+							Primary: to.BoolPtr(true),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	poller, err := p.client.BeginCreateOrUpdate(ctx, resourceGroup, spec.Name, parameters, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start VM creation: %w", err)
+	}
+
+	// The deadline comes from ctx (see AZURE_OPERATION_TIMEOUT).
+	resp, err := pollAzure(ctx, poller, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM: %w", err)
+	}
+	vm := p.toModel(ctx, &resp.VirtualMachine)
+	return &vm, nil
+}
+
+// UpdateTags merges the changes into the VM's tags. Azure replaces all tags
+// of a resource at once, so the current ones are read first.
+func (p *AzureProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	rg, name, err := parseAzureVMID(id)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Get(ctx, rg, name, nil)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]string, len(resp.Tags))
+	for k, v := range resp.Tags {
+		current[k] = to.String(v)
+	}
+	tags := mergeTags(current, set, remove)
+	if err := ValidateTags("azure", tags); err != nil {
+		return err
+	}
+	poller, err := p.client.BeginUpdate(ctx, rg, name, armcompute.VirtualMachineUpdate{Tags: azureTags(tags)}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update tags: %w", err)
+	}
+	_, err = pollAzure(ctx, poller, nil)
+	return err
+}
+
+// azureTags converts tags to the ARM representation. An empty map is kept
+// empty rather than nil so that updating with it removes all tags.
+func azureTags(tags map[string]string) map[string]*string {
+	out := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		out[k] = to.StringPtr(v)
+	}
+	return out
+}
+
+// parseAzureImageReference parses an image reference in the format
+// "Publisher:Offer:SKU:Version".
+func parseAzureImageReference(ref string) (*armcompute.ImageReference, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("Azure image %q is neither an image name nor Publisher:Offer:SKU:Version", ref)
+	}
+	return &armcompute.ImageReference{
+		Publisher: to.StringPtr(parts[0]),
+		Offer:     to.StringPtr(parts[1]),
+		SKU:       to.StringPtr(parts[2]),
+		Version:   to.StringPtr(parts[3]),
+	}, nil
+}
+
+// Images returns the image names and their marketplace image references.
+func (p *AzureProvider) Images() map[string]string {
+	return p.images
+}
+
+// GET https://management.azure.com/subscriptions/<sub>/providers/Microsoft.Compute/locations/<location>/publishers/<publisher>/artifacttypes/vmimage/offers/<offer>/skus/<sku>/versions
+//
+// ResolveImage returns the marketplace image reference of an image name or
+// reference, with a "latest" version replaced by the newest version offered
+// in the default location.
+func (p *AzureProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	ref := imageAlias(p.images, image)
+	imageRef, err := parseAzureImageReference(ref)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(*imageRef.Version, "latest") {
+		return ref, nil
+	}
+	resp, err := p.versions.List(ctx, p.mapping.DefaultLocation, *imageRef.Publisher, *imageRef.Offer, *imageRef.SKU, nil)
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, v := range resp.VirtualMachineImageResourceArray {
+		if v.Name != nil && compareVersions(*v.Name, latest) > 0 {
+			latest = *v.Name
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no versions of %s in %s", ref, p.mapping.DefaultLocation)
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", *imageRef.Publisher, *imageRef.Offer, *imageRef.SKU, latest), nil
+}
+
+// parseAzureVMID extracts the resource group and VM name from an ID such as
+// "/subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachines/<name>".
+// The short form "<rg>/<name>" is accepted as well.
+func parseAzureVMID(id string) (resourceGroup, vmName string, err error) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) == 2 {
+		return parts[0], parts[1], nil
+	}
+	return parseAzureResourceID(id)
+}
+
+// parseAzureResourceID returns the resource group and name of any ARM resource ID.
+func parseAzureResourceID(id string) (resourceGroup, name string, err error) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			resourceGroup = parts[i+1]
 		}
 	}
-	return vms, nil
+	if resourceGroup == "" || len(parts) < 4 {
+		return "", "", fmt.Errorf("invalid Azure resource ID %q", id)
+	}
+	return resourceGroup, parts[len(parts)-1], nil
 }
 
-// CreateVM creates a new virtual machine in the specified resource group.
-// The caller must supply the VM parameters (of type armcompute.VirtualMachine).
-func (p *AzureProvider) CreateVM(ctx context.Context, resourceGroupName, vmName string, parameters armcompute.VirtualMachine) error {
-	poller, err := p.client.BeginCreateOrUpdate(ctx, resourceGroupName, vmName, parameters, nil)
+func (p *AzureProvider) StartVM(ctx context.Context, id string) error {
+	rg, name, err := parseAzureVMID(id)
 	if err != nil {
-		return fmt.Errorf("failed to start VM creation: %w", err)
+		return err
 	}
+	poller, err := p.client.BeginStart(ctx, rg, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start VM: %w", err)
+	}
+	_, err = pollAzure(ctx, poller, nil)
+	return err
+}
 
-	// FixMe: We might need to use bigger value in here?
-	// https://pkg.go.dev/github.com/Azure/azure-sdk-for-go/sdk/azcore@v1.17.0/runtime#PollUntilDoneOptions
-	_, err = poller.PollUntilDone(ctx, nil)
+func (p *AzureProvider) StopVM(ctx context.Context, id string, force bool) error {
+	rg, name, err := parseAzureVMID(id)
 	if err != nil {
-		return fmt.Errorf("failed to create VM: %w", err)
+		return err
+	}
+	poller, err := p.client.BeginPowerOff(ctx, rg, name, &armcompute.VirtualMachinesClientBeginPowerOffOptions{
+		SkipShutdown: to.BoolPtr(force),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stop VM: %w", err)
+	}
+	_, err = pollAzure(ctx, poller, nil)
+	return err
+}
+
+// CheckPower refuses hard restarts, which Azure doesn't have.
+func (p *AzureProvider) CheckPower(action string, force bool) error {
+	if action == "restart" && force {
+		return notSupported("azure", "hard restart")
 	}
 	return nil
 }
+
+func (p *AzureProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	if err := p.CheckPower("restart", force); err != nil {
+		return err
+	}
+	rg, name, err := parseAzureVMID(id)
+	if err != nil {
+		return err
+	}
+	poller, err := p.client.BeginRestart(ctx, rg, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to restart VM: %w", err)
+	}
+	_, err = pollAzure(ctx, poller, nil)
+	return err
+}
+
+// SuspendVM hibernates the VM. Hibernation must be enabled on the VM.
+func (p *AzureProvider) SuspendVM(ctx context.Context, id string) error {
+	rg, name, err := parseAzureVMID(id)
+	if err != nil {
+		return err
+	}
+	poller, err := p.client.BeginDeallocate(ctx, rg, name, &armcompute.VirtualMachinesClientBeginDeallocateOptions{
+		Hibernate: to.BoolPtr(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hibernate VM: %w", err)
+	}
+	_, err = pollAzure(ctx, poller, nil)
+	return err
+}
+
+// DeleteVM deletes the VM and, with cascade, its managed disks, NICs and the
+// public IPs attached to those NICs.
+func (p *AzureProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	rg, name, err := parseAzureVMID(id)
+	if err != nil {
+		return nil, err
+	}
+	vm, err := p.client.Get(ctx, rg, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM: %w", err)
+	}
+
+	// Collect the attached resources before the VM is gone.
+	var diskIDs, nicIDs []string
+	if props := vm.Properties; props != nil {
+		if sp := props.StorageProfile; sp != nil {
+			if sp.OSDisk != nil && sp.OSDisk.ManagedDisk != nil && sp.OSDisk.ManagedDisk.ID != nil {
+				diskIDs = append(diskIDs, *sp.OSDisk.ManagedDisk.ID)
+			}
+			for _, d := range sp.DataDisks {
+				if d.ManagedDisk != nil && d.ManagedDisk.ID != nil {
+					diskIDs = append(diskIDs, *d.ManagedDisk.ID)
+				}
+			}
+		}
+		if np := props.NetworkProfile; np != nil {
+			for _, nic := range np.NetworkInterfaces {
+				if nic.ID != nil {
+					nicIDs = append(nicIDs, *nic.ID)
+				}
+			}
+		}
+	}
+
+	poller, err := p.client.BeginDelete(ctx, rg, name, nil)
+	if _, err = pollAzure(ctx, poller, err); err != nil {
+		return []models.DeletedResource{deletedResource("vm", *vm.ID, err)}, fmt.Errorf("failed to delete VM: %w", err)
+	}
+	results := []models.DeletedResource{deletedResource("vm", *vm.ID, nil)}
+	if !cascade {
+		return results, nil
+	}
+
+	// NICs must go before the public IPs they reference.
+	var publicIPIDs []string
+	for _, nicID := range nicIDs {
+		nicRG, nicName, err := parseAzureResourceID(nicID)
+		if err == nil {
+			var nic armnetwork.InterfacesClientGetResponse
+			nic, err = p.nics.Get(ctx, nicRG, nicName, nil)
+			if err == nil && nic.Properties != nil {
+				for _, ipc := range nic.Properties.IPConfigurations {
+					if ipc.Properties != nil && ipc.Properties.PublicIPAddress != nil && ipc.Properties.PublicIPAddress.ID != nil {
+						publicIPIDs = append(publicIPIDs, *ipc.Properties.PublicIPAddress.ID)
+					}
+				}
+			}
+			if err == nil {
+				poller, perr := p.nics.BeginDelete(ctx, nicRG, nicName, nil)
+				_, err = pollAzure(ctx, poller, perr)
+			}
+		}
+		results = append(results, deletedResource("nic", nicID, err))
+	}
+	for _, ipID := range publicIPIDs {
+		ipRG, ipName, err := parseAzureResourceID(ipID)
+		if err == nil {
+			poller, perr := p.publicIPs.BeginDelete(ctx, ipRG, ipName, nil)
+			_, err = pollAzure(ctx, poller, perr)
+		}
+		results = append(results, deletedResource("publicIp", ipID, err))
+	}
+	for _, diskID := range diskIDs {
+		diskRG, diskName, err := parseAzureResourceID(diskID)
+		if err == nil {
+			poller, perr := p.disks.BeginDelete(ctx, diskRG, diskName, nil)
+			_, err = pollAzure(ctx, poller, perr)
+		}
+		results = append(results, deletedResource("disk", diskID, err))
+	}
+	return results, nil
+}
+
+// pollAzure waits for a long-running ARM operation started by a Begin* call,
+// reporting each poll to the operation running with ctx.
+func pollAzure[T any](ctx context.Context, poller *runtime.Poller[T], err error) (T, error) {
+	var zero T
+	if err != nil {
+		return zero, err
+	}
+	for polls := 1; !poller.Done(); polls++ {
+		operations.Progress(ctx, 0, "waiting for Azure operation (poll %d)", polls)
+		if _, err := poller.Poll(ctx); err != nil {
+			return zero, err
+		}
+		if poller.Done() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-time.After(azurePollInterval):
+		}
+	}
+	return poller.Result(ctx)
+}
+
+// azurePollInterval is the delay between polls of a long-running operation.
+const azurePollInterval = 5 * time.Second
+
+// GetVM returns the VM with its instance view status and IP addresses.
+func (p *AzureProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	rg, name, err := parseAzureVMID(id)
+	if err != nil {
+		return nil, err
+	}
+	expand := armcompute.InstanceViewTypesInstanceView
+	resp, err := p.client.Get(ctx, rg, name, &armcompute.VirtualMachinesClientGetOptions{Expand: &expand})
+	if err != nil {
+		return nil, err
+	}
+	vm := p.toModel(ctx, &resp.VirtualMachine)
+
+	if props := resp.Properties; props != nil {
+		if iv := props.InstanceView; iv != nil {
+			vm.RawStatus = azurePowerState(iv.Statuses)
+		}
+		vm.Status = azureStatus(vm.RawStatus)
+		if np := props.NetworkProfile; np != nil {
+			for _, ref := range np.NetworkInterfaces {
+				if ref.ID != nil {
+					p.addNICAddresses(ctx, *ref.ID, &vm)
+				}
+			}
+		}
+	}
+	return &vm, nil
+}
+
+// addNICAddresses adds the private and public IPs of a NIC to the VM.
+func (p *AzureProvider) addNICAddresses(ctx context.Context, nicID string, vm *models.VM) {
+	rg, name, err := parseAzureResourceID(nicID)
+	if err != nil {
+		return
+	}
+	nic, err := p.nics.Get(ctx, rg, name, nil)
+	if err != nil || nic.Properties == nil {
+		return
+	}
+	for _, ipc := range nic.Properties.IPConfigurations {
+		if ipc.Properties == nil {
+			continue
+		}
+		if ipc.Properties.PrivateIPAddress != nil {
+			vm.PrivateIPs = append(vm.PrivateIPs, *ipc.Properties.PrivateIPAddress)
+		}
+		if pip := ipc.Properties.PublicIPAddress; pip != nil && pip.ID != nil {
+			pipRG, pipName, err := parseAzureResourceID(*pip.ID)
+			if err != nil {
+				continue
+			}
+			ip, err := p.publicIPs.Get(ctx, pipRG, pipName, nil)
+			if err == nil && ip.Properties != nil && ip.Properties.IPAddress != nil {
+				vm.PublicIPs = append(vm.PublicIPs, *ip.Properties.IPAddress)
+			}
+		}
+	}
+}
+
+// toModel converts an ARM VM resource to the unified model. Status is left empty.
+func (p *AzureProvider) toModel(ctx context.Context, vm *armcompute.VirtualMachine) models.VM {
+	m := models.VM{
+		ID:       to.String(vm.ID),
+		Name:     to.String(vm.Name),
+		Provider: "azure",
+		Region:   to.String(vm.Location),
+	}
+	if len(vm.Tags) > 0 {
+		m.Tags = make(map[string]string, len(vm.Tags))
+		for k, v := range vm.Tags {
+			m.Tags[k] = to.String(v)
+		}
+	}
+	props := vm.Properties
+	if props == nil {
+		return m
+	}
+	m.CreatedAt = props.TimeCreated
+	if hp := props.HardwareProfile; hp != nil && hp.VMSize != nil {
+		m.Size = string(*hp.VMSize)
+		if size := p.lookupSize(ctx, m.Region, m.Size); size != nil {
+			m.CPUs = int(to.Int32(size.NumberOfCores))
+			m.MemoryMB = int64(to.Int32(size.MemoryInMB))
+		}
+	}
+	if sp := props.StorageProfile; sp != nil {
+		if img := sp.ImageReference; img != nil {
+			if img.Publisher != nil {
+				m.Image = strings.Join([]string{to.String(img.Publisher), to.String(img.Offer), to.String(img.SKU), to.String(img.Version)}, ":")
+			} else {
+				m.Image = to.String(img.ID)
+			}
+		}
+		if osd := sp.OSDisk; osd != nil {
+			if osd.OSType != nil {
+				m.OSType = strings.ToLower(string(*osd.OSType))
+			}
+			disk := models.Disk{Name: to.String(osd.Name), SizeGB: int64(to.Int32(osd.DiskSizeGB)), Boot: true}
+			if osd.ManagedDisk != nil {
+				disk.ID = to.String(osd.ManagedDisk.ID)
+			}
+			m.Disks = append(m.Disks, disk)
+		}
+		for _, dd := range sp.DataDisks {
+			disk := models.Disk{Name: to.String(dd.Name), SizeGB: int64(to.Int32(dd.DiskSizeGB))}
+			if dd.ManagedDisk != nil {
+				disk.ID = to.String(dd.ManagedDisk.ID)
+			}
+			m.Disks = append(m.Disks, disk)
+		}
+	}
+	return m
+}
+
+// lookupSize returns the hardware details of a VM size in a location.
+func (p *AzureProvider) lookupSize(ctx context.Context, location, name string) *armcompute.VirtualMachineSize {
+	sizes, err := p.locationSizes(ctx, location)
+	if err != nil {
+		return nil
+	}
+	return sizes[strings.ToLower(name)]
+}
+
+// locationSizes returns the VM sizes of a location by lowercase name.
+func (p *AzureProvider) locationSizes(ctx context.Context, location string) (map[string]*armcompute.VirtualMachineSize, error) {
+	return p.sizeDetails.get(ctx, location, func() (map[string]*armcompute.VirtualMachineSize, error) {
+		sizes := make(map[string]*armcompute.VirtualMachineSize)
+		pager := p.sizes.NewListPager(location, nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, size := range page.Value {
+				sizes[strings.ToLower(to.String(size.Name))] = size
+			}
+		}
+		return sizes, nil
+	})
+}
+
+// ListSizes returns the VM sizes of a location, by default the mapping's
+// default location, with their pay-as-you-go Linux prices from the Azure
+// Retail Prices API.
+func (p *AzureProvider) ListSizes(ctx context.Context, location string) ([]models.Size, error) {
+	if location == "" {
+		location = p.mapping.DefaultLocation
+	}
+	sizes, err := p.locationSizes(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	prices := p.prices(ctx, location)
+	aliases := sizeAliases(p.mapping.CustomVMSizes)
+	list := make([]models.Size, 0, len(sizes))
+	for key, size := range sizes {
+		name := to.String(size.Name)
+		list = append(list, models.Size{
+			Name:           name,
+			CPUs:           int(to.Int32(size.NumberOfCores)),
+			MemoryMB:       int64(to.Int32(size.MemoryInMB)),
+			Arch:           azureArch(name),
+			HourlyPriceUSD: prices[key],
+			Aliases:        aliases[key],
+		})
+	}
+	return list, nil
+}
+
+// azureArmSizePattern matches the names of Arm64 VM sizes, which carry a "p"
+// among the additive features following the vCPU count, e.g. Standard_D4ps_v5.
+var azureArmSizePattern = regexp.MustCompile(`^(?i:standard|basic)_[A-Z]+\d+[a-z]*p[a-z]*(_|$)`)
+
+// azureArch returns the architecture of a VM size by its name.
+func azureArch(name string) string {
+	if azureArmSizePattern.MatchString(name) {
+		return "arm64"
+	}
+	return "x86_64"
+}
+
+// azureRetailPricesURL is the Azure Retail Prices API, which needs no
+// authentication.
+var azureRetailPricesURL = "https://prices.azure.com/api/retail/prices"
+
+// prices returns the hourly pay-as-you-go price in USD of each VM size in a
+// location by lowercase name, leaving out Windows, spot and low priority
+// prices. Failures are logged and leave the prices unknown until the lookup
+// is retried.
+func (p *AzureProvider) prices(ctx context.Context, location string) map[string]float64 {
+	prices, _ := p.priceCache.get(ctx, location, func() (map[string]float64, error) {
+		return fetchAzurePrices(ctx, location)
+	})
+	return prices
+}
+
+// fetchAzurePrices reads the prices of a location from the Retail Prices API.
+func fetchAzurePrices(ctx context.Context, location string) (map[string]float64, error) {
+	filter := fmt.Sprintf("serviceName eq 'Virtual Machines' and armRegionName eq '%s' and priceType eq 'Consumption'", location)
+	next := azureRetailPricesURL + "?$filter=" + url.PathEscape(filter)
+	prices := make(map[string]float64)
+	for next != "" {
+		var page struct {
+			Items []struct {
+				ArmSkuName    string  `json:"armSkuName"`
+				RetailPrice   float64 `json:"retailPrice"`
+				UnitOfMeasure string  `json:"unitOfMeasure"`
+				ProductName   string  `json:"productName"`
+				SkuName       string  `json:"skuName"`
+			} `json:"Items"`
+			NextPageLink string `json:"NextPageLink"`
+		}
+		if err := getJSON(ctx, next, &page); err != nil {
+			fmt.Printf("Reading Azure prices of %s failed. Will continue without them. Error: %v\r\n", location, err)
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if item.UnitOfMeasure != "1 Hour" || strings.Contains(item.ProductName, "Windows") ||
+				strings.Contains(item.SkuName, "Spot") || strings.Contains(item.SkuName, "Low Priority") {
+				continue
+			}
+			prices[strings.ToLower(item.ArmSkuName)] = item.RetailPrice
+		}
+		next = page.NextPageLink
+	}
+	return prices, nil
+}
+
+// getJSON decodes the JSON response of a GET request to rawURL.
+func getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// azurePowerState returns the power state code (e.g. "running", "deallocated")
+// from instance view statuses.
+func azurePowerState(statuses []*armcompute.InstanceViewStatus) string {
+	for _, st := range statuses {
+		if st.Code != nil && strings.HasPrefix(*st.Code, "PowerState/") {
+			return strings.TrimPrefix(*st.Code, "PowerState/")
+		}
+	}
+	return ""
+}
+
+// azureStatus maps an Azure power state code to the normalized status.
+func azureStatus(state string) models.VMStatus {
+	switch state {
+	case "running":
+		return models.StatusRunning
+	case "starting":
+		return models.StatusStarting
+	case "stopping", "deallocating", "hibernating":
+		return models.StatusStopping
+	case "stopped":
+		return models.StatusStopped
+	case "deallocated":
+		return models.StatusDeallocated
+	case "hibernated":
+		return models.StatusSuspended
+	}
+	return models.StatusUnknown
+}
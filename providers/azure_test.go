@@ -0,0 +1,127 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+)
+
+type staticToken struct{}
+
+func (staticToken) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeARM serves the VM list and instance view calls of the compute API for
+// two VMs in westeurope (rg1 and rg2) and one in northeurope (rg1), and
+// records the paths requested.
+type fakeARM struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (f *fakeARM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.Path)
+	f.mu.Unlock()
+
+	vm := func(rg, name, location string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":       "/subscriptions/sub/resourceGroups/" + rg + "/providers/Microsoft.Compute/virtualMachines/" + name,
+			"name":     name,
+			"location": location,
+		}
+	}
+	vms := []map[string]interface{}{vm("rg1", "web1", "westeurope"), vm("rg2", "web2", "westeurope"), vm("rg1", "db1", "northeurope")}
+	var list []map[string]interface{}
+	path := strings.ToLower(r.URL.Path)
+	switch {
+	case strings.HasSuffix(path, "/instanceview"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"statuses": []interface{}{map[string]string{"code": "PowerState/running"}},
+		})
+		return
+	case strings.HasPrefix(path, "/subscriptions/sub/resourcegroups/rg1/"):
+		list = []map[string]interface{}{vms[0], vms[2]}
+	case strings.HasSuffix(path, "/locations/westeurope/virtualmachines"):
+		list = vms[:2]
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"value": list})
+}
+
+func TestAzureListVMsFiltered(t *testing.T) {
+	fake := &fakeARM{}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+	client, err := armcompute.NewVirtualMachinesClient("sub", staticToken{}, &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		Cloud: cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {Endpoint: server.URL, Audience: "https://management.core.windows.net/"},
+		}},
+		Transport: server.Client(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &AzureProvider{client: client}
+	// No sizes are known, so the sizes API isn't called.
+	p.sizeDetails.set("westeurope", nil)
+	p.sizeDetails.set("northeurope", nil)
+
+	tests := []struct {
+		filter models.VMFilter
+		want   string
+		path   string
+	}{
+		{models.VMFilter{ResourceGroup: "rg1"}, "db1,web1", "/subscriptions/sub/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines"},
+		{models.VMFilter{Region: "WestEurope"}, "web1,web2", "/subscriptions/sub/providers/Microsoft.Compute/locations/westeurope/virtualMachines"},
+	}
+	for _, tt := range tests {
+		fake.mu.Lock()
+		fake.paths = nil
+		fake.mu.Unlock()
+		vms, err := p.ListVMsFiltered(context.Background(), tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, vm := range vms {
+			names = append(names, vm.Name)
+			if vm.Status != models.StatusRunning {
+				t.Errorf("%s is %s, want running", vm.Name, vm.Status)
+			}
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("filter %+v listed %s, want %s", tt.filter, got, tt.want)
+		}
+		fake.mu.Lock()
+		paths := fake.paths
+		fake.mu.Unlock()
+		if len(paths) == 0 || paths[0] != tt.path {
+			t.Errorf("filter %+v requested %v, want %s first", tt.filter, paths, tt.path)
+			continue
+		}
+		for _, path := range paths[1:] {
+			if !strings.HasSuffix(path, "/instanceView") {
+				t.Errorf("filter %+v requested %s, want only instance views after the list", tt.filter, path)
+			}
+		}
+	}
+}
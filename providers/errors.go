@@ -0,0 +1,31 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// NotSupportedError is returned when a provider has no way to perform the
+// requested operation (e.g. a graceful restart on GCP).
+type NotSupportedError struct {
+	Provider  string
+	Operation string
+}
+
+func (e *NotSupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by the %s provider", e.Operation, e.Provider)
+}
+
+func notSupported(provider, operation string) error {
+	return &NotSupportedError{Provider: provider, Operation: operation}
+}
+
+// deletedResource builds a DeletedResource entry from the result of a delete call.
+func deletedResource(kind, id string, err error) models.DeletedResource {
+	r := models.DeletedResource{Type: kind, ID: id, Deleted: err == nil}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
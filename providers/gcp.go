@@ -3,9 +3,15 @@ package providers
 import (
 	"context"
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/option"
@@ -14,6 +20,12 @@ import (
 type GCPProvider struct {
 	Client    *compute.Service
 	projectID string
+	mapping   config.GCPMapping
+	images    map[string]string // image names -> image URL or family URL
+
+	// types holds the machine types by "<zone>/<machine type>", filled on
+	// first use.
+	types sizeCache[*compute.MachineType]
 }
 
 func NewGCPProvider(cfg *config.Config) (*GCPProvider, bool) {
@@ -23,25 +35,71 @@ func NewGCPProvider(cfg *config.Config) (*GCPProvider, bool) {
 		fmt.Printf("Failed to active GCP provider. Will continue without it. Error: %v\r\n", err)
 		return nil, false
 	}
-	return &GCPProvider{Client: client, projectID: cfg.GCPCreds.ProjectID}, true
+	return &GCPProvider{
+		Client:    client,
+		projectID: cfg.GCPCreds.ProjectID,
+		mapping:   cfg.Mappings.GCP,
+		images:    cfg.ImageAliases("gcp"),
+	}, true
 }
 
 // GET  https://compute.googleapis.com/compute/v1/projects/<project id>/aggregated/instances?alt=json&prettyPrint=false
-func (p *GCPProvider) ListVMs() ([]models.VM, error) {
-	ctx := context.Background()
+func (p *GCPProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	return p.listVMs(ctx, "")
+}
+
+// gcpStatuses are the statuses a Compute Engine instance can have.
+var gcpStatuses = []string{"PROVISIONING", "STAGING", "RUNNING", "STOPPING", "SUSPENDING", "SUSPENDED", "REPAIRING", "TERMINATED"}
+
+// ListVMsFiltered passes the filter's labels, and its status when it maps to a
+// single instance status, on as aggregatedList filter expression.
+func (p *GCPProvider) ListVMsFiltered(ctx context.Context, filter models.VMFilter) ([]models.VM, error) {
+	var terms []string
+	if len(filter.Statuses) > 0 {
+		var statuses []string
+		for _, status := range gcpStatuses {
+			if filter.HasStatus(gcpStatus(status)) {
+				statuses = append(statuses, status)
+			}
+		}
+		if len(statuses) == 0 {
+			return nil, nil
+		}
+		if len(statuses) == 1 {
+			terms = append(terms, fmt.Sprintf("(status = %s)", statuses[0]))
+		}
+	}
+	for key, value := range filter.Tags {
+		// Label keys are lowercase letters, digits, dashes and underscores; other
+		// keys can't be expressed and are only filtered by the CloudManager.
+		if !gcpLabelPattern.MatchString(key) {
+			continue
+		}
+		if value == "" {
+			terms = append(terms, fmt.Sprintf("(labels.%s:*)", key))
+		} else {
+			terms = append(terms, fmt.Sprintf("(labels.%s = %q)", key, value))
+		}
+	}
+	sort.Strings(terms)
+	return p.listVMs(ctx, strings.Join(terms, " "))
+}
+
+// gcpLabelPattern matches a valid label key.
+var gcpLabelPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+
+// listVMs lists the instances of all zones matching the filter expression.
+func (p *GCPProvider) listVMs(ctx context.Context, filter string) ([]models.VM, error) {
 	var vms []models.VM
 
 	req := p.Client.Instances.AggregatedList(p.projectID)
+	if filter != "" {
+		req = req.Filter(filter)
+	}
 	if err := req.Pages(ctx, func(page *compute.InstanceAggregatedList) error {
 		for _, instances := range page.Items {
 			for _, inst := range instances.Instances {
-				vms = append(vms, models.VM{
-					ID:       inst.Name,
-					Name:     inst.Name,
-					Provider: "gcp",
-					Region:   inst.Zone,
-					Status:   inst.Status,
-				})
+				vms = append(vms, p.toModel(ctx, inst))
 			}
 		}
 		return nil
@@ -50,3 +108,483 @@ func (p *GCPProvider) ListVMs() ([]models.VM, error) {
 	}
 	return vms, nil
 }
+
+// locateInstance resolves a VM ID to its project, zone and instance name.
+// IDs are "<project>/<zone>/<name>", "<zone>/<name>" or a bare instance name,
+// in which case the zone is looked up and has to be unique; without project the provider's project
+// is meant.
+func (p *GCPProvider) locateInstance(ctx context.Context, id string) (project, zone, name string, err error) {
+	parts := strings.Split(id, "/")
+	switch len(parts) {
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	case 2:
+		return p.projectID, parts[0], parts[1], nil
+	case 1:
+	default:
+		return "", "", "", fmt.Errorf("invalid GCP VM ID %q", id)
+	}
+	var zones []string
+	req := p.Client.Instances.AggregatedList(p.projectID).Filter(fmt.Sprintf("name = %q", id))
+	err = req.Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+		for _, instances := range page.Items {
+			for _, inst := range instances.Instances {
+				zones = append(zones, path.Base(inst.Zone))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", "", err
+	}
+	switch len(zones) {
+	case 0:
+		return "", "", "", fmt.Errorf("GCP instance %q not found", id)
+	case 1:
+		return p.projectID, zones[0], id, nil
+	}
+	sort.Strings(zones)
+	return "", "", "", fmt.Errorf("GCP instance name %q is ambiguous, it exists in zones %s; use <zone>/<name>", id, strings.Join(zones, ", "))
+}
+
+func (p *GCPProvider) StartVM(ctx context.Context, id string) error {
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	op, err := p.Client.Instances.Start(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return p.waitZoneOperation(ctx, project, zone, op)
+}
+
+// StopVM stops the instance. GCP always signals the guest first and powers it
+// off once the shutdown period expires, so force makes no difference here.
+func (p *GCPProvider) StopVM(ctx context.Context, id string, force bool) error {
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	op, err := p.Client.Instances.Stop(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return p.waitZoneOperation(ctx, project, zone, op)
+}
+
+// CheckPower refuses graceful restarts, which GCP has no API for.
+func (p *GCPProvider) CheckPower(action string, force bool) error {
+	if action == "restart" && !force {
+		return notSupported("gcp", "graceful restart")
+	}
+	return nil
+}
+
+// RestartVM resets the instance. GCP has no graceful reboot API.
+func (p *GCPProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	if err := p.CheckPower("restart", force); err != nil {
+		return err
+	}
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	op, err := p.Client.Instances.Reset(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return p.waitZoneOperation(ctx, project, zone, op)
+}
+
+func (p *GCPProvider) SuspendVM(ctx context.Context, id string) error {
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	op, err := p.Client.Instances.Suspend(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return p.waitZoneOperation(ctx, project, zone, op)
+}
+
+// UpdateTags merges the changes into the instance's labels. The label
+// fingerprint makes the update fail instead of overwriting concurrent changes.
+func (p *GCPProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	inst, err := p.Client.Instances.Get(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	labels := mergeTags(inst.Labels, set, remove)
+	if err := ValidateTags("gcp", labels); err != nil {
+		return err
+	}
+	op, err := p.Client.Instances.SetLabels(project, zone, name, &compute.InstancesSetLabelsRequest{
+		Labels:           labels,
+		LabelFingerprint: inst.LabelFingerprint,
+		ForceSendFields:  []string{"Labels"}, // removing the last label sends {}
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return p.waitZoneOperation(ctx, project, zone, op)
+}
+
+// waitZoneOperation blocks until a zonal operation has completed, reporting
+// its progress to the operation running with ctx.
+func (p *GCPProvider) waitZoneOperation(ctx context.Context, project, zone string, op *compute.Operation) error {
+	for op.Status != "DONE" {
+		operations.Progress(ctx, int(op.Progress), "GCP operation %s is %s", op.Name, strings.ToLower(op.Status))
+		var err error
+		op, err = p.Client.ZoneOperations.Wait(project, zone, op.Name).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+// Images returns the image names and their image or image family URLs.
+func (p *GCPProvider) Images() map[string]string {
+	return p.images
+}
+
+// GET  https://compute.googleapis.com/compute/v1/projects/<project>/global/images/family/<family>
+//
+// ResolveImage returns the image URL of an image name or URL; image family
+// URLs resolve to the newest image of the family that isn't deprecated.
+func (p *GCPProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	ref := imageAlias(p.images, image)
+	project, family, ok := gcpImageFamily(ref)
+	if !ok {
+		return ref, nil
+	}
+	img, err := p.Client.Images.GetFromFamily(project, family).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("image family %s/%s: %w", project, family, err)
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", project, img.Name), nil
+}
+
+// gcpImageFamily returns the project and family of an image family URL such
+// as "projects/ubuntu-os-cloud/global/images/family/ubuntu-2404-lts-amd64".
+func gcpImageFamily(ref string) (project, family string, ok bool) {
+	parts := strings.Split(ref, "/")
+	for i := 0; i+5 < len(parts); i++ {
+		if parts[i] == "projects" && parts[i+2] == "global" && parts[i+3] == "images" && parts[i+4] == "family" {
+			return parts[i+1], parts[i+5], i+6 == len(parts)
+		}
+	}
+	return "", "", false
+}
+
+// CreateVM creates an instance with a boot disk from an image name, image
+// URL or image family URL and waits for the insert operation to finish.
+func (p *GCPProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	// Map custom machine type, defaulting to "small".
+	actualMachineType := spec.Size
+	if actualMachineType == "" {
+		actualMachineType = "small"
+	}
+	if mapped, ok := p.mapping.CustomVMSizes[strings.ToLower(actualMachineType)]; ok {
+		actualMachineType = mapped
+	}
+
+	// Map the image name, defaulting to "ubuntu24". Compute Engine picks
+	// the newest image of an image family itself.
+	actualSourceImage := spec.Image
+	if actualSourceImage == "" {
+		actualSourceImage = "ubuntu24"
+	}
+	actualSourceImage = imageAlias(p.images, actualSourceImage)
+
+	zone := spec.Region
+	if zone == "" {
+		zone = p.mapping.DefaultZone
+	}
+	projectID := spec.ProjectID
+	if projectID == "" {
+		projectID = p.mapping.DefaultProject
+	}
+	if projectID == "" {
+		projectID = p.projectID
+	}
+	network := spec.Network
+	if network == "" {
+		network = "global/networks/default"
+	}
+	diskSizeGB := spec.DiskSizeGB
+	if diskSizeGB == 0 {
+		diskSizeGB = 10
+	}
+
+	instance := &compute.Instance{
+		Name:   spec.Name,
+		Labels: spec.Tags,
+		// MachineType must be in the full URL format.
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zone, actualMachineType),
+		Disks: []*compute.AttachedDisk{
+			{
+				Boot:       true,
+				AutoDelete: true,
+				InitializeParams: &compute.AttachedDiskInitializeParams{
+					SourceImage: actualSourceImage,
+					DiskSizeGb:  diskSizeGB,
+				},
+			},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network: network,
+			},
+		},
+	}
+	// Instances get an external IP only through an access config.
+	if spec.PublicIP != nil && *spec.PublicIP {
+		instance.NetworkInterfaces[0].AccessConfigs = []*compute.AccessConfig{{Name: "External NAT", Type: "ONE_TO_ONE_NAT"}}
+	}
+	op, err := p.Client.Instances.Insert(projectID, zone, instance).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCP instance: %w", err)
+	}
+	if err := p.waitZoneOperation(ctx, projectID, zone, op); err != nil {
+		return nil, fmt.Errorf("failed to create GCP instance: %w", err)
+	}
+	inst, err := p.Client.Instances.Get(projectID, zone, spec.Name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	vm := p.toModel(ctx, inst)
+	return &vm, nil
+}
+
+// DeleteVM deletes the instance. With cascade every attached disk is switched
+// to autoDelete first and static external IPs used by the instance are released.
+func (p *GCPProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	inst, err := p.Client.Instances.Get(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []models.DeletedResource
+	var natIPs []string
+	if cascade {
+		for _, disk := range inst.Disks {
+			if disk.AutoDelete {
+				continue
+			}
+			op, err := p.Client.Instances.SetDiskAutoDelete(project, zone, name, true, disk.DeviceName).Context(ctx).Do()
+			if err == nil {
+				err = p.waitZoneOperation(ctx, project, zone, op)
+			}
+			if err != nil {
+				results = append(results, deletedResource("disk", disk.Source, err))
+				continue
+			}
+			disk.AutoDelete = true
+		}
+		for _, nic := range inst.NetworkInterfaces {
+			for _, ac := range nic.AccessConfigs {
+				if ac.NatIP != "" {
+					natIPs = append(natIPs, ac.NatIP)
+				}
+			}
+		}
+	}
+
+	op, err := p.Client.Instances.Delete(project, zone, name).Context(ctx).Do()
+	if err == nil {
+		err = p.waitZoneOperation(ctx, project, zone, op)
+	}
+	if err != nil {
+		return []models.DeletedResource{deletedResource("vm", name, err)}, err
+	}
+	results = append([]models.DeletedResource{deletedResource("vm", name, nil)}, results...)
+	for _, disk := range inst.Disks {
+		if disk.AutoDelete {
+			results = append(results, deletedResource("disk", disk.Source, nil))
+		}
+	}
+
+	// Ephemeral IPs disappear with the instance; reserved ones have to be released.
+	region := zone[:strings.LastIndex(zone, "-")]
+	for _, ip := range natIPs {
+		var addrs []*compute.Address
+		err := p.Client.Addresses.List(project, region).Filter(fmt.Sprintf("address = %q", ip)).Pages(ctx, func(page *compute.AddressList) error {
+			addrs = append(addrs, page.Items...)
+			return nil
+		})
+		if err != nil {
+			results = append(results, deletedResource("publicIp", ip, err))
+			continue
+		}
+		for _, addr := range addrs {
+			_, err := p.Client.Addresses.Delete(project, region, addr.Name).Context(ctx).Do()
+			results = append(results, deletedResource("publicIp", addr.SelfLink, err))
+		}
+	}
+	return results, nil
+}
+
+// GetVM returns the instance with the image its boot disk was created from.
+func (p *GCPProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	project, zone, name, err := p.locateInstance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	inst, err := p.Client.Instances.Get(project, zone, name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	vm := p.toModel(ctx, inst)
+	for _, d := range inst.Disks {
+		if !d.Boot || d.Source == "" {
+			continue
+		}
+		disk, err := p.Client.Disks.Get(project, zone, path.Base(d.Source)).Context(ctx).Do()
+		if err == nil {
+			vm.Image = disk.SourceImage
+		}
+	}
+	return &vm, nil
+}
+
+// toModel converts a Compute Engine instance to the unified model.
+func (p *GCPProvider) toModel(ctx context.Context, inst *compute.Instance) models.VM {
+	vm := models.VM{
+		ID:        p.instanceID(inst),
+		Name:      inst.Name,
+		Provider:  "gcp",
+		Region:    path.Base(inst.Zone),
+		Status:    gcpStatus(inst.Status),
+		RawStatus: inst.Status,
+		Size:      path.Base(inst.MachineType),
+		Tags:      inst.Labels,
+	}
+	if t, err := time.Parse(time.RFC3339, inst.CreationTimestamp); err == nil {
+		vm.CreatedAt = &t
+	}
+	if mt := p.lookupMachineType(ctx, path.Base(inst.Zone), vm.Size); mt != nil {
+		vm.CPUs = int(mt.GuestCpus)
+		vm.MemoryMB = mt.MemoryMb
+	}
+	for _, d := range inst.Disks {
+		vm.Disks = append(vm.Disks, models.Disk{
+			ID:     d.Source,
+			Name:   d.DeviceName,
+			SizeGB: d.DiskSizeGb,
+			Boot:   d.Boot,
+		})
+		if d.Boot {
+			for _, f := range d.GuestOsFeatures {
+				if f.Type == "WINDOWS" {
+					vm.OSType = "windows"
+				}
+			}
+		}
+	}
+	for _, nic := range inst.NetworkInterfaces {
+		if nic.NetworkIP != "" {
+			vm.PrivateIPs = append(vm.PrivateIPs, nic.NetworkIP)
+		}
+		for _, ac := range nic.AccessConfigs {
+			if ac.NatIP != "" {
+				vm.PublicIPs = append(vm.PublicIPs, ac.NatIP)
+			}
+		}
+	}
+	return vm
+}
+
+// instanceID returns the VM ID of an instance: its name, or for instances of
+// other projects than the provider's "<project>/<zone>/<name>".
+func (p *GCPProvider) instanceID(inst *compute.Instance) string {
+	// Zone is the URL ".../projects/<project>/zones/<zone>".
+	project := path.Base(path.Dir(path.Dir(inst.Zone)))
+	if inst.Zone == "" || project == p.projectID {
+		return inst.Name
+	}
+	return project + "/" + path.Base(inst.Zone) + "/" + inst.Name
+}
+
+// GET  https://compute.googleapis.com/compute/v1/projects/<project id>/zones/<zone>/machineTypes
+//
+// ListSizes returns the machine types of a zone, by default the mapping's
+// default zone. Deprecated machine types are left out; prices are unknown.
+func (p *GCPProvider) ListSizes(ctx context.Context, zone string) ([]models.Size, error) {
+	if zone == "" {
+		zone = p.mapping.DefaultZone
+	}
+	aliases := sizeAliases(p.mapping.CustomVMSizes)
+	var sizes []models.Size
+	err := p.Client.MachineTypes.List(p.projectID, zone).Pages(ctx, func(page *compute.MachineTypeList) error {
+		for _, mt := range page.Items {
+			if mt.Deprecated != nil && mt.Deprecated.State != "" {
+				continue
+			}
+			p.types.set(zone+"/"+mt.Name, mt)
+			sizes = append(sizes, models.Size{
+				Name:     mt.Name,
+				CPUs:     int(mt.GuestCpus),
+				MemoryMB: mt.MemoryMb,
+				Arch:     gcpArch(mt.Architecture),
+				Aliases:  aliases[mt.Name],
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+// gcpArch maps the architecture of a machine type to "x86_64" or "arm64";
+// it is empty when unspecified.
+func gcpArch(arch string) string {
+	switch arch {
+	case "X86_64":
+		return "x86_64"
+	case "ARM64":
+		return "arm64"
+	}
+	return ""
+}
+
+// lookupMachineType returns the vCPU and memory details of a machine type.
+func (p *GCPProvider) lookupMachineType(ctx context.Context, zone, machineType string) *compute.MachineType {
+	mt, _ := p.types.get(ctx, zone+"/"+machineType, func() (*compute.MachineType, error) {
+		return p.Client.MachineTypes.Get(p.projectID, zone, machineType).Context(ctx).Do()
+	})
+	return mt
+}
+
+// gcpStatus maps a Compute Engine instance status to the normalized status.
+// GCP reports stopped instances as TERMINATED.
+func gcpStatus(status string) models.VMStatus {
+	switch status {
+	case "PROVISIONING", "STAGING":
+		return models.StatusStarting
+	case "RUNNING":
+		return models.StatusRunning
+	case "STOPPING", "SUSPENDING":
+		return models.StatusStopping
+	case "TERMINATED":
+		return models.StatusStopped
+	case "SUSPENDED":
+		return models.StatusSuspended
+	}
+	return models.StatusUnknown
+}
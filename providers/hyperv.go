@@ -4,11 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
 
 	"github.com/masterzen/winrm"
@@ -16,48 +17,47 @@ import (
 
 // HyperVProvider uses WinRM to remotely execute PowerShell on a Hyper‑V host.
 type HyperVProvider struct {
-	client *winrm.Client
+	client psRunner
 	host   string
+	images map[string]string // image names -> golden VHDX path
 }
 
-// NewHyperVProvider creates and configures a HyperVProvider using environment variables.
-func NewHyperVProvider(cfg interface{}) (*HyperVProvider, bool) {
-	host := os.Getenv("HYPERV_HOST")
-	portStr := os.Getenv("HYPERV_PORT")
-	username := os.Getenv("HYPERV_USERNAME")
-	password := os.Getenv("HYPERV_PASSWORD")
+// psRunner runs a PowerShell script and returns its stdout, stderr and exit
+// code. It is implemented by *winrm.Client.
+type psRunner interface {
+	RunPSWithContext(ctx context.Context, command string) (string, string, int, error)
+}
 
-	if host == "" || username == "" || password == "" {
+// NewHyperVProvider creates and configures a HyperVProvider from cfg.HyperVCreds.
+func NewHyperVProvider(cfg *config.Config) (*HyperVProvider, bool) {
+	creds := cfg.HyperVCreds
+	if creds.Host == "" || creds.Username == "" || creds.Password == "" {
 		fmt.Printf("Hyper-V credentials not configured. Will continue without it.\r\n")
 		return nil, false
 	}
 
-	port := 5985
-	if portStr != "" {
-		if p, err := strconv.Atoi(portStr); err == nil {
-			port = p
-		}
-	}
-
 	// Create the WinRM endpoint.
-	endpoint := winrm.NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	endpoint := winrm.NewEndpoint(creds.Host, creds.Port, false, false, nil, nil, nil, 0)
 
 	// Set up WinRM parameters with NTLM encryption.
 	params := winrm.DefaultParameters
 	enc, err := winrm.NewEncryption("ntlm")
 	if err != nil {
-		panic(fmt.Sprintf("failed to create encryption: %v", err))
+		fmt.Printf("Failed to active Hyper-V provider. Will continue without it. Error: %v\r\n", err)
+		return nil, false
 	}
 	params.TransportDecorator = func() winrm.Transporter { return enc }
 
-	client, err := winrm.NewClientWithParameters(endpoint, username, password, params)
+	client, err := winrm.NewClientWithParameters(endpoint, creds.Username, creds.Password, params)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create winrm client: %v", err))
+		fmt.Printf("Failed to active Hyper-V provider. Will continue without it. Error: %v\r\n", err)
+		return nil, false
 	}
 
 	return &HyperVProvider{
 		client: client,
-		host:   host,
+		host:   creds.Host,
+		images: cfg.ImageAliases("hyperv"),
 	}, true
 }
 
@@ -66,29 +66,21 @@ type hypervVM struct {
 	Id    interface{} `json:"Id"`
 	Name  string      `json:"Name"`
 	State string      `json:"State"`
+	Notes string      `json:"Notes"`
 }
 
 // ListVMs runs a PowerShell command via WinRM to retrieve Hyper‑V VMs and parses the output.
-func (p *HyperVProvider) ListVMs() ([]models.VM, error) {
-	// Create a context with a timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Run the PowerShell command to list VMs.
-	cmd := `Get-WmiObject -Namespace "root\virtualization\v2" -Class "Msvm_ComputerSystem" | Where-Object { $_.Caption -eq "Virtual Machine" } | Select-Object @{l="Id";e={$_.Name.ToLower()}},@{l="Name";e={$_.ElementName}},@{l="State";e={if ($_.ProcessID){"Running"} else {"Stopped"}}} | ConvertTo-Json -Compress`
-	stdOut, stdErr, exitCode, err := p.client.RunPSWithContext(ctx, cmd)
-	if err != nil || exitCode != 0 {
-		return nil, fmt.Errorf("failed to run command: %v, stderr: %s", err, stdErr)
+func (p *HyperVProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	// Run the PowerShell command to list VMs. EnabledState is translated to the
+	// state names Get-VM uses so both code paths report the same raw values.
+	// The notes, which carry the tags, come from the VMs' realized settings.
+	cmd := hypervStateTable + hypervNotesTable + `Get-WmiObject -Namespace "root\virtualization\v2" -Class "Msvm_ComputerSystem" | Where-Object { $_.Caption -eq "Virtual Machine" } | Select-Object @{l="Id";e={$_.Name.ToLower()}},@{l="Name";e={$_.ElementName}},@{l="State";e={$hypervStates[[int]$_.EnabledState]}},@{l="Notes";e={$hypervNotes[$_.Name.ToLower()]}} | ConvertTo-Json -Compress`
+	stdOut, err := p.runPS(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 
-	// Pre-process output: if the output starts with a quote, unquote it.
-	trimmed := strings.TrimSpace(stdOut)
-	if len(trimmed) > 0 && trimmed[0] == '"' {
-		unquoted, err := strconv.Unquote(trimmed)
-		if err == nil {
-			stdOut = unquoted
-		}
-	}
+	stdOut = unquotePSOutput(stdOut)
 
 	// Parse the JSON output. Handle both array and single object cases.
 	var vmsData []hypervVM
@@ -115,12 +107,399 @@ func (p *HyperVProvider) ListVMs() ([]models.VM, error) {
 			idStr = fmt.Sprintf("%v", v)
 		}
 		vms = append(vms, models.VM{
-			ID:       idStr,
-			Name:     hv.Name,
-			Provider: "hyperv",
-			Region:   p.host,
-			Status:   hv.State,
+			ID:        idStr,
+			Name:      hv.Name,
+			Provider:  "hyperv",
+			Region:    p.host,
+			Status:    hypervStatus(hv.State),
+			RawStatus: hv.State,
+			Tags:      hypervTags(hv.Notes),
 		})
 	}
 	return vms, nil
 }
+
+// unquotePSOutput pre-processes output: if the output starts with a quote, unquote it.
+func unquotePSOutput(stdOut string) string {
+	trimmed := strings.TrimSpace(stdOut)
+	if len(trimmed) > 0 && trimmed[0] == '"' {
+		unquoted, err := strconv.Unquote(trimmed)
+		if err == nil {
+			return unquoted
+		}
+	}
+	return stdOut
+}
+
+// runPS executes a PowerShell script on the Hyper-V host and returns its stdout.
+func (p *HyperVProvider) runPS(ctx context.Context, script string) (string, error) {
+	stdOut, stdErr, exitCode, err := p.client.RunPSWithContext(ctx, script)
+	if err != nil || exitCode != 0 {
+		return "", fmt.Errorf("failed to run command: %v, stderr: %s", err, stdErr)
+	}
+	return stdOut, nil
+}
+
+var hypervIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func validateHyperVID(id string) error {
+	if !hypervIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid Hyper-V VM ID %q", id)
+	}
+	return nil
+}
+
+// vmCommand runs a cmdlet against the VM with the given ID. The ID is validated
+// as a GUID before it is placed in the script.
+func (p *HyperVProvider) vmCommand(ctx context.Context, id, cmdlet string) error {
+	if err := validateHyperVID(id); err != nil {
+		return err
+	}
+	_, err := p.runPS(ctx, fmt.Sprintf("Get-VM -Id '%s' | %s", id, cmdlet))
+	return err
+}
+
+func (p *HyperVProvider) StartVM(ctx context.Context, id string) error {
+	return p.vmCommand(ctx, id, "Start-VM")
+}
+
+func (p *HyperVProvider) StopVM(ctx context.Context, id string, force bool) error {
+	if force {
+		return p.vmCommand(ctx, id, "Stop-VM -TurnOff -Force")
+	}
+	return p.vmCommand(ctx, id, "Stop-VM -Force")
+}
+
+func (p *HyperVProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	if force {
+		return p.vmCommand(ctx, id, "Restart-VM -Type Reset -Force")
+	}
+	return p.vmCommand(ctx, id, "Restart-VM -Type Reboot -Force")
+}
+
+func (p *HyperVProvider) SuspendVM(ctx context.Context, id string) error {
+	return p.vmCommand(ctx, id, "Suspend-VM")
+}
+
+// hypervDeleteScript turns the VM off, removes it and, when cascade is set,
+// deletes its VHD/VHDX files. It prints one JSON entry per removed disk.
+const hypervDeleteScript = `$vm = Get-VM -Id '%s' -ErrorAction Stop
+$paths = @($vm | Get-VMHardDiskDrive | Where-Object { $_.Path } | Select-Object -ExpandProperty Path)
+if ($vm.State -ne 'Off') { $vm | Stop-VM -TurnOff -Force }
+$vm | Remove-VM -Force
+$result = @()
+if (%s) {
+  foreach ($path in $paths) {
+    try {
+      Remove-Item -LiteralPath $path -Force -ErrorAction Stop
+      $result += @{ Path = $path; Deleted = $true }
+    } catch {
+      $result += @{ Path = $path; Deleted = $false; Error = $_.Exception.Message }
+    }
+  }
+}
+ConvertTo-Json -Compress -InputObject @($result)`
+
+func (p *HyperVProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	if err := validateHyperVID(id); err != nil {
+		return nil, err
+	}
+	cascadeFlag := "$false"
+	if cascade {
+		cascadeFlag = "$true"
+	}
+	stdOut, err := p.runPS(ctx, fmt.Sprintf(hypervDeleteScript, id, cascadeFlag))
+	if err != nil {
+		return []models.DeletedResource{deletedResource("vm", id, err)}, err
+	}
+	results := []models.DeletedResource{deletedResource("vm", id, nil)}
+
+	var disks []struct {
+		Path    string `json:"Path"`
+		Deleted bool   `json:"Deleted"`
+		Error   string `json:"Error"`
+	}
+	if err := json.Unmarshal([]byte(unquotePSOutput(stdOut)), &disks); err != nil {
+		return results, fmt.Errorf("VM deleted but disk cleanup output could not be parsed: %v", err)
+	}
+	for _, d := range disks {
+		results = append(results, models.DeletedResource{Type: "disk", ID: d.Path, Deleted: d.Deleted, Error: d.Error})
+	}
+	return results, nil
+}
+
+// psQuote returns s as a single-quoted PowerShell string literal. PowerShell
+// also treats the typographic single quotes as quote characters, so they are
+// doubled as well.
+func psQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '\u2018', '\u2019', '\u201a', '\u201b':
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// hypervNamePattern matches names that are valid VM names and file names.
+var hypervNamePattern = regexp.MustCompile(`^[^\\/:*?"<>|\x00-\x1f]{1,100}$`)
+
+// hypervCreateScript creates the VHDX of a new VM from the golden image in
+// spec.Image, as a copy or a differencing disk, creates and configures the VM
+// and prints its ID. On failure the VM and disk are removed again.
+func hypervCreateScript(spec models.VMSpec) (string, error) {
+	if !hypervNamePattern.MatchString(spec.Name) || strings.Trim(spec.Name, ". ") == "" {
+		return "", fmt.Errorf("invalid Hyper-V VM name %q", spec.Name)
+	}
+	if spec.Image == "" {
+		return "", fmt.Errorf("Hyper-V VM creation requires the path of a golden VHDX in image")
+	}
+	generation := spec.Generation
+	if generation == 0 {
+		generation = 2
+	}
+	if generation != 1 && generation != 2 {
+		return "", fmt.Errorf("invalid Hyper-V VM generation %d", generation)
+	}
+	cpus := spec.CPUs
+	if cpus == 0 {
+		cpus = 1
+	}
+	memoryMB := spec.MemoryMB
+	if memoryMB == 0 {
+		memoryMB = 2048
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ErrorActionPreference = 'Stop'\n")
+	fmt.Fprintf(&b, "$name = %s\n", psQuote(spec.Name))
+	fmt.Fprintf(&b, "$image = %s\n", psQuote(spec.Image))
+	if spec.Storage != "" {
+		fmt.Fprintf(&b, "$dir = %s\n", psQuote(spec.Storage))
+	} else {
+		fmt.Fprintf(&b, "$dir = (Get-VMHost).VirtualHardDiskPath\n")
+	}
+	b.WriteString("$vhd = Join-Path $dir ($name + [IO.Path]::GetExtension($image))\n")
+	b.WriteString("if (Test-Path -LiteralPath $vhd) { throw \"$vhd already exists\" }\n")
+	b.WriteString("$vm = $null\n")
+	b.WriteString("try {\n")
+	if spec.LinkedClone {
+		b.WriteString("  New-VHD -Path $vhd -ParentPath $image -Differencing | Out-Null\n")
+	} else {
+		b.WriteString("  Copy-Item -LiteralPath $image -Destination $vhd\n")
+	}
+	if spec.DiskSizeGB > 0 {
+		fmt.Fprintf(&b, "  if ((Get-VHD -Path $vhd).Size -lt %dGB) { Resize-VHD -Path $vhd -SizeBytes %dGB }\n", spec.DiskSizeGB, spec.DiskSizeGB)
+	}
+	fmt.Fprintf(&b, "  $vm = New-VM -Name $name -Generation %d -MemoryStartupBytes %dMB -VHDPath $vhd", generation, memoryMB)
+	if spec.Network != "" {
+		fmt.Fprintf(&b, " -SwitchName %s", psQuote(spec.Network))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  Set-VM -VM $vm -ProcessorCount %d\n", cpus)
+	if len(spec.Tags) > 0 {
+		fmt.Fprintf(&b, "  Set-VM -VM $vm -Notes %s\n", psQuote(hypervNotes("", spec.Tags)))
+	}
+	if !spec.SkipStart {
+		b.WriteString("  Start-VM -VM $vm\n")
+	}
+	b.WriteString("} catch {\n")
+	b.WriteString("  if ($vm) { $vm | Stop-VM -TurnOff -Force -ErrorAction SilentlyContinue; $vm | Remove-VM -Force -ErrorAction SilentlyContinue }\n")
+	b.WriteString("  Remove-Item -LiteralPath $vhd -Force -ErrorAction SilentlyContinue\n")
+	b.WriteString("  throw\n")
+	b.WriteString("}\n")
+	b.WriteString("$vm.Id.ToString()")
+	return b.String(), nil
+}
+
+// Images returns the image names and their golden VHDX paths.
+func (p *HyperVProvider) Images() map[string]string {
+	return p.images
+}
+
+// ResolveImage returns the golden VHDX path of an image name; paths are
+// returned as they are.
+func (p *HyperVProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	return imageAlias(p.images, image), nil
+}
+
+// CreateVM creates a VM whose boot disk is a copy of the golden VHDX at
+// spec.Image, a path or image name, or a differencing disk on top of it when spec.LinkedClone is
+// set. The disk is placed in spec.Storage or the host's default virtual hard
+// disk folder, the VM is connected to the virtual switch spec.Network and
+// started unless spec.SkipStart is set.
+func (p *HyperVProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	spec.Image = imageAlias(p.images, spec.Image)
+	script, err := hypervCreateScript(spec)
+	if err != nil {
+		return nil, err
+	}
+	stdOut, err := p.runPS(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	id := strings.ToLower(strings.TrimSpace(unquotePSOutput(stdOut)))
+	if err := validateHyperVID(id); err != nil {
+		return nil, fmt.Errorf("VM created but its ID could not be read: %v", err)
+	}
+	return p.GetVM(ctx, id)
+}
+
+// hypervGetScript returns the details of a single VM as JSON.
+const hypervGetScript = `$vm = Get-VM -Id '%s' -ErrorAction Stop
+$memory = if ($vm.MemoryAssigned) { $vm.MemoryAssigned } else { $vm.MemoryStartup }
+[pscustomobject]@{
+  Id = $vm.Id.ToString()
+  Name = $vm.Name
+  State = $vm.State.ToString()
+  ProcessorCount = $vm.ProcessorCount
+  MemoryMB = [int64]($memory / 1MB)
+  CreationTime = $vm.CreationTime.ToUniversalTime().ToString('o')
+  Notes = $vm.Notes
+  Disks = @($vm | Get-VMHardDiskDrive | Where-Object { $_.Path } | ForEach-Object {
+    $vhd = Get-VHD -Path $_.Path -ErrorAction SilentlyContinue
+    @{ Path = $_.Path; SizeGB = [int64]($vhd.Size / 1GB) }
+  })
+  IPAddresses = @($vm | Get-VMNetworkAdapter | ForEach-Object { $_.IPAddresses })
+} | ConvertTo-Json -Compress -Depth 4`
+
+type hypervVMDetails struct {
+	Id             string    `json:"Id"`
+	Name           string    `json:"Name"`
+	State          string    `json:"State"`
+	ProcessorCount int       `json:"ProcessorCount"`
+	MemoryMB       int64     `json:"MemoryMB"`
+	CreationTime   time.Time `json:"CreationTime"`
+	Notes          string    `json:"Notes"`
+	Disks          []struct {
+		Path   string `json:"Path"`
+		SizeGB int64  `json:"SizeGB"`
+	} `json:"Disks"`
+	IPAddresses []string `json:"IPAddresses"`
+}
+
+func (p *HyperVProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	if err := validateHyperVID(id); err != nil {
+		return nil, err
+	}
+	stdOut, err := p.runPS(ctx, fmt.Sprintf(hypervGetScript, id))
+	if err != nil {
+		return nil, err
+	}
+	var hv hypervVMDetails
+	if err := json.Unmarshal([]byte(unquotePSOutput(stdOut)), &hv); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON output: %v , std out: %s", err, stdOut)
+	}
+
+	vm := &models.VM{
+		ID:         strings.ToLower(hv.Id),
+		Name:       hv.Name,
+		Provider:   "hyperv",
+		Region:     p.host,
+		Status:     hypervStatus(hv.State),
+		RawStatus:  hv.State,
+		CPUs:       hv.ProcessorCount,
+		MemoryMB:   hv.MemoryMB,
+		PrivateIPs: hv.IPAddresses,
+		Tags:       hypervTags(hv.Notes),
+	}
+	if !hv.CreationTime.IsZero() {
+		vm.CreatedAt = &hv.CreationTime
+	}
+	for i, d := range hv.Disks {
+		vm.Disks = append(vm.Disks, models.Disk{ID: d.Path, SizeGB: d.SizeGB, Boot: i == 0})
+	}
+	return vm, nil
+}
+
+// hypervNotesTable maps the lowercase VM IDs to their notes.
+const hypervNotesTable = `$hypervNotes = @{}
+Get-WmiObject -Namespace "root\virtualization\v2" -Class "Msvm_VirtualSystemSettingData" -Filter "VirtualSystemType='Microsoft:Hyper-V:System:Realized'" | ForEach-Object { $hypervNotes[$_.ConfigurationID.ToLower()] = $_.Notes -join [char]10 }
+`
+
+// hypervTagPrefix starts the lines of a VM's notes that hold a tag, e.g.
+// "#tag env=prod". The other lines are free text and left alone.
+const hypervTagPrefix = "#tag "
+
+// hypervTags returns the tags kept in a VM's notes.
+func hypervTags(notes string) map[string]string {
+	var tags map[string]string
+	for _, line := range strings.Split(notes, "\n") {
+		tag, found := strings.CutPrefix(strings.TrimRight(line, "\r"), hypervTagPrefix)
+		if !found {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// hypervNotes replaces the tag lines of notes with tags.
+func hypervNotes(notes string, tags map[string]string) string {
+	var lines []string
+	for _, line := range strings.Split(notes, "\n") {
+		if !strings.HasPrefix(line, hypervTagPrefix) {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for _, key := range sortedKeys(tags) {
+		lines = append(lines, hypervTagPrefix+key+"="+tags[key])
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// UpdateTags rewrites the tag lines of the VM's notes.
+func (p *HyperVProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	if err := validateHyperVID(id); err != nil {
+		return err
+	}
+	stdOut, err := p.runPS(ctx, fmt.Sprintf("ConvertTo-Json -Compress -InputObject @{ Notes = [string](Get-VM -Id '%s' -ErrorAction Stop).Notes }", id))
+	if err != nil {
+		return err
+	}
+	var current struct {
+		Notes string `json:"Notes"`
+	}
+	if err := json.Unmarshal([]byte(unquotePSOutput(stdOut)), &current); err != nil {
+		return fmt.Errorf("failed to parse JSON output: %v , std out: %s", err, stdOut)
+	}
+	tags := mergeTags(hypervTags(current.Notes), set, remove)
+	if err := ValidateTags("hyperv", tags); err != nil {
+		return err
+	}
+	return p.vmCommand(ctx, id, "Set-VM -Notes "+psQuote(hypervNotes(current.Notes, tags)))
+}
+
+// hypervStateTable maps Msvm_ComputerSystem.EnabledState values to VMState names.
+const hypervStateTable = `$hypervStates = @{2="Running";3="Off";4="Stopping";6="Saved";10="Starting";32768="Paused";32769="Saved";32770="Starting";32773="Saving";32774="Stopping";32776="Pausing";32777="Resuming"}
+`
+
+// hypervStatus maps a Hyper-V VMState name to the normalized status.
+func hypervStatus(state string) models.VMStatus {
+	switch state {
+	case "Running", "RunningCritical":
+		return models.StatusRunning
+	case "Off", "OffCritical":
+		return models.StatusStopped
+	case "Starting", "Resuming", "StartingCritical", "ResumingCritical":
+		return models.StatusStarting
+	case "Stopping", "Saving", "Pausing", "FastSaving", "StoppingCritical", "SavingCritical", "PausingCritical", "FastSavingCritical":
+		return models.StatusStopping
+	case "Saved", "Paused", "FastSaved", "SavedCritical", "PausedCritical", "FastSavedCritical":
+		return models.StatusSuspended
+	}
+	return models.StatusUnknown
+}
@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// fakeWinRM records the scripts it is asked to run and answers each with the
+// next entry of outputs.
+type fakeWinRM struct {
+	scripts []string
+	outputs []string
+}
+
+func (f *fakeWinRM) RunPSWithContext(ctx context.Context, command string) (string, string, int, error) {
+	f.scripts = append(f.scripts, command)
+	if len(f.outputs) == 0 {
+		return "", "unexpected script", 1, nil
+	}
+	out := f.outputs[0]
+	f.outputs = f.outputs[1:]
+	return out, "", 0, nil
+}
+
+const hypervTestID = "5f1c7b9e-3a2d-4c8e-9b1a-0d2e4f6a8c0b"
+
+func TestHyperVCreateVM(t *testing.T) {
+	fake := &fakeWinRM{outputs: []string{
+		strings.ToUpper(hypervTestID) + "\r\n",
+		`{"Id":"` + hypervTestID + `","Name":"web01","State":"Running","ProcessorCount":2,"MemoryMB":4096}`,
+	}}
+	p := &HyperVProvider{client: fake, host: "hv01"}
+
+	vm, err := p.CreateVM(context.Background(), models.VMSpec{
+		Name:        "web01",
+		Image:       `D:\Golden\ubuntu24.vhdx`,
+		CPUs:        2,
+		MemoryMB:    4096,
+		DiskSizeGB:  64,
+		Network:     "External",
+		LinkedClone: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.ID != hypervTestID || vm.Status != models.StatusRunning || vm.CPUs != 2 {
+		t.Errorf("unexpected VM %+v", vm)
+	}
+	if len(fake.scripts) != 2 || !strings.Contains(fake.scripts[1], "Get-VM -Id '"+hypervTestID+"'") {
+		t.Fatalf("expected the create script followed by a lookup of the new VM, got %q", fake.scripts)
+	}
+	for _, want := range []string{
+		"$name = 'web01'\n",
+		`$image = 'D:\Golden\ubuntu24.vhdx'` + "\n",
+		"$dir = (Get-VMHost).VirtualHardDiskPath\n",
+		"New-VHD -Path $vhd -ParentPath $image -Differencing",
+		"Resize-VHD -Path $vhd -SizeBytes 64GB",
+		"New-VM -Name $name -Generation 2 -MemoryStartupBytes 4096MB -VHDPath $vhd -SwitchName 'External'\n",
+		"Set-VM -VM $vm -ProcessorCount 2\n",
+		"Start-VM -VM $vm\n",
+	} {
+		if !strings.Contains(fake.scripts[0], want) {
+			t.Errorf("create script does not contain %q:\n%s", want, fake.scripts[0])
+		}
+	}
+}
+
+func TestHyperVCreateScriptCopy(t *testing.T) {
+	script, err := hypervCreateScript(models.VMSpec{Name: "db01", Image: `D:\Golden\w2022.vhdx`, Storage: `E:\VMs`, Generation: 1, SkipStart: true,
+		Tags: map[string]string{"env": "prod", "owner": "o'brien"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`$dir = 'E:\VMs'`,
+		"Copy-Item -LiteralPath $image -Destination $vhd\n",
+		"-Generation 1 -MemoryStartupBytes 2048MB -VHDPath $vhd\n",
+		"Set-VM -VM $vm -ProcessorCount 1\n",
+		"Set-VM -VM $vm -Notes '#tag env=prod\r\n#tag owner=o''brien'\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("create script does not contain %q:\n%s", want, script)
+		}
+	}
+	for _, unwanted := range []string{"Start-VM", "Resize-VHD", "-Differencing", "-SwitchName"} {
+		if strings.Contains(script, unwanted) {
+			t.Errorf("create script contains %q:\n%s", unwanted, script)
+		}
+	}
+}
+
+func TestHyperVCreateScriptEscaping(t *testing.T) {
+	script, err := hypervCreateScript(models.VMSpec{
+		Name:    "it's",
+		Image:   `D:\x'; Remove-Item C:\ -Recurse; '.vhdx`,
+		Network: "sw\u2019; Stop-Computer; \u2018",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"$name = 'it''s'\n",
+		`$image = 'D:\x''; Remove-Item C:\ -Recurse; ''.vhdx'` + "\n",
+		"-SwitchName 'sw\u2019\u2019; Stop-Computer; \u2018\u2018'\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("create script does not contain %q:\n%s", want, script)
+		}
+	}
+
+	for _, name := range []string{"", `..\..\Windows\web`, "a/b", "web\n01", "..", strings.Repeat("x", 101)} {
+		if _, err := hypervCreateScript(models.VMSpec{Name: name, Image: `D:\Golden\ubuntu24.vhdx`}); err == nil {
+			t.Errorf("name %q was accepted", name)
+		}
+	}
+	if _, err := hypervCreateScript(models.VMSpec{Name: "web01", Image: `D:\Golden\ubuntu24.vhdx`, Generation: 3}); err == nil {
+		t.Error("generation 3 was accepted")
+	}
+}
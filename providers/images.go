@@ -0,0 +1,114 @@
+package providers
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// ImageResolver is implemented by providers that create VMs from the logical
+// images of the catalog (see config.ImageAliases).
+type ImageResolver interface {
+	// Images returns the logical image names the provider knows and their
+	// references.
+	Images() map[string]string
+
+	// ResolveImage returns the native image an image name or reference stands
+	// for right now, e.g. the newest AMI matching an "<owner>/<name pattern>"
+	// reference. Native images are returned as they are.
+	ResolveImage(ctx context.Context, image string) (string, error)
+}
+
+// imageAlias returns the reference of the logical image name image, or image
+// itself if it isn't one.
+func imageAlias(images map[string]string, image string) string {
+	if ref, ok := images[strings.ToLower(image)]; ok {
+		return ref
+	}
+	return image
+}
+
+// ListImages returns the logical images of the given providers, or of every
+// registered provider when names is empty, sorted by name. Providers that
+// don't implement ImageResolver have none. With resolve, the references are
+// resolved concurrently per provider; failures are reported per reference.
+func (cm *CloudManager) ListImages(ctx context.Context, resolve bool, names ...string) []models.Image {
+	var mu sync.Mutex
+	images := make(map[string]*models.Image)
+	add := func(name, provider string, ref models.ImageReference) {
+		mu.Lock()
+		defer mu.Unlock()
+		img, ok := images[name]
+		if !ok {
+			img = &models.Image{Name: name, Providers: make(map[string]models.ImageReference)}
+			images[name] = img
+		}
+		img.Providers[provider] = ref
+	}
+
+	providers := cm.GetAllProviders()
+	if len(names) == 0 {
+		for name := range providers {
+			names = append(names, name)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		resolver, ok := providers[name].(ImageResolver)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, resolver ImageResolver) {
+			defer wg.Done()
+			pctx, cancel := cm.ReadContext(ctx, name)
+			defer cancel()
+			typ := cm.ProviderType(name)
+			for image, reference := range resolver.Images() {
+				ref := models.ImageReference{Type: typ, Reference: reference}
+				if resolve {
+					resolved, err := resolver.ResolveImage(pctx, image)
+					if err != nil {
+						ref.Error = err.Error()
+					} else if resolved != reference {
+						ref.Resolved = resolved
+					}
+				}
+				add(image, name, ref)
+			}
+		}(name, resolver)
+	}
+	wg.Wait()
+
+	list := make([]models.Image, 0, len(images))
+	for _, img := range images {
+		list = append(list, *img)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// compareVersions compares dotted version numbers such as Azure marketplace
+// image versions part by part, numerically where both parts are numbers.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aerr != nil || berr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+func TestListImages(t *testing.T) {
+	cfg := loadConfig(t, `
+hyperv: {host: hv01, username: admin, password: secret}
+images:
+  ubuntu24: {hyperv: 'D:\Golden\ubuntu24.vhdx'}
+  rocky9: {proxmox: rocky9-tmpl}
+`)
+	cm := NewCloudManager(nil)
+	for _, name := range []string{"hyperv", "hv-lab"} {
+		p, ok := NewHyperVProvider(cfg)
+		if !ok {
+			t.Fatal("Hyper-V provider not created")
+		}
+		cm.RegisterProvider(name, "hyperv", p)
+	}
+
+	images := cm.ListImages(context.Background(), true)
+	if len(images) != 1 || images[0].Name != "ubuntu24" {
+		t.Fatalf("got images %+v, want only ubuntu24", images)
+	}
+	for _, name := range []string{"hyperv", "hv-lab"} {
+		ref := images[0].Providers[name]
+		if ref.Type != "hyperv" || ref.Reference != `D:\Golden\ubuntu24.vhdx` || ref.Resolved != "" || ref.Error != "" {
+			t.Errorf("%s: unexpected reference %+v", name, ref)
+		}
+	}
+}
+
+func TestProxmoxResolveImage(t *testing.T) {
+	server := httptest.NewServer(&fakeProxmox{requests: make(map[string]url.Values)})
+	defer server.Close()
+	client, err := proxmox.NewClient(server.URL, server.Client(), "", nil, "", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxmoxVEProvider{client: client, node: "pve1", images: map[string]string{
+		"ubuntu24": "ubuntu24-*",
+		"debian12": "debian12-*",
+	}}
+
+	tests := []struct{ image, want string }{
+		{"ubuntu24", "9002"},
+		{"UBUNTU24", "9002"},
+		{"ubuntu24-tmpl", "ubuntu24-tmpl"},
+		{"9000", "9000"},
+		{"debian12", ""},
+		{"ubuntu24-[", ""},
+	}
+	for _, tt := range tests {
+		got, err := p.ResolveImage(context.Background(), tt.image)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("ResolveImage(%q) = %q, %v, want %q", tt.image, got, err, tt.want)
+		}
+	}
+}
+
+func TestGCPImageFamily(t *testing.T) {
+	tests := []struct {
+		ref             string
+		project, family string
+		ok              bool
+	}{
+		{"projects/ubuntu-os-cloud/global/images/family/ubuntu-2404-lts-amd64", "ubuntu-os-cloud", "ubuntu-2404-lts-amd64", true},
+		{"https://www.googleapis.com/compute/v1/projects/debian-cloud/global/images/family/debian-12", "debian-cloud", "debian-12", true},
+		{"projects/ubuntu-os-cloud/global/images/ubuntu-2404-noble-amd64-v20250313", "", "", false},
+		{"global/images/family/custom", "", "", false},
+	}
+	for _, tt := range tests {
+		project, family, ok := gcpImageFamily(tt.ref)
+		if project != tt.project || family != tt.family || ok != tt.ok {
+			t.Errorf("gcpImageFamily(%q) = %q, %q, %v", tt.ref, project, family, ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"24.04.202409120", "24.04.202409120", 0},
+		{"24.04.202410020", "24.04.202409120", 1},
+		{"9.0.1", "10.0.0", -1},
+		{"1.2", "1.2.1", -1},
+		{"1.0.0", "", 1},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if (got < 0) != (tt.want < 0) || (got > 0) != (tt.want > 0) {
+			t.Errorf("compareVersions(%q, %q) = %d, want sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
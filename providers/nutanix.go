@@ -1,51 +1,489 @@
 package providers
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 )
 
 type NutanixProvider struct {
-	apiURL   string
-	username string
-	password string
+	apiURL     string
+	username   string
+	password   string
+	httpClient *http.Client
+	images     map[string]string // image names -> image name or UUID
 }
 
-func NewNutanixProvider(cfg interface{}) (*NutanixProvider, bool) {
-	// Load Nutanix credentials from environment variables.
-	apiURL := os.Getenv("NUTANIX_API_URL")
-	username := os.Getenv("NUTANIX_USERNAME")
-	password := os.Getenv("NUTANIX_PASSWORD")
-
-	if apiURL == "" || username == "" || password == "" {
+func NewNutanixProvider(cfg *config.Config) (*NutanixProvider, bool) {
+	creds := cfg.NutanixCreds
+	if creds.APIURL == "" || creds.Username == "" || creds.Password == "" {
 		fmt.Printf("Nutanix credentials not configured. Will continue without it.\r\n")
 		return nil, false
 	}
 
+	// Prism Central usually runs with a self-signed certificate.
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: creds.Insecure}},
+	}
+
+	apiURL := strings.TrimSuffix(creds.APIURL, "/")
+	if !strings.Contains(apiURL, "/api/nutanix/") {
+		apiURL += "/api/nutanix/v3"
+	}
+
 	return &NutanixProvider{
-		apiURL:   apiURL,
-		username: username,
-		password: password,
+		apiURL:     apiURL,
+		username:   creds.Username,
+		password:   creds.Password,
+		httpClient: httpClient,
+		images:     cfg.ImageAliases("nutanix"),
 	}, true
 }
 
-func (p *NutanixProvider) ListVMs() ([]models.VM, error) {
-	// Stub implementation. In production, use Nutanix API to retrieve VMs.
-	if p.apiURL == "" || p.username == "" || p.password == "" {
-		return nil, errors.New("Nutanix credentials not configured")
+// Close drops the connections of the provider.
+func (p *NutanixProvider) Close() error {
+	p.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// nutanixPageSize is the number of entities requested per list call (max 500).
+const nutanixPageSize = 250
+
+// ListVMs pages through POST /vms/list.
+func (p *NutanixProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	var vms []models.VM
+	for offset := 0; ; {
+		var page struct {
+			Entities []nutanixVM `json:"entities"`
+			Metadata struct {
+				TotalMatches int `json:"total_matches"`
+			} `json:"metadata"`
+		}
+		req := map[string]interface{}{"kind": "vm", "length": nutanixPageSize, "offset": offset}
+		if err := p.do(ctx, http.MethodPost, "/vms/list", req, &page); err != nil {
+			return nil, err
+		}
+		for _, vm := range page.Entities {
+			vms = append(vms, nutanixToModel(vm))
+		}
+		offset += len(page.Entities)
+		if len(page.Entities) == 0 || offset >= page.Metadata.TotalMatches {
+			return vms, nil
+		}
+	}
+}
+
+// nutanixUUIDPattern matches a Prism entity UUID.
+var nutanixUUIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// lookupUUID resolves the name of a cluster, image or subnet to its UUID.
+// Values that already are UUIDs are returned unchanged.
+func (p *NutanixProvider) lookupUUID(ctx context.Context, kind, name string) (string, error) {
+	if nutanixUUIDPattern.MatchString(name) {
+		return name, nil
+	}
+	var list struct {
+		Entities []struct {
+			Metadata struct {
+				UUID string `json:"uuid"`
+			} `json:"metadata"`
+		} `json:"entities"`
+	}
+	req := map[string]interface{}{"kind": kind, "filter": "name==" + fiqlEscape(name)}
+	if err := p.do(ctx, http.MethodPost, "/"+kind+"s/list", req, &list); err != nil {
+		return "", err
+	}
+	switch len(list.Entities) {
+	case 0:
+		return "", fmt.Errorf("nutanix %s %q not found", kind, name)
+	case 1:
+		return list.Entities[0].Metadata.UUID, nil
+	}
+	return "", fmt.Errorf("nutanix %s name %q is ambiguous", kind, name)
+}
+
+// fiqlEscape percent-encodes the characters of value that have a meaning in
+// a FIQL filter, so names can't add conditions to it.
+func fiqlEscape(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if strings.IndexByte(";,()=!<>~*%'\" \\", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// vmPath returns the API path of the VM with the given ID, which has to be a
+// UUID so IDs from request URLs can't point the call at another entity.
+func vmPath(id string) (string, error) {
+	if !nutanixUUIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid Nutanix VM ID %q", id)
+	}
+	return "/vms/" + id, nil
+}
+
+// Images returns the image names and their Nutanix images.
+func (p *NutanixProvider) Images() map[string]string {
+	return p.images
+}
+
+// ResolveImage returns the UUID of the image an image name, Nutanix image
+// name or UUID stands for.
+func (p *NutanixProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	return p.lookupUUID(ctx, "image", imageAlias(p.images, image))
+}
+
+// CreateVM creates a VM on the cluster named by spec.Region with a boot disk
+// cloned from the image spec.Image and a NIC on the subnet spec.Network, and
+// powers it on. Names are resolved to UUIDs; UUIDs are accepted as well.
+func (p *NutanixProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	if spec.Region == "" || spec.Image == "" {
+		return nil, fmt.Errorf("Nutanix VM creation requires a cluster in region and an image")
+	}
+	clusterUUID, err := p.lookupUUID(ctx, "cluster", spec.Region)
+	if err != nil {
+		return nil, err
+	}
+	imageUUID, err := p.lookupUUID(ctx, "image", imageAlias(p.images, spec.Image))
+	if err != nil {
+		return nil, err
 	}
 
-	vms := []models.VM{
-		{
-			ID:       "nut-001",
-			Name:     "NutanixVM1",
-			Provider: "nutanix",
-			Region:   "cluster1",
-			Status:   "running",
+	cpus := spec.CPUs
+	if cpus == 0 {
+		cpus = 1
+	}
+	memoryMB := spec.MemoryMB
+	if memoryMB == 0 {
+		memoryMB = 2048
+	}
+	disk := map[string]interface{}{
+		"device_properties":     map[string]interface{}{"device_type": "DISK"},
+		"data_source_reference": map[string]interface{}{"kind": "image", "uuid": imageUUID},
+	}
+	if spec.DiskSizeGB > 0 {
+		disk["disk_size_mib"] = spec.DiskSizeGB * 1024
+	}
+	resources := map[string]interface{}{
+		"num_sockets":          cpus,
+		"num_vcpus_per_socket": 1,
+		"memory_size_mib":      memoryMB,
+		"power_state":          "ON",
+		"disk_list":            []interface{}{disk},
+	}
+	if spec.Network != "" {
+		subnetUUID, err := p.lookupUUID(ctx, "subnet", spec.Network)
+		if err != nil {
+			return nil, err
+		}
+		resources["nic_list"] = []interface{}{map[string]interface{}{
+			"subnet_reference": map[string]interface{}{"kind": "subnet", "uuid": subnetUUID},
+		}}
+	}
+	body := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name":              spec.Name,
+			"resources":         resources,
+			"cluster_reference": map[string]interface{}{"kind": "cluster", "uuid": clusterUUID},
 		},
+		"metadata": map[string]interface{}{"kind": "vm"},
+	}
+
+	var resp struct {
+		nutanixTaskResponse
+		Metadata struct {
+			UUID string `json:"uuid"`
+		} `json:"metadata"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/vms", body, &resp); err != nil {
+		return nil, err
+	}
+	if taskUUID := resp.Status.ExecutionContext.TaskUUID; taskUUID != "" {
+		if err := p.waitTask(ctx, taskUUID); err != nil {
+			return nil, err
+		}
+	}
+	return p.GetVM(ctx, resp.Metadata.UUID)
+}
+
+// do sends a request to the Prism Central v3 API and decodes the JSON response into out.
+func (p *NutanixProvider) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.username, p.password)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("nutanix API %s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// nutanixTaskResponse is the part of an intent response that identifies the
+// task started by a create, update or delete call.
+type nutanixTaskResponse struct {
+	Status struct {
+		ExecutionContext struct {
+			TaskUUID string `json:"task_uuid"`
+		} `json:"execution_context"`
+	} `json:"status"`
+}
+
+// waitTask polls a Prism task until it has finished, reporting its progress
+// to the operation running with ctx.
+func (p *NutanixProvider) waitTask(ctx context.Context, taskUUID string) error {
+	for {
+		var task struct {
+			Status             string `json:"status"`
+			ErrorDetail        string `json:"error_detail"`
+			PercentageComplete int    `json:"percentage_complete"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/tasks/"+taskUUID, nil, &task); err != nil {
+			return err
+		}
+		switch task.Status {
+		case "SUCCEEDED":
+			return nil
+		case "FAILED", "ABORTED":
+			return fmt.Errorf("nutanix task %s %s: %s", taskUUID, strings.ToLower(task.Status), task.ErrorDetail)
+		}
+		operations.Progress(ctx, task.PercentageComplete, "nutanix task %s is %s", taskUUID, strings.ToLower(task.Status))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// setPowerState updates the desired power state of a VM and waits for the
+// resulting task. mechanism is one of "HARD", "ACPI" or "GUEST".
+func (p *NutanixProvider) setPowerState(ctx context.Context, id, state, mechanism string) error {
+	path, err := vmPath(id)
+	if err != nil {
+		return err
+	}
+	var vm map[string]interface{}
+	if err := p.do(ctx, http.MethodGet, path, nil, &vm); err != nil {
+		return err
+	}
+	spec, _ := vm["spec"].(map[string]interface{})
+	resources, _ := spec["resources"].(map[string]interface{})
+	if resources == nil {
+		return fmt.Errorf("nutanix VM %s has no spec", id)
+	}
+	resources["power_state"] = state
+	resources["power_state_mechanism"] = map[string]interface{}{"mechanism": mechanism}
+
+	// The status section is read-only and must not be sent back.
+	delete(vm, "status")
+
+	var resp nutanixTaskResponse
+	if err := p.do(ctx, http.MethodPut, path, vm, &resp); err != nil {
+		return err
+	}
+	if taskUUID := resp.Status.ExecutionContext.TaskUUID; taskUUID != "" {
+		return p.waitTask(ctx, taskUUID)
+	}
+	return nil
+}
+
+func (p *NutanixProvider) StartVM(ctx context.Context, id string) error {
+	return p.setPowerState(ctx, id, "ON", "HARD")
+}
+
+func (p *NutanixProvider) StopVM(ctx context.Context, id string, force bool) error {
+	if force {
+		return p.setPowerState(ctx, id, "OFF", "HARD")
+	}
+	return p.setPowerState(ctx, id, "OFF", "ACPI")
+}
+
+// RestartVM power cycles the VM; the v3 API has no single reboot call.
+func (p *NutanixProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	if err := p.StopVM(ctx, id, force); err != nil {
+		return err
+	}
+	return p.StartVM(ctx, id)
+}
+
+func (p *NutanixProvider) SuspendVM(ctx context.Context, id string) error {
+	return p.CheckPower("suspend", false)
+}
+
+// CheckPower refuses suspending, which AHV doesn't offer through the v3 API.
+func (p *NutanixProvider) CheckPower(action string, force bool) error {
+	if action == "suspend" {
+		return notSupported("nutanix", "suspend")
+	}
+	return nil
+}
+
+// DeleteVM deletes the VM. AHV always removes the VM's vDisks with it.
+func (p *NutanixProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	path, err := vmPath(id)
+	if err != nil {
+		return nil, err
+	}
+	var vm nutanixVM
+	if err := p.do(ctx, http.MethodGet, path, nil, &vm); err != nil {
+		return nil, err
+	}
+
+	var resp nutanixTaskResponse
+	err = p.do(ctx, http.MethodDelete, path, nil, &resp)
+	if err == nil && resp.Status.ExecutionContext.TaskUUID != "" {
+		err = p.waitTask(ctx, resp.Status.ExecutionContext.TaskUUID)
+	}
+	if err != nil {
+		return []models.DeletedResource{deletedResource("vm", id, err)}, err
+	}
+
+	results := []models.DeletedResource{deletedResource("vm", id, nil)}
+	for _, disk := range vm.Status.Resources.DiskList {
+		if disk.DeviceProperties.DeviceType == "DISK" {
+			results = append(results, deletedResource("disk", disk.UUID, nil))
+		}
+	}
+	return results, nil
+}
+
+// nutanixVM is the subset of a v3 VM intent response that AnyVM uses.
+type nutanixVM struct {
+	Status struct {
+		Name      string `json:"name"`
+		Resources struct {
+			NumSockets        int    `json:"num_sockets"`
+			NumVcpusPerSocket int    `json:"num_vcpus_per_socket"`
+			MemorySizeMib     int64  `json:"memory_size_mib"`
+			PowerState        string `json:"power_state"`
+			DiskList          []struct {
+				UUID             string `json:"uuid"`
+				DiskSizeMib      int64  `json:"disk_size_mib"`
+				DeviceProperties struct {
+					DeviceType string `json:"device_type"`
+				} `json:"device_properties"`
+				DataSourceReference *struct {
+					Kind string `json:"kind"`
+					Name string `json:"name"`
+					UUID string `json:"uuid"`
+				} `json:"data_source_reference"`
+			} `json:"disk_list"`
+			NicList []struct {
+				IPEndpointList []struct {
+					IP string `json:"ip"`
+				} `json:"ip_endpoint_list"`
+			} `json:"nic_list"`
+		} `json:"resources"`
+		ClusterReference struct {
+			Name string `json:"name"`
+			UUID string `json:"uuid"`
+		} `json:"cluster_reference"`
+	} `json:"status"`
+	Metadata struct {
+		UUID         string            `json:"uuid"`
+		CreationTime *time.Time        `json:"creation_time"`
+		Categories   map[string]string `json:"categories"`
+	} `json:"metadata"`
+}
+
+func (p *NutanixProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	path, err := vmPath(id)
+	if err != nil {
+		return nil, err
+	}
+	var vm nutanixVM
+	if err := p.do(ctx, http.MethodGet, path, nil, &vm); err != nil {
+		return nil, err
+	}
+	m := nutanixToModel(vm)
+	return &m, nil
+}
+
+func nutanixToModel(vm nutanixVM) models.VM {
+	res := vm.Status.Resources
+	m := models.VM{
+		ID:        vm.Metadata.UUID,
+		Name:      vm.Status.Name,
+		Provider:  "nutanix",
+		Region:    vm.Status.ClusterReference.Name,
+		Status:    nutanixStatus(res.PowerState),
+		RawStatus: res.PowerState,
+		CPUs:      res.NumSockets * res.NumVcpusPerSocket,
+		MemoryMB:  res.MemorySizeMib,
+		CreatedAt: vm.Metadata.CreationTime,
+	}
+	if len(vm.Metadata.Categories) > 0 {
+		m.Tags = vm.Metadata.Categories
+	}
+	for _, disk := range res.DiskList {
+		if disk.DeviceProperties.DeviceType != "DISK" {
+			continue
+		}
+		m.Disks = append(m.Disks, models.Disk{
+			ID:     disk.UUID,
+			SizeGB: disk.DiskSizeMib / 1024,
+			Boot:   len(m.Disks) == 0,
+		})
+		if ref := disk.DataSourceReference; ref != nil && ref.Kind == "image" && m.Image == "" {
+			m.Image = ref.Name
+			if m.Image == "" {
+				m.Image = ref.UUID
+			}
+		}
+	}
+	for _, nic := range res.NicList {
+		for _, ep := range nic.IPEndpointList {
+			m.PrivateIPs = append(m.PrivateIPs, ep.IP)
+		}
+	}
+	return m
+}
+
+// nutanixStatus maps an AHV power state to the normalized status.
+func nutanixStatus(state string) models.VMStatus {
+	switch state {
+	case "ON":
+		return models.StatusRunning
+	case "OFF":
+		return models.StatusStopped
+	case "PAUSED", "SUSPENDED":
+		return models.StatusSuspended
 	}
-	return vms, nil
+	return models.StatusUnknown
 }
@@ -0,0 +1,235 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fuddata/anyvm/models"
+)
+
+// fakePrism serves the parts of the Prism Central v3 API used by the Nutanix
+// provider. Lists return at most two entities per page, like a small page
+// size would, and every task has succeeded when it is first polled.
+type fakePrism struct {
+	mu       sync.Mutex
+	vms      map[string]map[string]interface{} // VM intents by UUID
+	order    []string                          // VM UUIDs in list order
+	names    map[string]string                 // "kind name" -> UUID
+	requests []string                          // "METHOD path" of every request
+	created  map[string]interface{}            // body of the last POST /vms
+	filters  []string                          // filters of the name lookups
+}
+
+const (
+	prismCluster = "00000000-0000-0000-0000-00000000c001"
+	prismImage   = "00000000-0000-0000-0000-00000000a001"
+	prismSubnet  = "00000000-0000-0000-0000-00000000b001"
+	prismNewVM   = "00000000-0000-0000-0000-0000000000ff"
+	prismTask    = "00000000-0000-0000-0000-0000000000aa"
+)
+
+func newFakePrism() *fakePrism {
+	f := &fakePrism{
+		vms: make(map[string]map[string]interface{}),
+		names: map[string]string{
+			"cluster cluster1": prismCluster,
+			"image ubuntu24":   prismImage,
+			"subnet vlan10":    prismSubnet,
+		},
+	}
+	for i, state := range []string{"ON", "OFF", "PAUSED"} {
+		f.addVM(fmt.Sprintf("00000000-0000-0000-0000-00000000000%d", i+1), fmt.Sprintf("vm%d", i+1), state)
+	}
+	return f
+}
+
+func (f *fakePrism) addVM(uuid, name, state string) {
+	f.vms[uuid] = map[string]interface{}{
+		"metadata": map[string]interface{}{"kind": "vm", "uuid": uuid},
+		"spec":     map[string]interface{}{"name": name, "resources": map[string]interface{}{"power_state": state}},
+		"status": map[string]interface{}{
+			"name":              name,
+			"cluster_reference": map[string]interface{}{"kind": "cluster", "name": "cluster1", "uuid": prismCluster},
+			"resources": map[string]interface{}{
+				"num_sockets": 2, "num_vcpus_per_socket": 1, "memory_size_mib": 4096, "power_state": state,
+				"disk_list": []interface{}{
+					map[string]interface{}{"uuid": uuid[:35] + "d", "disk_size_mib": 20480, "device_properties": map[string]interface{}{"device_type": "DISK"},
+						"data_source_reference": map[string]interface{}{"kind": "image", "name": "ubuntu24", "uuid": prismImage}},
+					map[string]interface{}{"uuid": uuid[:35] + "c", "device_properties": map[string]interface{}{"device_type": "CDROM"}},
+				},
+			},
+		},
+	}
+	f.order = append(f.order, uuid)
+}
+
+func (f *fakePrism) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/api/nutanix/v3")
+	f.requests = append(f.requests, r.Method+" "+path)
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	task := map[string]interface{}{"status": map[string]interface{}{"execution_context": map[string]interface{}{"task_uuid": prismTask}}}
+
+	switch {
+	case r.Method == http.MethodPost && path == "/vms/list":
+		offset, _ := body["offset"].(float64)
+		var page []interface{}
+		for _, uuid := range f.order[int(offset):] {
+			if len(page) == 2 {
+				break
+			}
+			page = append(page, f.vms[uuid])
+		}
+		reply(map[string]interface{}{"entities": page, "metadata": map[string]interface{}{"total_matches": len(f.order)}})
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "s/list"):
+		kind, _ := body["kind"].(string)
+		filter, _ := body["filter"].(string)
+		f.filters = append(f.filters, filter)
+		var entities []interface{}
+		if uuid, ok := f.names[kind+" "+strings.TrimPrefix(filter, "name==")]; ok {
+			entities = append(entities, map[string]interface{}{"metadata": map[string]interface{}{"uuid": uuid}})
+		}
+		reply(map[string]interface{}{"entities": entities})
+	case r.Method == http.MethodPost && path == "/vms":
+		f.created = body
+		f.addVM(prismNewVM, "web01", "ON")
+		task["metadata"] = map[string]interface{}{"uuid": prismNewVM}
+		reply(task)
+	case r.Method == http.MethodGet && path == "/tasks/"+prismTask:
+		reply(map[string]interface{}{"status": "SUCCEEDED", "percentage_complete": 100})
+	case strings.HasPrefix(path, "/vms/"):
+		uuid := strings.TrimPrefix(path, "/vms/")
+		vm, ok := f.vms[uuid]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			reply(vm)
+		case http.MethodPut:
+			state := body["spec"].(map[string]interface{})["resources"].(map[string]interface{})["power_state"]
+			vm["status"].(map[string]interface{})["resources"].(map[string]interface{})["power_state"] = state
+			reply(task)
+		case http.MethodDelete:
+			delete(f.vms, uuid)
+			reply(task)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestNutanix(t *testing.T) (*NutanixProvider, *fakePrism) {
+	fake := newFakePrism()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &NutanixProvider{apiURL: server.URL + "/api/nutanix/v3", httpClient: server.Client()}, fake
+}
+
+func TestNutanixListVMs(t *testing.T) {
+	p, fake := newTestNutanix(t)
+	vms, err := p.ListVMs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]models.VMStatus{"vm1": models.StatusRunning, "vm2": models.StatusStopped, "vm3": models.StatusSuspended}
+	if len(vms) != len(want) {
+		t.Fatalf("got %d VMs, want %d", len(vms), len(want))
+	}
+	for _, vm := range vms {
+		if vm.Status != want[vm.Name] || vm.Region != "cluster1" || vm.CPUs != 2 || vm.MemoryMB != 4096 || vm.Image != "ubuntu24" || len(vm.Disks) != 1 {
+			t.Errorf("unexpected VM %+v", vm)
+		}
+	}
+	if lists := countRequests(fake, "POST /vms/list"); lists != 2 {
+		t.Errorf("listed %d pages, want 2", lists)
+	}
+}
+
+func TestNutanixCreateVM(t *testing.T) {
+	p, fake := newTestNutanix(t)
+	vm, err := p.CreateVM(context.Background(), models.VMSpec{
+		Name: "web01", Region: "cluster1", Image: "ubuntu24", Network: "vlan10", CPUs: 2, MemoryMB: 4096, DiskSizeGB: 40,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.ID != prismNewVM || vm.Status != models.StatusRunning {
+		t.Errorf("got VM %s in status %s", vm.ID, vm.Status)
+	}
+	spec := fake.created["spec"].(map[string]interface{})
+	resources := spec["resources"].(map[string]interface{})
+	disk := resources["disk_list"].([]interface{})[0].(map[string]interface{})
+	nic := resources["nic_list"].([]interface{})[0].(map[string]interface{})
+	if spec["cluster_reference"].(map[string]interface{})["uuid"] != prismCluster ||
+		disk["data_source_reference"].(map[string]interface{})["uuid"] != prismImage || disk["disk_size_mib"] != 40960.0 ||
+		nic["subnet_reference"].(map[string]interface{})["uuid"] != prismSubnet || resources["num_sockets"] != 2.0 {
+		t.Errorf("unexpected create request %v", fake.created)
+	}
+	if countRequests(fake, "GET /tasks/"+prismTask) != 1 {
+		t.Error("create task was not waited for")
+	}
+
+	if _, err := p.CreateVM(context.Background(), models.VMSpec{Name: "web02", Region: "cluster1", Image: "ubuntu24;name==other"}); err == nil {
+		t.Error("image name with a FIQL condition resolved")
+	}
+	if got := fake.filters[len(fake.filters)-1]; got != "name==ubuntu24%3Bname%3D%3Dother" {
+		t.Errorf("filter %q is not escaped", got)
+	}
+}
+
+func TestNutanixPowerAndDelete(t *testing.T) {
+	p, fake := newTestNutanix(t)
+	ctx := context.Background()
+	const id = "00000000-0000-0000-0000-000000000002"
+	if err := p.StartVM(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if vm, err := p.GetVM(ctx, id); err != nil || vm.Status != models.StatusRunning {
+		t.Errorf("after start: %v, %v", vm, err)
+	}
+
+	results, err := p.DeleteVM(ctx, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Type != "vm" || results[1].Type != "disk" || results[1].ID != id[:35]+"d" {
+		t.Errorf("unexpected delete results %+v", results)
+	}
+	if _, ok := fake.vms[id]; ok {
+		t.Error("VM was not deleted")
+	}
+
+	before := len(fake.requests)
+	for _, bad := range []string{"../images/" + prismImage, id + "?foo=", "vm1"} {
+		if err := p.StartVM(ctx, bad); err == nil {
+			t.Errorf("ID %q accepted", bad)
+		}
+		if _, err := p.DeleteVM(ctx, bad, false); err == nil {
+			t.Errorf("ID %q accepted for delete", bad)
+		}
+	}
+	if len(fake.requests) != before {
+		t.Errorf("invalid IDs were sent to Prism: %v", fake.requests[before:])
+	}
+}
+
+func countRequests(f *fakePrism, request string) int {
+	n := 0
+	for _, r := range f.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
@@ -1,34 +1,336 @@
 package providers
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
 )
 
 type CloudProvider interface {
-	ListVMs() ([]models.VM, error)
+	ListVMs(ctx context.Context) ([]models.VM, error)
+
+	// GetVM returns a single VM with all details the provider can supply.
+	GetVM(ctx context.Context, id string) (*models.VM, error)
+
+	// Power lifecycle. When force is true the provider skips the guest OS
+	// shutdown/reboot and performs a hard power off/reset instead.
+	StartVM(ctx context.Context, id string) error
+	StopVM(ctx context.Context, id string, force bool) error
+	RestartVM(ctx context.Context, id string, force bool) error
+	SuspendVM(ctx context.Context, id string) error
+
+	// DeleteVM removes the VM. With cascade the attached disks, NICs and public
+	// IPs are removed as well. The returned list covers every resource the
+	// provider tried to remove, including failures; err is only set when the VM
+	// itself could not be deleted.
+	DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error)
+}
+
+// VMCreator is implemented by providers that can create VMs.
+type VMCreator interface {
+	// CreateVM creates a VM and waits until the provider has accepted it. The
+	// returned VM carries at least the ID used by the other endpoints.
+	CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error)
+}
+
+// PowerChecker is implemented by providers that can't perform every power
+// action. CheckPower returns a NotSupportedError if action ("start", "stop",
+// "restart" or "suspend") with force isn't supported, so the request can be
+// refused before an operation is started.
+type PowerChecker interface {
+	CheckPower(action string, force bool) error
 }
 
+// FilteredLister is implemented by providers that can narrow a listing down
+// in the provider API. The result doesn't need to match the filter exactly;
+// the CloudManager applies the whole filter to it again.
+type FilteredLister interface {
+	ListVMsFiltered(ctx context.Context, filter models.VMFilter) ([]models.VM, error)
+}
+
+// CloudManager holds the registered providers by name. A name is either a
+// provider type (e.g. "azure") or the name of an account of that type (e.g.
+// "azure-prod").
 type CloudManager struct {
+	mu        sync.RWMutex
 	providers map[string]CloudProvider
+	types     map[string]string // name -> provider type
+	timeouts  map[string]config.Timeouts
 }
 
-func NewCloudManager() *CloudManager {
+func NewCloudManager(timeouts map[string]config.Timeouts) *CloudManager {
 	return &CloudManager{
 		providers: make(map[string]CloudProvider),
+		types:     make(map[string]string),
+		timeouts:  timeouts,
 	}
 }
 
-func (cm *CloudManager) RegisterProvider(name string, provider CloudProvider) {
-	fmt.Printf("Registering provider %s\r\n", name)
+func (cm *CloudManager) RegisterProvider(name, typ string, provider CloudProvider) {
+	if name == typ {
+		fmt.Printf("Registering provider %s\r\n", name)
+	} else {
+		fmt.Printf("Registering provider %s (%s)\r\n", name, typ)
+	}
+	cm.mu.Lock()
+	previous := cm.providers[name]
 	cm.providers[name] = provider
+	cm.types[name] = typ
+	cm.mu.Unlock()
+	if previous != nil {
+		cm.retire(name, previous)
+	}
+}
+
+func (cm *CloudManager) UnregisterProvider(name string) {
+	fmt.Printf("Unregistering provider %s\r\n", name)
+	cm.mu.Lock()
+	previous := cm.providers[name]
+	delete(cm.providers, name)
+	delete(cm.types, name)
+	cm.mu.Unlock()
+	if previous != nil {
+		cm.retire(name, previous)
+	}
+}
+
+// retireDelay is how long a replaced provider is kept open when calls to it
+// have no timeout.
+var retireDelay = 15 * time.Minute
+
+// retire closes a provider that was replaced or unregistered if it
+// implements io.Closer, e.g. to log out of vCenter. Calls that started with
+// it may still be running, so it is closed once they have timed out.
+func (cm *CloudManager) retire(name string, p CloudProvider) {
+	closer, ok := p.(io.Closer)
+	if !ok {
+		return
+	}
+	t := cm.timeout(name)
+	wait := t.Operation
+	if t.Read > wait {
+		wait = t.Read
+	}
+	if wait <= 0 {
+		wait = retireDelay
+	}
+	time.AfterFunc(wait, func() {
+		if err := closer.Close(); err != nil {
+			fmt.Printf("Closing the previous provider %s failed: %v\r\n", name, err)
+		}
+	})
+}
+
+// ProviderType returns the type of the named provider, or "" if no provider
+// is registered under name.
+func (cm *CloudManager) ProviderType(name string) string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.types[name]
 }
 
 func (cm *CloudManager) GetProvider(name string) CloudProvider {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 	return cm.providers[name]
 }
 
+// GetAllProviders returns a snapshot of the registered providers.
 func (cm *CloudManager) GetAllProviders() map[string]CloudProvider {
-	return cm.providers
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	providers := make(map[string]CloudProvider, len(cm.providers))
+	for name, p := range cm.providers {
+		providers[name] = p
+	}
+	return providers
+}
+
+// providerFactories create each provider from the configuration. The bool
+// is false when the provider isn't configured or could not be initialized.
+var providerFactories = map[string]func(cfg *config.Config) (CloudProvider, bool){
+	"azure":   func(cfg *config.Config) (CloudProvider, bool) { return NewAzureProvider(cfg) },
+	"aws":     func(cfg *config.Config) (CloudProvider, bool) { return NewAWSProvider(cfg) },
+	"gcp":     func(cfg *config.Config) (CloudProvider, bool) { return NewGCPProvider(cfg) },
+	"hyperv":  func(cfg *config.Config) (CloudProvider, bool) { return NewHyperVProvider(cfg) },
+	"nutanix": func(cfg *config.Config) (CloudProvider, bool) { return NewNutanixProvider(cfg) },
+	"proxmox": func(cfg *config.Config) (CloudProvider, bool) { return NewProxmoxVEProvider(cfg) },
+	"vsphere": func(cfg *config.Config) (CloudProvider, bool) { return NewVSphereProvider(cfg) },
+}
+
+// ApplyConfig (re)initializes the providers and accounts whose settings
+// differ between old and cfg, or all of them when old is nil, and takes over
+// the timeouts of cfg. Providers that are no longer configured are
+// unregistered. Calls that are already running keep using the provider they
+// started with.
+func (cm *CloudManager) ApplyConfig(old, cfg *config.Config) {
+	previous := make(map[string]config.Instance)
+	if old != nil {
+		for _, inst := range old.Instances() {
+			previous[inst.Name] = inst
+		}
+	}
+	for _, inst := range cfg.Instances() {
+		prev, existed := previous[inst.Name]
+		delete(previous, inst.Name)
+		if existed && prev.Type == inst.Type &&
+			reflect.DeepEqual(prev.Config.ProviderSettings(prev.Type), inst.Config.ProviderSettings(inst.Type)) {
+			continue
+		}
+		if p, ok := providerFactories[inst.Type](inst.Config); ok {
+			cm.RegisterProvider(inst.Name, inst.Type, p)
+		} else if cm.GetProvider(inst.Name) != nil {
+			cm.UnregisterProvider(inst.Name)
+		}
+	}
+	// Accounts that were removed from the configuration.
+	for name := range previous {
+		if cm.GetProvider(name) != nil {
+			cm.UnregisterProvider(name)
+		}
+	}
+	cm.mu.Lock()
+	cm.timeouts = cfg.Timeouts
+	cm.mu.Unlock()
+}
+
+// CreateVM creates a VM with the named provider, choosing its size from
+// spec.Requirements if given (see applySize). Providers that don't implement
+// VMCreator return a NotSupportedError.
+func (cm *CloudManager) CreateVM(ctx context.Context, name string, spec models.VMSpec) (*models.VM, error) {
+	p := cm.GetProvider(name)
+	creator, ok := p.(VMCreator)
+	if !ok {
+		return nil, notSupported(name, "VM creation")
+	}
+	if err := applySize(ctx, p, &spec); err != nil {
+		return nil, err
+	}
+	vm, err := creator.CreateVM(ctx, spec)
+	if vm != nil {
+		vm.Account = name
+	}
+	return vm, err
+}
+
+// ApplySize chooses the size of spec for the named provider ahead of
+// CreateVM, e.g. so policies can check it (see applySize).
+func (cm *CloudManager) ApplySize(ctx context.Context, name string, spec *models.VMSpec) error {
+	p := cm.GetProvider(name)
+	if p == nil {
+		return fmt.Errorf("provider %s not registered", name)
+	}
+	return applySize(ctx, p, spec)
+}
+
+// GetVM returns a VM of the named provider.
+func (cm *CloudManager) GetVM(ctx context.Context, name, id string) (*models.VM, error) {
+	p := cm.GetProvider(name)
+	if p == nil {
+		return nil, fmt.Errorf("provider %s is not registered", name)
+	}
+	vm, err := p.GetVM(ctx, id)
+	if vm != nil {
+		vm.Account = name
+	}
+	return vm, err
+}
+
+// ListAllVMs queries the given providers concurrently, or every registered
+// provider when names is empty, and returns the VMs matching filter. Failing
+// providers don't fail the call; their errors are reported in the per-provider
+// results, which are sorted by name.
+func (cm *CloudManager) ListAllVMs(ctx context.Context, filter models.VMFilter, names ...string) ([]models.VM, []models.ProviderResult) {
+	providers := cm.GetAllProviders()
+	if len(names) == 0 {
+		for name := range providers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	vmsByProvider := make([][]models.VM, len(names))
+	results := make([]models.ProviderResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			result := models.ProviderResult{Provider: name, Type: cm.ProviderType(name)}
+			start := time.Now()
+
+			p := providers[name]
+			if p == nil {
+				result.Error = "provider not registered"
+				results[i] = result
+				return
+			}
+			pctx, cancel := cm.ReadContext(ctx, name)
+			defer cancel()
+			var vms []models.VM
+			var err error
+			if fl, ok := p.(FilteredLister); ok && !filter.IsZero() {
+				vms, err = fl.ListVMsFiltered(pctx, filter)
+			} else {
+				vms, err = p.ListVMs(pctx)
+			}
+
+			result.LatencyMs = time.Since(start).Milliseconds()
+			if err != nil {
+				fmt.Printf("Listing VMs from provider %s failed: %v\r\n", name, err)
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+				var matched []models.VM
+				for _, vm := range vms {
+					if filter.Match(vm) {
+						vm.Account = name
+						matched = append(matched, vm)
+					}
+				}
+				result.VMCount = len(matched)
+				vmsByProvider[i] = matched
+			}
+			results[i] = result
+		}(i, name)
+	}
+	wg.Wait()
+
+	var vms []models.VM
+	for _, list := range vmsByProvider {
+		vms = append(vms, list...)
+	}
+	return vms, results
+}
+
+// ReadContext derives a context for a list or get call to the named provider,
+// bounded by the provider's read timeout.
+func (cm *CloudManager) ReadContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, cm.timeout(name).Read)
+}
+
+// OperationContext derives a context for a create, delete or power call to the
+// named provider, bounded by the provider's operation timeout.
+func (cm *CloudManager) OperationContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, cm.timeout(name).Operation)
+}
+
+func (cm *CloudManager) timeout(name string) config.Timeouts {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.timeouts[name]
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fuddata/anyvm/config"
+)
+
+// loadConfig loads a config file with the given content.
+func loadConfig(t *testing.T, content string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "anyvm.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// Hyper-V providers are used because creating one doesn't contact the host.
+func TestApplyConfigAccounts(t *testing.T) {
+	cm := NewCloudManager(nil)
+	old := loadConfig(t, `
+accounts:
+  - {name: hv-a, type: hyperv, hyperv: {host: hv-a, username: admin, password: secret}}
+  - {name: hv-b, type: hyperv, hyperv: {host: hv-b, username: admin, password: secret}}
+`)
+	cm.ApplyConfig(nil, old)
+	if cm.ProviderType("hv-a") != "hyperv" || cm.ProviderType("hv-b") != "hyperv" || cm.GetProvider("hyperv") != nil {
+		t.Fatalf("unexpected providers %v", cm.GetAllProviders())
+	}
+	hvB := cm.GetProvider("hv-b")
+
+	cfg := loadConfig(t, `
+accounts:
+  - {name: hv-b, type: hyperv, hyperv: {host: hv-b, username: admin, password: secret}}
+  - {name: hv-c, type: hyperv, hyperv: {host: hv-c, username: admin, password: secret}, timeouts: {read: 5s}}
+`)
+	cm.ApplyConfig(old, cfg)
+	if cm.GetProvider("hv-a") != nil {
+		t.Error("removed account hv-a is still registered")
+	}
+	if cm.GetProvider("hv-b") != hvB {
+		t.Error("unchanged account hv-b was re-initialized")
+	}
+	if p, ok := cm.GetProvider("hv-c").(*HyperVProvider); !ok || p.host != "hv-c" {
+		t.Errorf("account hv-c not registered, got %v", cm.GetProvider("hv-c"))
+	}
+	if d := cm.timeout("hv-c").Read; d.Seconds() != 5 {
+		t.Errorf("hv-c read timeout = %v, want 5s", d)
+	}
+}
+
+// closerProvider reports when it is closed.
+type closerProvider struct {
+	CloudProvider
+	closed chan struct{}
+}
+
+func (p *closerProvider) Close() error {
+	close(p.closed)
+	return nil
+}
+
+func TestRetireProvider(t *testing.T) {
+	defer func(d time.Duration) { retireDelay = d }(retireDelay)
+	retireDelay = time.Millisecond
+	cm := NewCloudManager(nil)
+	first := &closerProvider{closed: make(chan struct{})}
+	second := &closerProvider{closed: make(chan struct{})}
+	cm.RegisterProvider("vsphere", "vsphere", first)
+
+	cm.RegisterProvider("vsphere", "vsphere", second)
+	select {
+	case <-first.closed:
+	case <-time.After(time.Second):
+		t.Error("replaced provider was not closed")
+	}
+	cm.UnregisterProvider("vsphere")
+	select {
+	case <-second.closed:
+	case <-time.After(time.Second):
+		t.Error("unregistered provider was not closed")
+	}
+}
@@ -3,39 +3,47 @@ package providers
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 )
 
 type ProxmoxVEProvider struct {
-	client *proxmox.Client
-	node   string
+	client     *proxmox.Client
+	session    *proxmox.Session // for calls whose task (UPID) AnyVM tracks itself
+	httpClient *http.Client
+	node       string
+	images     map[string]string // image names -> template VMID, name or name pattern
 }
 
-func NewProxmoxVEProvider(cfg interface{}) (*ProxmoxVEProvider, bool) {
-	apiURL := os.Getenv("PROXMOX_API_URL")
-	username := os.Getenv("PROXMOX_USERNAME")
-	password := os.Getenv("PROXMOX_PASSWORD")
-	node := os.Getenv("PROXMOX_NODE") // This must be specified
-
-	if apiURL == "" || username == "" || password == "" || node == "" {
+func NewProxmoxVEProvider(cfg *config.Config) (*ProxmoxVEProvider, bool) {
+	creds := cfg.ProxmoxCreds
+	apiURL, username, password := creds.APIURL, creds.Username, creds.Password
+	if apiURL == "" || username == "" || password == "" || creds.Node == "" {
 		fmt.Printf("ProxmoxVE credentials not configured. Will continue without it.\r\n")
 		return nil, false
 	}
 
-	// Create an HTTP client for use by the Proxmox client.
-	httpClient := &http.Client{}
+	// Create an HTTP client for use by the Proxmox client. It has its own
+	// transport so Close can drop its connections.
+	httpClient := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
 
 	// For Telmate's NewClient, we need: (apiURL, *http.Client, realm, *tls.Config, ticket, port)
 	// Use an empty realm and ticket. Typical port for Proxmox is 8006.
 	client, err := proxmox.NewClient(apiURL, httpClient, "", nil, "", 30)
 	if err != nil {
-		panic(err)
-
+		fmt.Printf("Failed to active ProxmoxVE provider. Will continue without it. Error: %v\r\n", err)
+		return nil, false
 	}
 
 	// Login using a context. The fourth parameter (OTP) is empty.
@@ -45,15 +53,39 @@ func NewProxmoxVEProvider(cfg interface{}) (*ProxmoxVEProvider, bool) {
 		return nil, false
 	}
 
+	// The client's own task handling gives up after TaskTimeout and can't be
+	// cancelled, so tasks are started and tracked through a separate session.
+	session, err := proxmox.NewSession(apiURL, httpClient, "", nil)
+	if err != nil {
+		fmt.Printf("Failed to active ProxmoxVE provider. Will continue without it. Error: %v\r\n", err)
+		return nil, false
+	}
+	if err := session.Login(context.Background(), username, password, ""); err != nil {
+		fmt.Printf("ProxmoxVE login failed. Will continue without it. Error: %v\r\n", err)
+		return nil, false
+	}
+
 	return &ProxmoxVEProvider{
-		client: client,
-		node:   node,
+		client:     client,
+		session:    session,
+		httpClient: httpClient,
+		node:       creds.Node,
+		images:     cfg.ImageAliases("proxmox"),
 	}, true
 }
 
-func (p *ProxmoxVEProvider) ListVMs() ([]models.VM, error) {
+// Close drops the connections of the provider. Proxmox tickets can't be
+// revoked; they expire after two hours.
+func (p *ProxmoxVEProvider) Close() error {
+	if p.httpClient != nil {
+		p.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (p *ProxmoxVEProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
 	// Use ListGuests to get the list of VMs for the specified node.
-	guests, err := proxmox.ListGuests(context.Background(), p.client)
+	guests, err := proxmox.ListGuests(ctx, p.client)
 	if err != nil {
 		return nil, err
 	}
@@ -61,13 +93,592 @@ func (p *ProxmoxVEProvider) ListVMs() ([]models.VM, error) {
 	var vms []models.VM
 	for _, guest := range guests {
 		vmID := strconv.FormatUint(uint64(guest.Id), 10)
-		vms = append(vms, models.VM{
-			ID:       vmID,
-			Name:     guest.Name,
-			Provider: "proxmoxve",
-			Region:   p.node,
-			Status:   guest.Status,
-		})
+		vm := models.VM{
+			ID:        vmID,
+			Name:      guest.Name,
+			Provider:  "proxmox",
+			Region:    guest.Node,
+			Status:    proxmoxStatus(guest.Status),
+			RawStatus: guest.Status,
+			CPUs:      int(guest.CpuCores),
+			MemoryMB:  int64(guest.MemoryTotalInBytes / (1 << 20)),
+		}
+		if len(guest.Tags) > 0 {
+			vm.Tags = make(map[string]string, len(guest.Tags))
+			for _, tag := range guest.Tags {
+				vm.Tags[string(tag)] = ""
+			}
+		}
+		vms = append(vms, vm)
 	}
 	return vms, nil
 }
+
+// vmRef resolves a VMID to a reference that includes the node hosting the guest.
+func (p *ProxmoxVEProvider) vmRef(ctx context.Context, id string) (*proxmox.VmRef, error) {
+	vmid, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Proxmox VMID %q", id)
+	}
+	return p.client.GetVmRefById(ctx, proxmox.GuestID(vmid))
+}
+
+// statusTask runs a power action (start, stop, shutdown, reset, reboot,
+// suspend, ...) on the guest and waits for its task.
+func (p *ProxmoxVEProvider) statusTask(ctx context.Context, vmr *proxmox.VmRef, action string) error {
+	url := fmt.Sprintf("/nodes/%s/%s/%d/status/%s", vmr.Node(), vmr.GetVmType(), vmr.VmId(), action)
+	_, err := p.runTask(ctx, http.MethodPost, url, nil)
+	return err
+}
+
+// runTask sends a request that starts a Proxmox task and waits for the task
+// to finish. It returns the task's UPID.
+func (p *ProxmoxVEProvider) runTask(ctx context.Context, method, url string, params map[string]interface{}) (string, error) {
+	var resp *http.Response
+	var err error
+	if method == http.MethodDelete {
+		resp, err = p.session.Delete(ctx, url, nil, nil)
+	} else {
+		body := proxmox.ParamsToBody(params)
+		resp, err = p.session.Request(ctx, method, url, nil, &http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, &body)
+	}
+	if err != nil {
+		return "", proxmoxError(resp, err)
+	}
+	data, err := proxmox.ResponseJSON(resp)
+	if err != nil {
+		return "", err
+	}
+	upid, _ := data["data"].(string)
+	if upid == "" {
+		return "", nil // the call completed synchronously
+	}
+	return upid, p.waitTask(ctx, upid)
+}
+
+// waitTask polls a task until it has stopped, reporting its status to the
+// operation running with ctx. When ctx is cancelled the task is stopped too.
+func (p *ProxmoxVEProvider) waitTask(ctx context.Context, upid string) error {
+	// UPID:<node>:<pid>:<pstart>:<starttime>:<type>:<id>:<user>:
+	parts := strings.Split(upid, ":")
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid Proxmox UPID %q", upid)
+	}
+	taskURL := fmt.Sprintf("/nodes/%s/tasks/%s", parts[1], upid)
+	for {
+		var status struct {
+			Data struct {
+				Status     string `json:"status"`
+				ExitStatus string `json:"exitstatus"`
+			} `json:"data"`
+		}
+		if resp, err := p.session.GetJSON(ctx, taskURL+"/status", nil, nil, &status); err != nil {
+			return proxmoxError(resp, err)
+		}
+		if status.Data.Status == "stopped" {
+			if status.Data.ExitStatus == "OK" || strings.HasPrefix(status.Data.ExitStatus, "WARNINGS") {
+				return nil
+			}
+			return fmt.Errorf("proxmox task %s failed: %s", upid, status.Data.ExitStatus)
+		}
+		operations.Progress(ctx, 0, "proxmox task %s is %s", upid, status.Data.Status)
+		select {
+		case <-ctx.Done():
+			p.session.Delete(context.Background(), taskURL, nil, nil)
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// proxmoxError adds the error details from the response body, if any.
+func proxmoxError(resp *http.Response, err error) error {
+	if resp == nil || resp.Body == nil {
+		return err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+func (p *ProxmoxVEProvider) StartVM(ctx context.Context, id string) error {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return err
+	}
+	return p.statusTask(ctx, vmr, "start")
+}
+
+func (p *ProxmoxVEProvider) StopVM(ctx context.Context, id string, force bool) error {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return err
+	}
+	if force {
+		return p.statusTask(ctx, vmr, "stop")
+	}
+	return p.statusTask(ctx, vmr, "shutdown")
+}
+
+func (p *ProxmoxVEProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return err
+	}
+	if force {
+		return p.statusTask(ctx, vmr, "reset")
+	}
+	return p.statusTask(ctx, vmr, "reboot")
+}
+
+func (p *ProxmoxVEProvider) SuspendVM(ctx context.Context, id string) error {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return err
+	}
+	return p.statusTask(ctx, vmr, "suspend")
+}
+
+var proxmoxDiskKey = regexp.MustCompile(`^(ide|sata|scsi|virtio|efidisk|tpmstate)\d+$`)
+
+// DeleteVM stops the guest if needed and destroys it. Proxmox always removes
+// disks owned by the VM; with cascade it also purges the VMID from backup,
+// replication and HA configuration and destroys unreferenced disks.
+func (p *ProxmoxVEProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []string
+	if config, err := p.client.GetVmConfig(ctx, vmr); err == nil {
+		for key, value := range config {
+			str, ok := value.(string)
+			if !ok || !proxmoxDiskKey.MatchString(key) || strings.Contains(str, "media=cdrom") {
+				continue
+			}
+			disks = append(disks, strings.SplitN(str, ",", 2)[0])
+		}
+	}
+
+	if state, err := p.client.GetVmState(ctx, vmr); err == nil && state["status"] != "stopped" {
+		if err := p.statusTask(ctx, vmr, "stop"); err != nil {
+			return nil, fmt.Errorf("failed to stop VM before deletion: %w", err)
+		}
+	}
+
+	url := fmt.Sprintf("/nodes/%s/%s/%d", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	if cascade {
+		url += "?purge=1&destroy-unreferenced-disks=1"
+	}
+	if _, err := p.runTask(ctx, http.MethodDelete, url, nil); err != nil {
+		return []models.DeletedResource{deletedResource("vm", id, err)}, err
+	}
+
+	results := []models.DeletedResource{deletedResource("vm", id, nil)}
+	for _, disk := range disks {
+		results = append(results, deletedResource("disk", disk, nil))
+	}
+	return results, nil
+}
+
+// Images returns the image names and their templates.
+func (p *ProxmoxVEProvider) Images() map[string]string {
+	return p.images
+}
+
+// ResolveImage returns the template of an image name, template VMID or
+// template name. A name pattern such as "ubuntu24-*" resolves to the VMID of
+// the matching template with the highest VMID, i.e. the newest one.
+func (p *ProxmoxVEProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	ref := imageAlias(p.images, image)
+	if !strings.ContainsAny(ref, "*?[") {
+		return ref, nil
+	}
+	if _, err := path.Match(ref, ""); err != nil {
+		return "", fmt.Errorf("invalid proxmox template pattern %q", ref)
+	}
+	guests, err := p.client.GetResourceList(ctx, "vm")
+	if err != nil {
+		return "", err
+	}
+	var newest float64
+	for _, g := range guests {
+		guest, _ := g.(map[string]interface{})
+		name, _ := guest["name"].(string)
+		vmid, _ := guest["vmid"].(float64)
+		template, _ := guest["template"].(float64)
+		if matched, _ := path.Match(ref, name); matched && template == 1 && vmid > newest {
+			newest = vmid
+		}
+	}
+	if newest == 0 {
+		return "", fmt.Errorf("no proxmox template matches %q", ref)
+	}
+	return strconv.FormatFloat(newest, 'f', -1, 64), nil
+}
+
+// templateRef resolves the template given by image name, VMID, name or name
+// pattern.
+func (p *ProxmoxVEProvider) templateRef(ctx context.Context, image string) (*proxmox.VmRef, error) {
+	image, err := p.ResolveImage(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := strconv.ParseUint(image, 10, 32); err == nil {
+		return p.vmRef(ctx, image)
+	}
+	vmrs, err := p.client.GetVmRefsByName(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	switch len(vmrs) {
+	case 0:
+		return nil, fmt.Errorf("proxmox template %q not found", image)
+	case 1:
+		return vmrs[0], nil
+	}
+	return nil, fmt.Errorf("proxmox template name %q is ambiguous", image)
+}
+
+// CreateVM clones the template given by spec.Image (see templateRef) onto the
+// node spec.Region (by default the template's node), applies the hardware and
+// cloud-init settings and starts the VM. The clone is a full clone onto
+// spec.Storage unless spec.LinkedClone is set; cloud-init settings require a
+// cloud-init drive on the template.
+func (p *ProxmoxVEProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	if spec.Image == "" {
+		return nil, fmt.Errorf("Proxmox VM creation requires a template VMID in image")
+	}
+	if spec.LinkedClone && spec.Storage != "" {
+		return nil, fmt.Errorf("Proxmox linked clones are always placed on the template's storage")
+	}
+	template, err := p.templateRef(ctx, spec.Image)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := p.client.GetNextID(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	node := template.Node().String()
+	if spec.Region != "" {
+		node = spec.Region
+	}
+
+	clone := map[string]interface{}{
+		"newid":   int(newID),
+		"name":    spec.Name,
+		"full":    !spec.LinkedClone,
+		"storage": spec.Storage,
+	}
+	if node != template.Node().String() {
+		clone["target"] = node
+	}
+	operations.Progress(ctx, 0, "cloning template %d to VM %d", template.VmId(), newID)
+	cloneURL := fmt.Sprintf("/nodes/%s/%s/%d/clone", template.Node(), template.GetVmType(), template.VmId())
+	if _, err := p.runTask(ctx, http.MethodPost, cloneURL, clone); err != nil {
+		return nil, err
+	}
+
+	vmr := proxmox.NewVmRef(newID)
+	vmr.SetNode(node)
+	vmr.SetVmType(template.GetVmType())
+
+	if err := p.setupClone(ctx, vmr, spec); err != nil {
+		p.removeClone(ctx, vmr)
+		return nil, err
+	}
+	return p.GetVM(ctx, strconv.Itoa(int(newID)))
+}
+
+// setupClone configures, resizes and starts a VM cloned for spec.
+func (p *ProxmoxVEProvider) setupClone(ctx context.Context, vmr *proxmox.VmRef, spec models.VMSpec) error {
+	vmURL := fmt.Sprintf("/nodes/%s/%s/%d", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+
+	config := map[string]interface{}{
+		"ciuser":     spec.AdminUsername,
+		"cipassword": spec.AdminPassword,
+		"ipconfig0":  spec.IPConfig,
+	}
+	if spec.CPUs > 0 {
+		config["cores"] = spec.CPUs
+		config["sockets"] = 1
+	}
+	if spec.MemoryMB > 0 {
+		config["memory"] = spec.MemoryMB
+	}
+	if len(spec.SSHKeys) > 0 {
+		config["sshkeys"] = proxmoxSSHKeys(spec.SSHKeys)
+	}
+	if len(spec.Tags) > 0 {
+		config["tags"] = proxmoxTagList(spec.Tags)
+	}
+	if len(proxmox.ParamsToValues(config)) > 0 {
+		operations.Progress(ctx, 0, "configuring VM %d", vmr.VmId())
+		if _, err := p.runTask(ctx, http.MethodPost, vmURL+"/config", config); err != nil {
+			return fmt.Errorf("failed to configure VM %d: %w", vmr.VmId(), err)
+		}
+	}
+
+	if spec.DiskSizeGB > 0 {
+		current, err := p.client.GetVmConfig(ctx, vmr)
+		if err != nil {
+			return err
+		}
+		disk, size := proxmoxBootDisk(current)
+		if disk == "" {
+			return fmt.Errorf("VM %d has no boot disk to resize", vmr.VmId())
+		}
+		// Disks can only grow; keep the template's size when it is large enough.
+		if spec.DiskSizeGB > size {
+			resize := map[string]interface{}{"disk": disk, "size": fmt.Sprintf("%dG", spec.DiskSizeGB)}
+			if _, err := p.runTask(ctx, http.MethodPut, vmURL+"/resize", resize); err != nil {
+				return fmt.Errorf("failed to resize disk %s of VM %d: %w", disk, vmr.VmId(), err)
+			}
+		}
+	}
+
+	if err := p.statusTask(ctx, vmr, "start"); err != nil {
+		return fmt.Errorf("failed to start VM %d: %w", vmr.VmId(), err)
+	}
+	return nil
+}
+
+// removeClone destroys a clone whose setup failed, so it doesn't keep its
+// VMID and disks. It goes on when ctx is cancelled.
+func (p *ProxmoxVEProvider) removeClone(ctx context.Context, vmr *proxmox.VmRef) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
+	defer cancel()
+	operations.Progress(ctx, 0, "removing VM %d", vmr.VmId())
+	if state, err := p.client.GetVmState(ctx, vmr); err == nil && state["status"] != "stopped" {
+		p.statusTask(ctx, vmr, "stop")
+	}
+	url := fmt.Sprintf("/nodes/%s/%s/%d?purge=1&destroy-unreferenced-disks=1", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	if _, err := p.runTask(ctx, http.MethodDelete, url, nil); err != nil {
+		fmt.Printf("Removing Proxmox VM %d after its setup failed did not work: %v\r\n", vmr.VmId(), err)
+	}
+}
+
+// UpdateTags adds the tags in set and removes the ones in remove. Proxmox
+// tags are plain names, so values have to be empty.
+func (p *ProxmoxVEProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return err
+	}
+	config, err := p.client.GetVmConfig(ctx, vmr)
+	if err != nil {
+		return err
+	}
+	tags := mergeTags(proxmoxTags(config), set, remove)
+	if err := ValidateTags("proxmox", tags); err != nil {
+		return err
+	}
+	params := map[string]interface{}{"tags": proxmoxTagList(tags)}
+	if len(tags) == 0 {
+		params = map[string]interface{}{"delete": "tags"}
+	}
+	vmURL := fmt.Sprintf("/nodes/%s/%s/%d", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	_, err = p.runTask(ctx, http.MethodPost, vmURL+"/config", params)
+	return err
+}
+
+// proxmoxTags returns the tags of a guest configuration as keys without values.
+func proxmoxTags(config map[string]interface{}) map[string]string {
+	list, ok := config["tags"].(string)
+	if !ok || list == "" {
+		return nil
+	}
+	tags := make(map[string]string)
+	for _, tag := range strings.FieldsFunc(list, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+		tags[tag] = ""
+	}
+	return tags
+}
+
+// proxmoxTagList encodes the keys of tags for the tags option.
+func proxmoxTagList(tags map[string]string) string {
+	return strings.Join(sortedKeys(tags), ";")
+}
+
+// proxmoxSSHKeys encodes public keys for the sshkeys option, which Proxmox
+// expects URL-encoded (with %20 for spaces) on top of the form encoding.
+func proxmoxSSHKeys(keys []string) string {
+	return strings.ReplaceAll(url.QueryEscape(strings.Join(keys, "\n")+"\n"), "+", "%20")
+}
+
+// proxmoxBootDisk returns the config key and size in GB of the first disk in
+// the boot order, or of the first disk when the config has no boot order.
+func proxmoxBootDisk(config map[string]interface{}) (string, int64) {
+	var keys []string
+	boot := fmt.Sprint(config["boot"])
+	if order, found := strings.CutPrefix(boot, "order="); found {
+		keys = strings.Split(order, ";")
+	} else if bootdisk, ok := config["bootdisk"].(string); ok {
+		keys = []string{bootdisk}
+	}
+	for _, prefix := range []string{"scsi", "virtio", "sata", "ide"} {
+		for i := 0; i < 31; i++ {
+			keys = append(keys, fmt.Sprintf("%s%d", prefix, i))
+		}
+	}
+	for _, key := range keys {
+		str, ok := config[key].(string)
+		if !ok || !proxmoxDiskKey.MatchString(key) || strings.Contains(str, "media=cdrom") {
+			continue
+		}
+		for _, opt := range strings.Split(str, ",") {
+			if size, found := strings.CutPrefix(opt, "size="); found {
+				return key, parseProxmoxSize(size)
+			}
+		}
+		return key, 0
+	}
+	return "", 0
+}
+
+// GetVM reads the guest configuration and, when the QEMU guest agent is
+// running, its IP addresses.
+func (p *ProxmoxVEProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	vmr, err := p.vmRef(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	config, err := p.client.GetVmConfig(ctx, vmr)
+	if err != nil {
+		return nil, err
+	}
+	state, err := p.client.GetVmState(ctx, vmr)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := &models.VM{
+		ID:        id,
+		Name:      fmt.Sprint(config["name"]),
+		Provider:  "proxmox",
+		Region:    vmr.Node().String(),
+		RawStatus: fmt.Sprint(state["status"]),
+		MemoryMB:  proxmoxInt(config["memory"]),
+		OSType:    proxmoxOSType(fmt.Sprint(config["ostype"])),
+	}
+	// qmpstatus distinguishes paused/suspended guests, which status reports as running.
+	if qmp, ok := state["qmpstatus"].(string); ok && qmp != "" {
+		vm.RawStatus = qmp
+	}
+	vm.Status = proxmoxStatus(vm.RawStatus)
+
+	cores, sockets := proxmoxInt(config["cores"]), proxmoxInt(config["sockets"])
+	if cores == 0 {
+		cores = 1
+	}
+	if sockets == 0 {
+		sockets = 1
+	}
+	vm.CPUs = int(cores * sockets)
+
+	for key, value := range config {
+		str, ok := value.(string)
+		if !ok || !proxmoxDiskKey.MatchString(key) || strings.Contains(str, "media=cdrom") {
+			continue
+		}
+		disk := models.Disk{ID: strings.SplitN(str, ",", 2)[0], Name: key}
+		for _, opt := range strings.Split(str, ",") {
+			if size, found := strings.CutPrefix(opt, "size="); found {
+				disk.SizeGB = parseProxmoxSize(size)
+			}
+		}
+		disk.Boot = strings.Contains(fmt.Sprint(config["boot"]), key)
+		vm.Disks = append(vm.Disks, disk)
+	}
+
+	vm.Tags = proxmoxTags(config)
+	if meta, ok := config["meta"].(string); ok {
+		for _, opt := range strings.Split(meta, ",") {
+			if ctime, found := strings.CutPrefix(opt, "ctime="); found {
+				if secs, err := strconv.ParseInt(ctime, 10, 64); err == nil {
+					t := time.Unix(secs, 0).UTC()
+					vm.CreatedAt = &t
+				}
+			}
+		}
+	}
+
+	if vm.Status == models.StatusRunning {
+		if ifaces, err := p.client.GetVmAgentNetworkInterfaces(ctx, vmr); err == nil {
+			for _, iface := range ifaces {
+				for _, ip := range iface.IpAddresses {
+					if !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+						vm.PrivateIPs = append(vm.PrivateIPs, ip.String())
+					}
+				}
+			}
+		}
+	}
+	return vm, nil
+}
+
+// proxmoxInt converts a numeric config value, which the API may return as a
+// number or a string, to an int64.
+func proxmoxInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
+
+// parseProxmoxSize converts a disk size such as "32G" or "512M" to whole GB.
+func parseProxmoxSize(size string) int64 {
+	if size == "" {
+		return 0
+	}
+	unit := size[len(size)-1]
+	n, err := strconv.ParseFloat(strings.TrimRight(size, "KMGTkmgt"), 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case 'T', 't':
+		n *= 1024
+	case 'M', 'm':
+		n /= 1024
+	case 'K', 'k':
+		n /= 1024 * 1024
+	}
+	return int64(n)
+}
+
+// proxmoxOSType maps the ostype config value (e.g. "l26", "win11") to linux/windows.
+func proxmoxOSType(ostype string) string {
+	switch {
+	case strings.HasPrefix(ostype, "w"):
+		return "windows"
+	case strings.HasPrefix(ostype, "l"):
+		return "linux"
+	}
+	return ""
+}
+
+// proxmoxStatus maps a guest status or QMP status to the normalized status.
+func proxmoxStatus(status string) models.VMStatus {
+	switch status {
+	case "running":
+		return models.StatusRunning
+	case "stopped":
+		return models.StatusStopped
+	case "paused", "suspended":
+		return models.StatusSuspended
+	case "prelaunch":
+		return models.StatusStarting
+	case "shutdown":
+		return models.StatusStopping
+	}
+	return models.StatusUnknown
+}
@@ -0,0 +1,159 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/fuddata/anyvm/models"
+)
+
+// fakeProxmox serves the parts of the Proxmox VE API used by CreateVM for a
+// cluster with the templates 9000-9002 on node pve1. Every task finishes at once.
+type fakeProxmox struct {
+	mu         sync.Mutex
+	requests   map[string]url.Values // form of each POST/PUT/DELETE by "METHOD path"
+	failResize bool
+}
+
+func (f *fakeProxmox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := func(data interface{}) { json.NewEncoder(w).Encode(map[string]interface{}{"data": data}) }
+	const upid = "UPID:pve1:0000A1B2:00C3D4E5:67890ABC:qmclone:9000:root@pam:"
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		r.ParseForm()
+		f.requests[r.Method+" "+r.URL.Path] = r.PostForm
+	case http.MethodDelete:
+		f.requests[r.Method+" "+r.URL.Path] = r.URL.Query()
+	}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /cluster/resources":
+		reply([]interface{}{
+			map[string]interface{}{"vmid": 9000.0, "name": "ubuntu24-tmpl", "node": "pve1", "type": "qemu", "template": 1.0},
+			map[string]interface{}{"vmid": 101.0, "name": "web01", "node": "pve1", "type": "qemu"},
+			map[string]interface{}{"vmid": 9001.0, "name": "ubuntu24-20250301", "node": "pve1", "type": "qemu", "template": 1.0},
+			map[string]interface{}{"vmid": 9002.0, "name": "ubuntu24-20250601", "node": "pve1", "type": "qemu", "template": 1.0},
+			map[string]interface{}{"vmid": 9100.0, "name": "ubuntu24-scratch", "node": "pve2", "type": "qemu"},
+		})
+	case "GET /cluster/nextid":
+		reply("101")
+	case "PUT /nodes/pve1/qemu/101/resize":
+		if f.failResize {
+			http.Error(w, "storage full", http.StatusInternalServerError)
+			return
+		}
+		reply(upid)
+	case "POST /nodes/pve1/qemu/9000/clone", "POST /nodes/pve1/qemu/101/config",
+		"POST /nodes/pve1/qemu/101/status/start", "POST /nodes/pve1/qemu/101/status/stop", "DELETE /nodes/pve1/qemu/101":
+		reply(upid)
+	case "GET /nodes/pve1/tasks/" + upid + "/status":
+		reply(map[string]interface{}{"status": "stopped", "exitstatus": "OK"})
+	case "GET /nodes/pve1/qemu/101/config":
+		reply(map[string]interface{}{
+			"name": "web01", "cores": 2.0, "memory": "4096", "boot": "order=scsi0;ide2;net0",
+			"scsi0": "local-lvm:vm-101-disk-0,size=8G", "ide2": "local-lvm:vm-101-cloudinit,media=cdrom",
+		})
+	case "GET /nodes/pve1/qemu/101/status/current":
+		reply(map[string]interface{}{"status": "running", "qmpstatus": "running"})
+	case "GET /nodes/pve1/qemu/101/agent/network-get-interfaces":
+		reply(map[string]interface{}{"result": []interface{}{}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestProxmox(t *testing.T) (*ProxmoxVEProvider, *fakeProxmox) {
+	fake := &fakeProxmox{requests: make(map[string]url.Values)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := proxmox.NewClient(server.URL, server.Client(), "", nil, "", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := proxmox.NewSession(server.URL, server.Client(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ProxmoxVEProvider{client: client, session: session, node: "pve1"}, fake
+}
+
+func TestProxmoxCreateVM(t *testing.T) {
+	p, fake := newTestProxmox(t)
+
+	vm, err := p.CreateVM(context.Background(), models.VMSpec{
+		Name:          "web01",
+		Image:         "ubuntu24-tmpl",
+		CPUs:          2,
+		MemoryMB:      4096,
+		DiskSizeGB:    32,
+		AdminUsername: "ubuntu",
+		SSHKeys:       []string{"ssh-ed25519 AAAAC3Nz+/= me@host"},
+		IPConfig:      "ip=10.0.0.5/24,gw=10.0.0.1",
+		LinkedClone:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.ID != "101" || vm.Status != models.StatusRunning {
+		t.Errorf("got VM %s in status %s, want 101 running", vm.ID, vm.Status)
+	}
+
+	clone := fake.requests["POST /nodes/pve1/qemu/9000/clone"]
+	if clone.Get("newid") != "101" || clone.Get("name") != "web01" || clone.Get("full") != "0" || clone.Has("target") {
+		t.Errorf("unexpected clone request %v", clone)
+	}
+	config := fake.requests["POST /nodes/pve1/qemu/101/config"]
+	if config.Get("cores") != "2" || config.Get("memory") != "4096" || config.Get("ciuser") != "ubuntu" ||
+		config.Get("ipconfig0") != "ip=10.0.0.5/24,gw=10.0.0.1" || config.Has("cipassword") {
+		t.Errorf("unexpected config request %v", config)
+	}
+	if keys, _ := url.QueryUnescape(config.Get("sshkeys")); keys != "ssh-ed25519 AAAAC3Nz+/= me@host\n" || strings.Contains(config.Get("sshkeys"), "+") {
+		t.Errorf("sshkeys = %q, want URL-encoded key", config.Get("sshkeys"))
+	}
+	if resize := fake.requests["PUT /nodes/pve1/qemu/101/resize"]; resize.Get("disk") != "scsi0" || resize.Get("size") != "32G" {
+		t.Errorf("unexpected resize request %v", resize)
+	}
+	if _, ok := fake.requests["POST /nodes/pve1/qemu/101/status/start"]; !ok {
+		t.Error("VM was not started")
+	}
+}
+
+func TestProxmoxCreateVMCleanup(t *testing.T) {
+	p, fake := newTestProxmox(t)
+	fake.failResize = true
+	_, err := p.CreateVM(context.Background(), models.VMSpec{Name: "web01", Image: "9000", DiskSizeGB: 32})
+	if err == nil || !strings.Contains(err.Error(), "storage full") {
+		t.Fatalf("got error %v, want the resize error", err)
+	}
+	if del, ok := fake.requests["DELETE /nodes/pve1/qemu/101"]; !ok || del.Get("purge") != "1" || del.Get("destroy-unreferenced-disks") != "1" {
+		t.Errorf("clone of the failed VM was not destroyed: %v", del)
+	}
+	if _, ok := fake.requests["POST /nodes/pve1/qemu/101/status/start"]; ok {
+		t.Error("VM was started although its setup failed")
+	}
+}
+
+func TestProxmoxListVMs(t *testing.T) {
+	p, _ := newTestProxmox(t)
+	vms, err := p.ListVMs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := make(map[string]string)
+	for _, vm := range vms {
+		nodes[vm.ID] = vm.Region
+	}
+	if nodes["101"] != "pve1" || nodes["9100"] != "pve2" {
+		t.Errorf("VMs are on nodes %v, want 101 on pve1 and 9100 on pve2", nodes)
+	}
+}
@@ -0,0 +1,247 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
+)
+
+// SizeLister is implemented by providers with fixed VM sizes. The other
+// providers take any number of cores and amount of memory.
+type SizeLister interface {
+	// ListSizes returns the sizes offered in region, by default in the region
+	// new VMs are created in.
+	ListSizes(ctx context.Context, region string) ([]models.Size, error)
+}
+
+// flexibleSizes are the sizes of providers without fixed sizes. Their names
+// can be given as size when creating a VM, like the size aliases of the clouds.
+var flexibleSizes = []models.Size{
+	{Name: "small", CPUs: 1, MemoryMB: 2048},
+	{Name: "medium", CPUs: 2, MemoryMB: 4096},
+	{Name: "large", CPUs: 4, MemoryMB: 8192},
+}
+
+// SelectSize returns the smallest of sizes that meets req: the one with the
+// fewest vCPUs, then the least memory, then the lowest known price.
+func SelectSize(sizes []models.Size, req models.SizeRequirements) (models.Size, error) {
+	matching := matchingSizes(sizes, &req)
+	if len(matching) == 0 {
+		return models.Size{}, fmt.Errorf("no size has %d vCPUs and %g GiB of memory", req.CPU, req.MemoryGiB)
+	}
+	return matching[0], nil
+}
+
+// matchingSizes returns the sizes meeting req, or all sizes if req is nil,
+// sorted from the smallest to the largest.
+func matchingSizes(sizes []models.Size, req *models.SizeRequirements) []models.Size {
+	var matching []models.Size
+	for _, size := range sizes {
+		if req == nil || req.Match(size) {
+			matching = append(matching, size)
+		}
+	}
+	sortSizes(matching)
+	return matching
+}
+
+// sortSizes sorts sizes from the smallest to the largest. Sizes of unknown
+// price come after those of the same shape with a price.
+func sortSizes(sizes []models.Size) {
+	sort.Slice(sizes, func(i, j int) bool {
+		a, b := sizes[i], sizes[j]
+		switch {
+		case a.CPUs != b.CPUs:
+			return a.CPUs < b.CPUs
+		case a.MemoryMB != b.MemoryMB:
+			return a.MemoryMB < b.MemoryMB
+		case a.HourlyPriceUSD != b.HourlyPriceUSD:
+			return b.HourlyPriceUSD == 0 || (a.HourlyPriceUSD != 0 && a.HourlyPriceUSD < b.HourlyPriceUSD)
+		}
+		return a.Name < b.Name
+	})
+}
+
+// sizeAliases inverts the customVmSizes of a mapping: native size name in
+// lowercase -> aliases.
+func sizeAliases(custom map[string]string) map[string][]string {
+	aliases := make(map[string][]string)
+	for _, alias := range sortedKeys(custom) {
+		native := strings.ToLower(custom[alias])
+		aliases[native] = append(aliases[native], alias)
+	}
+	return aliases
+}
+
+// applySize chooses the size of spec for provider p. For providers with fixed
+// sizes, requirements select the smallest matching size of spec.Region. For
+// the others a flexible size name or the requirements give the cores and
+// memory, unless spec sets them itself. The requirements are cleared once
+// they are applied.
+func applySize(ctx context.Context, p CloudProvider, spec *models.VMSpec) error {
+	req := spec.Requirements
+	lister, ok := p.(SizeLister)
+	if !ok {
+		for _, size := range flexibleSizes {
+			if strings.EqualFold(spec.Size, size.Name) {
+				req = &models.SizeRequirements{CPU: size.CPUs, MemoryGiB: float64(size.MemoryMB) / 1024}
+			}
+		}
+		if req != nil {
+			if spec.CPUs == 0 {
+				spec.CPUs = req.CPU
+			}
+			if spec.MemoryMB == 0 {
+				spec.MemoryMB = req.MemoryMB()
+			}
+		}
+		spec.Requirements = nil
+		return nil
+	}
+	if req == nil {
+		return nil
+	}
+
+	sizes, err := lister.ListSizes(ctx, spec.Region)
+	if err != nil {
+		return fmt.Errorf("failed to list sizes: %w", err)
+	}
+	size, err := SelectSize(sizes, *req)
+	if err != nil {
+		return err
+	}
+	operations.Progress(ctx, 0, "selected size %s with %d vCPUs and %d MB of memory", size.Name, size.CPUs, size.MemoryMB)
+	spec.Size = size.Name
+	spec.Requirements = nil
+	return nil
+}
+
+// ListSizes returns the sizes of the given providers, or of every registered
+// provider when names is empty, sorted by provider name. Providers with fixed
+// sizes list those of region, by default of the region new VMs are created
+// in, and are queried concurrently; failures are reported per provider.
+//
+// With req only the sizes meeting it are returned, smallest first. Providers
+// without fixed sizes then return the required cores and memory as a size
+// without name, which is what a VM with these requirements gets.
+func (cm *CloudManager) ListSizes(ctx context.Context, region string, req *models.SizeRequirements, names ...string) []models.ProviderSizes {
+	providers := cm.GetAllProviders()
+	if len(names) == 0 {
+		for name := range providers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	results := make([]models.ProviderSizes, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		result := models.ProviderSizes{Provider: name, Type: cm.ProviderType(name)}
+		lister, ok := providers[name].(SizeLister)
+		if providers[name] == nil {
+			result.Error = "provider not registered"
+			results[i] = result
+			continue
+		}
+		if !ok {
+			result.Sizes = append([]models.Size(nil), flexibleSizes...)
+			if req != nil {
+				result.Sizes = []models.Size{{CPUs: req.CPU, MemoryMB: req.MemoryMB(), Arch: req.Arch}}
+			}
+			results[i] = result
+			continue
+		}
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			pctx, cancel := cm.ReadContext(ctx, name)
+			defer cancel()
+			sizes, err := lister.ListSizes(pctx, region)
+			if err != nil {
+				fmt.Printf("Listing sizes of provider %s failed: %v\r\n", name, err)
+				result.Error = err.Error()
+			} else {
+				result.Sizes = matchingSizes(sizes, req)
+			}
+			results[i] = result
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// sizeLookupRetry is how long a failed lookup of size details is remembered,
+// so a denied or failing call isn't repeated for every VM of a list.
+var sizeLookupRetry = 5 * time.Minute
+
+// sizeCache holds details looked up by key, e.g. of sizes by
+// "<region>/<size>" or the prices of a region. Each key is fetched by one
+// caller at a time without blocking lookups of other keys.
+type sizeCache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]*sizeEntry[V]
+}
+
+type sizeEntry[V any] struct {
+	done   chan struct{} // closed once value and err are set
+	value  V
+	err    error
+	expiry time.Time // when a failed lookup is tried again
+}
+
+// get returns the cached details of key, fetching them when they aren't
+// known yet, or the error of the last fetch until it expires.
+func (c *sizeCache[V]) get(ctx context.Context, key string, fetch func() (V, error)) (value V, err error) {
+	for {
+		c.mu.Lock()
+		e, found := c.entries[key]
+		if !found {
+			e = &sizeEntry[V]{done: make(chan struct{})}
+			if c.entries == nil {
+				c.entries = make(map[string]*sizeEntry[V])
+			}
+			c.entries[key] = e
+			c.mu.Unlock()
+
+			e.value, e.err = fetch()
+			if e.err != nil && ctx.Err() == nil {
+				e.expiry = time.Now().Add(sizeLookupRetry)
+			}
+			close(e.done)
+			return e.value, e.err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return value, ctx.Err()
+		}
+		if e.err == nil || time.Now().Before(e.expiry) {
+			return e.value, e.err
+		}
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// set stores the details of key, e.g. from a list of all sizes.
+func (c *sizeCache[V]) set(key string, value V) {
+	e := &sizeEntry[V]{done: make(chan struct{}), value: value}
+	close(e.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*sizeEntry[V])
+	}
+	c.entries[key] = e
+}
@@ -0,0 +1,218 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/fuddata/anyvm/models"
+)
+
+// fakeSizeLister is a provider with fixed sizes.
+type fakeSizeLister struct {
+	CloudProvider
+	sizes []models.Size
+}
+
+func (f fakeSizeLister) ListSizes(ctx context.Context, region string) ([]models.Size, error) {
+	return f.sizes, nil
+}
+
+var testSizes = []models.Size{
+	{Name: "m7g.xlarge", CPUs: 4, MemoryMB: 16384, Arch: "arm64", HourlyPriceUSD: 0.1632},
+	{Name: "m5.xlarge", CPUs: 4, MemoryMB: 16384, Arch: "x86_64"},
+	{Name: "m6i.xlarge", CPUs: 4, MemoryMB: 16384, Arch: "x86_64", HourlyPriceUSD: 0.192},
+	{Name: "m7i.xlarge", CPUs: 4, MemoryMB: 16384, Arch: "x86_64", HourlyPriceUSD: 0.2016},
+	{Name: "r6i.large", CPUs: 2, MemoryMB: 16384, Arch: "x86_64", HourlyPriceUSD: 0.126},
+	{Name: "c6i.2xlarge", CPUs: 8, MemoryMB: 16384, Arch: "x86_64", HourlyPriceUSD: 0.34},
+	{Name: "t3.micro", CPUs: 2, MemoryMB: 1024, Arch: "x86_64", HourlyPriceUSD: 0.0104},
+}
+
+func TestSelectSize(t *testing.T) {
+	tests := []struct {
+		req  models.SizeRequirements
+		want string
+	}{
+		{models.SizeRequirements{CPU: 4, MemoryGiB: 16}, "m6i.xlarge"},
+		{models.SizeRequirements{CPU: 4, MemoryGiB: 16, Arch: "arm64"}, "m7g.xlarge"},
+		{models.SizeRequirements{CPU: 2, MemoryGiB: 8}, "r6i.large"},
+		{models.SizeRequirements{CPU: 1, MemoryGiB: 0.5}, "t3.micro"},
+		{models.SizeRequirements{CPU: 8, MemoryGiB: 15.5}, "c6i.2xlarge"},
+		{models.SizeRequirements{CPU: 16}, ""},
+	}
+	for _, tt := range tests {
+		size, err := SelectSize(testSizes, tt.req)
+		if size.Name != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("SelectSize(%+v) = %q, %v, want %q", tt.req, size.Name, err, tt.want)
+		}
+	}
+}
+
+func TestApplySize(t *testing.T) {
+	fixed := fakeSizeLister{sizes: testSizes}
+	spec := models.VMSpec{Requirements: &models.SizeRequirements{CPU: 4, MemoryGiB: 16}}
+	if err := applySize(context.Background(), fixed, &spec); err != nil || spec.Size != "m6i.xlarge" {
+		t.Errorf("fixed sizes: got size %q, %v", spec.Size, err)
+	}
+	spec = models.VMSpec{Requirements: &models.SizeRequirements{CPU: 64}}
+	if err := applySize(context.Background(), fixed, &spec); err == nil {
+		t.Error("requirements no size meets should fail")
+	}
+
+	flexible := &HyperVProvider{}
+	tests := []struct {
+		spec           models.VMSpec
+		cpus, memoryMB int64
+	}{
+		{models.VMSpec{Requirements: &models.SizeRequirements{CPU: 4, MemoryGiB: 1.5}}, 4, 1536},
+		{models.VMSpec{Size: "Medium"}, 2, 4096},
+		{models.VMSpec{Size: "large", CPUs: 6}, 6, 8192},
+		{models.VMSpec{Size: "Standard_DS2_v2"}, 0, 0},
+	}
+	for _, tt := range tests {
+		spec := tt.spec
+		if err := applySize(context.Background(), flexible, &spec); err != nil || int64(spec.CPUs) != tt.cpus || spec.MemoryMB != tt.memoryMB {
+			t.Errorf("applySize(%+v) = %d CPUs, %d MB, %v", tt.spec, spec.CPUs, spec.MemoryMB, err)
+		}
+	}
+}
+
+func TestAzureArch(t *testing.T) {
+	for name, want := range map[string]string{
+		"Standard_D4ps_v5":    "arm64",
+		"Standard_E8pds_v5":   "arm64",
+		"Standard_B2pls_v2":   "arm64",
+		"Standard_DS2_v2":     "x86_64",
+		"Standard_D4ads_v5":   "x86_64",
+		"Standard_NP10s":      "x86_64",
+		"Standard_HB120rs_v3": "x86_64",
+	} {
+		if got := azureArch(name); got != want {
+			t.Errorf("azureArch(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAzurePrices(t *testing.T) {
+	pages := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		item := func(sku, product, skuName string, price float64) map[string]interface{} {
+			return map[string]interface{}{"armSkuName": sku, "productName": product, "skuName": skuName,
+				"retailPrice": price, "unitOfMeasure": "1 Hour"}
+		}
+		if r.URL.Query().Get("page") == "" {
+			if f := r.URL.Query().Get("$filter"); f != "serviceName eq 'Virtual Machines' and armRegionName eq 'westeurope' and priceType eq 'Consumption'" {
+				t.Errorf("unexpected filter %q", f)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Items": []interface{}{
+					item("Standard_D2s_v5", "Virtual Machines Dsv5 Series", "D2s v5", 0.115),
+					item("Standard_D2s_v5", "Virtual Machines Dsv5 Series Windows", "D2s v5", 0.207),
+					item("Standard_D2s_v5", "Virtual Machines Dsv5 Series", "D2s v5 Spot", 0.023),
+				},
+				"NextPageLink": "http://" + r.Host + "/?page=2",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Items": []interface{}{item("Standard_D2ps_v5", "Virtual Machines Dpsv5 Series", "D2ps v5 Low Priority", 0.018)},
+		})
+	}))
+	defer server.Close()
+	defer func(u string) { azureRetailPricesURL = u }(azureRetailPricesURL)
+	azureRetailPricesURL = server.URL
+
+	p := &AzureProvider{}
+	for i := 0; i < 2; i++ {
+		prices := p.prices(context.Background(), "westeurope")
+		if len(prices) != 1 || prices["standard_d2s_v5"] != 0.115 {
+			t.Errorf("prices = %v", prices)
+		}
+	}
+	if pages != 2 {
+		t.Errorf("%d pages requested, want 2 and the prices cached", pages)
+	}
+
+	// Failures are remembered as well.
+	pages = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	for i := 0; i < 2; i++ {
+		if prices := p.prices(context.Background(), "northeurope"); prices != nil {
+			t.Errorf("prices = %v, want none", prices)
+		}
+	}
+	if pages != 1 {
+		t.Errorf("%d requests after a failure, want 1", pages)
+	}
+}
+
+func TestAWSOnDemandPrice(t *testing.T) {
+	var product aws.JSONValue
+	err := json.Unmarshal([]byte(`{
+		"product": {"productFamily": "Compute Instance", "attributes": {"instanceType": "m6i.xlarge", "regionCode": "eu-west-3"}},
+		"terms": {"OnDemand": {"ABC.JRTCKXETXF": {"priceDimensions": {"ABC.JRTCKXETXF.6YS6EN2CT7": {
+			"unit": "Hrs", "pricePerUnit": {"USD": "0.2240000000"}}}}}}
+	}`), &product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instanceType, price, ok := awsOnDemandPrice(product); !ok || instanceType != "m6i.xlarge" || price != 0.224 {
+		t.Errorf("awsOnDemandPrice = %q, %v, %v", instanceType, price, ok)
+	}
+	if _, _, ok := awsOnDemandPrice(aws.JSONValue{"product": "?"}); ok {
+		t.Error("product without terms should have no price")
+	}
+}
+
+func TestSizeCache(t *testing.T) {
+	var c sizeCache[int]
+	ctx := context.Background()
+
+	// A slow fetch doesn't hold up lookups of other keys.
+	release := make(chan struct{})
+	slow := make(chan int)
+	go func() {
+		v, _ := c.get(ctx, "r/slow", func() (int, error) { <-release; return 1, nil })
+		slow <- v
+	}()
+	for started := false; !started; {
+		time.Sleep(time.Millisecond)
+		c.mu.Lock()
+		started = c.entries["r/slow"] != nil
+		c.mu.Unlock()
+	}
+	if v, err := c.get(ctx, "r/fast", func() (int, error) { return 2, nil }); err != nil || v != 2 {
+		t.Errorf("fast lookup = %d, %v", v, err)
+	}
+	close(release)
+	if v := <-slow; v != 1 {
+		t.Errorf("slow lookup = %d", v)
+	}
+
+	// Failures are remembered until they expire.
+	calls := 0
+	fail := func() (int, error) { calls++; return 0, errors.New("denied") }
+	for i := 0; i < 3; i++ {
+		if _, err := c.get(ctx, "r/denied", fail); err == nil || err.Error() != "denied" {
+			t.Errorf("failed lookup returned %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("failed lookup tried %d times, want once", calls)
+	}
+	c.mu.Lock()
+	c.entries["r/denied"].expiry = time.Now()
+	c.mu.Unlock()
+	if v, err := c.get(ctx, "r/denied", func() (int, error) { return 3, nil }); err != nil || v != 3 {
+		t.Errorf("lookup after the failure expired = %d, %v", v, err)
+	}
+}
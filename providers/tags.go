@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// TagUpdater is implemented by providers whose VMs carry tags: AWS and Azure
+// tags, GCP labels, Proxmox tags, vSphere custom attributes and Hyper-V notes.
+type TagUpdater interface {
+	// UpdateTags sets the tags in set and removes the keys in remove; other
+	// tags are left as they are.
+	UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error
+}
+
+// tagRules are the restrictions a provider puts on tags.
+type tagRules struct {
+	max            int            // number of tags a VM can carry, 0 for no limit
+	maxKey         int            // length of a key in characters
+	maxValue       int            // length of a value in characters
+	key, value     *regexp.Regexp // patterns keys and values have to match, if any
+	reservedPrefix string         // prefix of keys reserved by the provider
+	keysOnly       bool           // tags have no values
+}
+
+var tagRulesByType = map[string]tagRules{
+	"aws":   {max: 50, maxKey: 128, maxValue: 256, reservedPrefix: "aws:"},
+	"azure": {max: 50, maxKey: 512, maxValue: 256, key: regexp.MustCompile(`^[^<>%&\\?/]+$`)},
+	"gcp": {max: 64, maxKey: 63, maxValue: 63,
+		key: gcpLabelPattern, value: regexp.MustCompile(`^[a-z0-9_-]*$`)},
+	"proxmox": {keysOnly: true, key: regexp.MustCompile(`^[a-z0-9_][a-z0-9_+.-]*$`)},
+	"vsphere": {maxKey: 255, maxValue: 65535},
+	"hyperv":  {maxKey: 128, maxValue: 256, key: regexp.MustCompile(`^[^=\r\n]+$`), value: regexp.MustCompile(`^[^\r\n]*$`)},
+}
+
+// ValidateTags checks tags against the restrictions of the provider type typ
+// so invalid tags are rejected before the provider is called.
+func ValidateTags(typ string, tags map[string]string) error {
+	rules, ok := tagRulesByType[typ]
+	if !ok {
+		return notSupported(typ, "tags")
+	}
+	if rules.max > 0 && len(tags) > rules.max {
+		return fmt.Errorf("%s VMs can have at most %d tags", typ, rules.max)
+	}
+	for _, key := range sortedKeys(tags) {
+		value := tags[key]
+		switch {
+		case key == "":
+			return fmt.Errorf("tag keys can't be empty")
+		case rules.maxKey > 0 && utf8.RuneCountInString(key) > rules.maxKey:
+			return fmt.Errorf("%s tag key %q is longer than %d characters", typ, key, rules.maxKey)
+		case rules.key != nil && !rules.key.MatchString(key):
+			return fmt.Errorf("%s tag key %q must match %s", typ, key, rules.key)
+		case rules.reservedPrefix != "" && strings.HasPrefix(strings.ToLower(key), rules.reservedPrefix):
+			return fmt.Errorf("%s tag keys can't start with %q", typ, rules.reservedPrefix)
+		case rules.keysOnly && value != "":
+			return fmt.Errorf("%s tags have no values, got %s=%q", typ, key, value)
+		case rules.maxValue > 0 && utf8.RuneCountInString(value) > rules.maxValue:
+			return fmt.Errorf("%s tag value of %q is longer than %d characters", typ, key, rules.maxValue)
+		case rules.value != nil && !rules.value.MatchString(value):
+			return fmt.Errorf("%s tag value of %q must match %s", typ, key, rules.value)
+		}
+	}
+	return nil
+}
+
+// mergeTags returns current with the tags in set added or changed and the
+// keys in remove deleted.
+func mergeTags(current, set map[string]string, remove []string) map[string]string {
+	tags := make(map[string]string, len(current)+len(set))
+	for k, v := range current {
+		tags[k] = v
+	}
+	for _, k := range remove {
+		delete(tags, k)
+	}
+	for k, v := range set {
+		tags[k] = v
+	}
+	return tags
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		typ  string
+		tags map[string]string
+		want string // part of the error, "" if the tags are valid
+	}{
+		{"aws", map[string]string{"Env": "Prod", "cost center": "42"}, ""},
+		{"aws", map[string]string{"aws:cloudformation:stack-name": "x"}, `can't start with "aws:"`},
+		{"aws", map[string]string{"k": strings.Repeat("v", 257)}, "longer than 256"},
+		{"azure", map[string]string{"a/b": "x"}, "must match"},
+		{"gcp", map[string]string{"env": "prod"}, ""},
+		{"gcp", map[string]string{"Env": "prod"}, "must match"},
+		{"gcp", map[string]string{"env": "Prod"}, "must match"},
+		{"proxmox", map[string]string{"web": ""}, ""},
+		{"proxmox", map[string]string{"env": "prod"}, "have no values"},
+		{"hyperv", map[string]string{"a=b": "x"}, "must match"},
+		{"vsphere", map[string]string{"": "x"}, "can't be empty"},
+		{"nutanix", map[string]string{"env": "prod"}, "not supported"},
+	}
+	for _, tt := range tests {
+		err := ValidateTags(tt.typ, tt.tags)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s %v: %v", tt.typ, tt.tags, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s %v: got error %v, want it to contain %q", tt.typ, tt.tags, err, tt.want)
+		}
+	}
+}
+
+func TestHyperVNotes(t *testing.T) {
+	notes := "Web server\r\n#tag env=prod\r\nowned by ops\r\n#tag role=web"
+	if got := hypervTags(notes); !reflect.DeepEqual(got, map[string]string{"env": "prod", "role": "web"}) {
+		t.Errorf("hypervTags = %v", got)
+	}
+	updated := hypervNotes(notes, mergeTags(hypervTags(notes), map[string]string{"env": "dev"}, []string{"role"}))
+	if want := "Web server\r\nowned by ops\r\n#tag env=dev"; updated != want {
+		t.Errorf("hypervNotes = %q, want %q", updated, want)
+	}
+	if got := hypervNotes("", nil); got != "" {
+		t.Errorf("hypervNotes without notes and tags = %q", got)
+	}
+}
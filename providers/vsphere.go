@@ -4,55 +4,465 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"os"
+	"strings"
+	"time"
 
+	"github.com/fuddata/anyvm/config"
 	"github.com/fuddata/anyvm/models"
+	"github.com/fuddata/anyvm/operations"
 
 	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/progress"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 type VSphereProvider struct {
-	client *govmomi.Client
+	client     *govmomi.Client
+	datacenter string            // datacenter new VMs are created in; empty if there is only one
+	images     map[string]string // image names -> template name or inventory path
 }
 
-func NewVSphereProvider(cfg interface{}) (*VSphereProvider, bool) {
-	// Load vSphere credentials from environment variables.
-	vsURL := os.Getenv("VSPHERE_URL")
-	username := os.Getenv("VSPHERE_USERNAME")
-	password := os.Getenv("VSPHERE_PASSWORD")
-
-	if vsURL == "" || username == "" || password == "" {
+func NewVSphereProvider(cfg *config.Config) (*VSphereProvider, bool) {
+	creds := cfg.VSphereCreds
+	if creds.URL == "" || creds.Username == "" || creds.Password == "" {
 		fmt.Printf("vSphere credentials not configured. Will continue without it.\r\n")
 		return nil, false
 	}
 
-	u, err := url.Parse(vsURL)
+	u, err := url.Parse(creds.URL)
 	if err != nil {
-		panic(err)
+		fmt.Printf("Failed to active vSphere provider. Will continue without it. Error: %v\r\n", err)
+		return nil, false
 	}
-	u.User = url.UserPassword(username, password)
+	u.User = url.UserPassword(creds.Username, creds.Password)
 
 	ctx := context.Background()
 	client, err := govmomi.NewClient(ctx, u, true)
 	if err != nil {
-		panic(err)
+		fmt.Printf("Failed to active vSphere provider. Will continue without it. Error: %v\r\n", err)
+		return nil, false
 	}
 
 	return &VSphereProvider{
-		client: client,
+		client:     client,
+		datacenter: creds.Datacenter,
+		images:     cfg.ImageAliases("vsphere"),
 	}, true
 }
 
-func (p *VSphereProvider) ListVMs() ([]models.VM, error) {
-	// Stub implementation. In production, use govmomi methods to retrieve VMs.
-	vms := []models.VM{
-		{
-			ID:       "vs-001",
-			Name:     "vSphereVM1",
-			Provider: "vsphere",
-			Region:   "datacenter1",
-			Status:   "running",
-		},
+// Close logs the session out of vCenter and drops its connections.
+func (p *VSphereProvider) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return p.client.Logout(ctx)
+}
+
+// ListVMs lists the VMs of every datacenter through container views. Region
+// is "<datacenter>/<cluster>" ("<datacenter>/<host>" for standalone hosts).
+// Templates are skipped; they are used as images for CreateVM.
+func (p *VSphereProvider) ListVMs(ctx context.Context) ([]models.VM, error) {
+	m := view.NewManager(p.client.Client)
+
+	var dcs []mo.Datacenter
+	if err := retrieveView(ctx, m, p.client.ServiceContent.RootFolder, []string{"Datacenter"}, []string{"name"}, &dcs); err != nil {
+		return nil, err
+	}
+
+	fields := p.customFieldNames(ctx)
+	var vms []models.VM
+	for _, dc := range dcs {
+		// Map each host to the name of its cluster or standalone compute resource.
+		var hosts []mo.HostSystem
+		if err := retrieveView(ctx, m, dc.Self, []string{"HostSystem"}, []string{"parent"}, &hosts); err != nil {
+			return nil, err
+		}
+		var computes []mo.ManagedEntity
+		if err := retrieveView(ctx, m, dc.Self, []string{"ComputeResource", "ClusterComputeResource"}, []string{"name"}, &computes); err != nil {
+			return nil, err
+		}
+		computeNames := make(map[types.ManagedObjectReference]string, len(computes))
+		for _, c := range computes {
+			computeNames[c.Self] = c.Name
+		}
+		hostCompute := make(map[types.ManagedObjectReference]string, len(hosts))
+		for _, h := range hosts {
+			if h.Parent != nil {
+				hostCompute[h.Self] = computeNames[*h.Parent]
+			}
+		}
+
+		var mvms []mo.VirtualMachine
+		if err := retrieveView(ctx, m, dc.Self, []string{"VirtualMachine"}, vsphereVMProperties, &mvms); err != nil {
+			return nil, err
+		}
+		for _, mvm := range mvms {
+			if mvm.Config != nil && mvm.Config.Template {
+				continue
+			}
+			vm := vsphereToModel(mvm, fields)
+			vm.Region = dc.Name
+			if host := mvm.Runtime.Host; host != nil && hostCompute[*host] != "" {
+				vm.Region += "/" + hostCompute[*host]
+			}
+			vms = append(vms, vm)
+		}
 	}
 	return vms, nil
 }
+
+// retrieveView fetches the given properties of all objects of the given types
+// below root into dst.
+func retrieveView(ctx context.Context, m *view.Manager, root types.ManagedObjectReference, kinds, props []string, dst interface{}) error {
+	v, err := m.CreateContainerView(ctx, root, kinds, true)
+	if err != nil {
+		return err
+	}
+	defer v.Destroy(context.Background())
+	return v.Retrieve(ctx, kinds, props, dst)
+}
+
+// vm returns a handle for the VM with the given managed object ID (e.g. "vm-42").
+func (p *VSphereProvider) vm(id string) *object.VirtualMachine {
+	return object.NewVirtualMachine(p.client.Client, types.ManagedObjectReference{Type: "VirtualMachine", Value: id})
+}
+
+// runTask starts a vSphere task and waits for it to complete.
+func runTask(ctx context.Context, start func(context.Context) (*object.Task, error)) error {
+	task, err := start(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = waitTask(ctx, task)
+	return err
+}
+
+// waitTask waits for a task, reporting its progress to the operation running
+// with ctx. The task is cancelled in vCenter when ctx is cancelled.
+func waitTask(ctx context.Context, task *object.Task) (*types.TaskInfo, error) {
+	sink := progress.SinkFunc(func() chan<- progress.Report {
+		ch := make(chan progress.Report)
+		go func() {
+			for r := range ch {
+				operations.Progress(ctx, int(r.Percentage()), "vSphere task %s is %.0f%% complete", task.Reference().Value, r.Percentage())
+			}
+		}()
+		return ch
+	})
+	info, err := task.WaitForResult(ctx, sink)
+	if err != nil && ctx.Err() != nil {
+		task.Cancel(context.Background())
+	}
+	return info, err
+}
+
+func (p *VSphereProvider) StartVM(ctx context.Context, id string) error {
+	return runTask(ctx, p.vm(id).PowerOn)
+}
+
+func (p *VSphereProvider) StopVM(ctx context.Context, id string, force bool) error {
+	if force {
+		return runTask(ctx, p.vm(id).PowerOff)
+	}
+	return p.vm(id).ShutdownGuest(ctx)
+}
+
+func (p *VSphereProvider) RestartVM(ctx context.Context, id string, force bool) error {
+	if force {
+		return runTask(ctx, p.vm(id).Reset)
+	}
+	return p.vm(id).RebootGuest(ctx)
+}
+
+func (p *VSphereProvider) SuspendVM(ctx context.Context, id string) error {
+	return runTask(ctx, p.vm(id).Suspend)
+}
+
+// Images returns the image names and their templates.
+func (p *VSphereProvider) Images() map[string]string {
+	return p.images
+}
+
+// ResolveImage returns the template of an image name; templates are returned
+// as they are.
+func (p *VSphereProvider) ResolveImage(ctx context.Context, image string) (string, error) {
+	return imageAlias(p.images, image), nil
+}
+
+// CreateVM clones the template (or VM) named by spec.Image, directly or
+// through an image name, into the datacenter's VM folder and powers the clone
+// on. spec.Region selects the cluster or standalone host whose root resource
+// pool the VM is placed in, either as "<datacenter>/<cluster>" like the
+// regions ListVMs reports or by its name in the configured datacenter. By
+// default the VM goes to the pool of the source VM or the datacenter's only
+// pool; CPUs and memory override the template's hardware when set.
+func (p *VSphereProvider) CreateVM(ctx context.Context, spec models.VMSpec) (*models.VM, error) {
+	if spec.Image == "" {
+		return nil, fmt.Errorf("vSphere VM creation requires a template name in image")
+	}
+
+	datacenter, compute := p.datacenter, spec.Region
+	if dcName, name, ok := strings.Cut(spec.Region, "/"); ok {
+		datacenter, compute = dcName, name
+	}
+	finder := find.NewFinder(p.client.Client, true)
+	dc, err := finder.DatacenterOrDefault(ctx, datacenter)
+	if err != nil {
+		return nil, err
+	}
+	finder.SetDatacenter(dc)
+
+	template, err := finder.VirtualMachine(ctx, imageAlias(p.images, spec.Image))
+	if err != nil {
+		return nil, err
+	}
+	var pool *object.ResourcePool
+	if compute != "" {
+		cr, err := finder.ComputeResource(ctx, compute)
+		if err != nil {
+			return nil, err
+		}
+		pool, err = cr.ResourcePool(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else if pool, err = template.ResourcePool(ctx); err != nil {
+		// Templates don't belong to a resource pool.
+		pool, err = finder.DefaultResourcePool(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	folders, err := dc.Folders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	poolRef := pool.Reference()
+	cloneSpec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{Pool: &poolRef},
+		PowerOn:  true,
+	}
+	if spec.CPUs > 0 || spec.MemoryMB > 0 {
+		cloneSpec.Config = &types.VirtualMachineConfigSpec{
+			NumCPUs:  int32(spec.CPUs),
+			MemoryMB: spec.MemoryMB,
+		}
+	}
+
+	task, err := template.Clone(ctx, folders.VmFolder, spec.Name, cloneSpec)
+	if err != nil {
+		return nil, err
+	}
+	info, err := waitTask(ctx, task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone %s: %w", spec.Image, err)
+	}
+	ref, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return nil, fmt.Errorf("clone task returned no VM")
+	}
+	if len(spec.Tags) > 0 {
+		if err := p.UpdateTags(ctx, ref.Value, spec.Tags, nil); err != nil {
+			return nil, fmt.Errorf("VM %s created but its custom attributes could not be set: %w", ref.Value, err)
+		}
+	}
+	return p.GetVM(ctx, ref.Value)
+}
+
+// UpdateTags sets the VM's custom attributes. Attributes that don't exist
+// yet are defined for VMs; removing an attribute clears its value.
+func (p *VSphereProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	m, err := object.GetCustomFieldsManager(p.client.Client)
+	if err != nil {
+		return err
+	}
+	ref := p.vm(id).Reference()
+	for _, name := range remove {
+		key, err := m.FindKey(ctx, name)
+		if err == object.ErrKeyNameNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := m.Set(ctx, ref, key, ""); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(set) {
+		key, err := m.FindKey(ctx, name)
+		if err == object.ErrKeyNameNotFound {
+			var def *types.CustomFieldDef
+			def, err = m.Add(ctx, name, "VirtualMachine", nil, nil)
+			if def != nil {
+				key = def.Key
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("custom attribute %q: %w", name, err)
+		}
+		if err := m.Set(ctx, ref, key, set[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// customFieldNames maps the keys of the custom attributes to their names. It
+// returns nil for hosts that aren't managed by vCenter.
+func (p *VSphereProvider) customFieldNames(ctx context.Context) map[int32]string {
+	m, err := object.GetCustomFieldsManager(p.client.Client)
+	if err != nil {
+		return nil
+	}
+	fields, err := m.Field(ctx)
+	if err != nil {
+		return nil
+	}
+	names := make(map[int32]string, len(fields))
+	for _, f := range fields {
+		names[f.Key] = f.Name
+	}
+	return names
+}
+
+// DeleteVM destroys the VM. With cascade its disk files are deleted with it;
+// without, the disks are detached first so their files stay on the datastore.
+func (p *VSphereProvider) DeleteVM(ctx context.Context, id string, cascade bool) ([]models.DeletedResource, error) {
+	vm := p.vm(id)
+
+	var disks object.VirtualDeviceList
+	if devices, err := vm.Device(ctx); err == nil {
+		disks = devices.SelectByType((*types.VirtualDisk)(nil))
+	}
+
+	if state, err := vm.PowerState(ctx); err == nil && state != types.VirtualMachinePowerStatePoweredOff {
+		if err := runTask(ctx, vm.PowerOff); err != nil {
+			return nil, fmt.Errorf("failed to power off VM before deletion: %w", err)
+		}
+	}
+
+	if !cascade && len(disks) > 0 {
+		if err := vm.RemoveDevice(ctx, true, disks...); err != nil {
+			err = fmt.Errorf("failed to detach disks before deletion: %w", err)
+			return []models.DeletedResource{deletedResource("vm", id, err)}, err
+		}
+	}
+	if err := runTask(ctx, vm.Destroy); err != nil {
+		return []models.DeletedResource{deletedResource("vm", id, err)}, err
+	}
+	results := []models.DeletedResource{deletedResource("vm", id, nil)}
+	if cascade {
+		for _, disk := range disks {
+			if backing, ok := disk.GetVirtualDevice().Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+				results = append(results, deletedResource("disk", backing.GetVirtualDeviceFileBackingInfo().FileName, nil))
+			}
+		}
+	}
+	return results, nil
+}
+
+// vsphereVMProperties are the properties fetched for each VM.
+var vsphereVMProperties = []string{"name", "config", "runtime", "guest", "customValue"}
+
+func (p *VSphereProvider) GetVM(ctx context.Context, id string) (*models.VM, error) {
+	var mvm mo.VirtualMachine
+	if err := p.client.RetrieveOne(ctx, p.vm(id).Reference(), vsphereVMProperties, &mvm); err != nil {
+		return nil, err
+	}
+	vm := vsphereToModel(mvm, p.customFieldNames(ctx))
+	if host := mvm.Runtime.Host; host != nil {
+		vm.Region = p.hostLocation(ctx, *host)
+	}
+	return &vm, nil
+}
+
+// hostLocation returns "<datacenter>/<cluster>" for a host, matching the
+// region reported by ListVMs.
+func (p *VSphereProvider) hostLocation(ctx context.Context, host types.ManagedObjectReference) string {
+	entities, err := mo.Ancestors(ctx, p.client.Client, p.client.ServiceContent.PropertyCollector, host)
+	if err != nil {
+		return ""
+	}
+	var datacenter, compute string
+	for _, e := range entities {
+		switch e.Self.Type {
+		case "Datacenter":
+			datacenter = e.Name
+		case "ComputeResource", "ClusterComputeResource":
+			compute = e.Name
+		}
+	}
+	if compute == "" {
+		return datacenter
+	}
+	return datacenter + "/" + compute
+}
+
+// vsphereToModel converts VM properties to the unified model, naming custom
+// attributes through fields. Region is left for the caller to fill in.
+func vsphereToModel(mvm mo.VirtualMachine, fields map[int32]string) models.VM {
+	vm := models.VM{
+		ID:        mvm.Self.Value,
+		Name:      mvm.Name,
+		Provider:  "vsphere",
+		Status:    vsphereStatus(mvm.Runtime.PowerState),
+		RawStatus: string(mvm.Runtime.PowerState),
+	}
+	if cfg := mvm.Config; cfg != nil {
+		vm.CPUs = int(cfg.Hardware.NumCPU)
+		vm.MemoryMB = int64(cfg.Hardware.MemoryMB)
+		vm.CreatedAt = cfg.CreateDate
+		if strings.Contains(strings.ToLower(cfg.GuestId), "win") {
+			vm.OSType = "windows"
+		} else if cfg.GuestId != "" {
+			vm.OSType = "linux"
+		}
+		for i, dev := range object.VirtualDeviceList(cfg.Hardware.Device).SelectByType((*types.VirtualDisk)(nil)) {
+			disk := dev.(*types.VirtualDisk)
+			d := models.Disk{SizeGB: disk.CapacityInKB / (1 << 20), Boot: i == 0}
+			if info := disk.DeviceInfo; info != nil {
+				d.Name = info.GetDescription().Label
+			}
+			if backing, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+				d.ID = backing.GetVirtualDeviceFileBackingInfo().FileName
+			}
+			vm.Disks = append(vm.Disks, d)
+		}
+	}
+	if guest := mvm.Guest; guest != nil {
+		for _, nic := range guest.Net {
+			vm.PrivateIPs = append(vm.PrivateIPs, nic.IpAddress...)
+		}
+		if len(vm.PrivateIPs) == 0 && guest.IpAddress != "" {
+			vm.PrivateIPs = []string{guest.IpAddress}
+		}
+	}
+	// Custom attributes are tags; vSphere reports unset ones with an empty value.
+	for _, cv := range mvm.CustomValue {
+		if v, ok := cv.(*types.CustomFieldStringValue); ok && v.Value != "" && fields[v.Key] != "" {
+			if vm.Tags == nil {
+				vm.Tags = make(map[string]string)
+			}
+			vm.Tags[fields[v.Key]] = v.Value
+		}
+	}
+	return vm
+}
+
+// vsphereStatus maps a vSphere power state to the normalized status.
+func vsphereStatus(state types.VirtualMachinePowerState) models.VMStatus {
+	switch state {
+	case types.VirtualMachinePowerStatePoweredOn:
+		return models.StatusRunning
+	case types.VirtualMachinePowerStatePoweredOff:
+		return models.StatusStopped
+	case types.VirtualMachinePowerStateSuspended:
+		return models.StatusSuspended
+	}
+	return models.StatusUnknown
+}
@@ -0,0 +1,260 @@
+package providers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fuddata/anyvm/models"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// vcsimTest runs f against a simulated vCenter with one datacenter (DC0), a
+// cluster (DC0_C0), a standalone host (DC0_H0) and two VMs on each.
+func vcsimTest(t *testing.T, f func(ctx context.Context, p *VSphereProvider)) {
+	t.Helper()
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+		f(ctx, &VSphereProvider{client: &govmomi.Client{Client: c, SessionManager: session.NewManager(c)}})
+	})
+}
+
+// vsphereVMID returns the managed object ID of the VM with the given name.
+func vsphereVMID(ctx context.Context, t *testing.T, p *VSphereProvider, name string) string {
+	t.Helper()
+	vm, err := find.NewFinder(p.client.Client).VirtualMachine(ctx, name)
+	if err != nil {
+		t.Fatalf("finding %s: %v", name, err)
+	}
+	return vm.Reference().Value
+}
+
+func TestVSphereListVMs(t *testing.T) {
+	vcsimTest(t, func(ctx context.Context, p *VSphereProvider) {
+		vms, err := p.ListVMs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		regions := make(map[string]string)
+		for _, vm := range vms {
+			if vm.Provider != "vsphere" || vm.ID == "" {
+				t.Errorf("VM %+v lacks provider or ID", vm)
+			}
+			if vm.Status != models.StatusRunning {
+				t.Errorf("VM %s is %s, want running", vm.Name, vm.Status)
+			}
+			regions[vm.Name] = vm.Region
+		}
+		want := map[string]string{
+			"DC0_H0_VM0":     "DC0/DC0_H0",
+			"DC0_H0_VM1":     "DC0/DC0_H0",
+			"DC0_C0_RP0_VM0": "DC0/DC0_C0",
+			"DC0_C0_RP0_VM1": "DC0/DC0_C0",
+		}
+		for name, region := range want {
+			if regions[name] != region {
+				t.Errorf("region of %s = %q, want %q", name, regions[name], region)
+			}
+		}
+		if len(vms) != len(want) {
+			t.Errorf("got %d VMs, want %d", len(vms), len(want))
+		}
+	})
+}
+
+func TestVSpherePowerActions(t *testing.T) {
+	vcsimTest(t, func(ctx context.Context, p *VSphereProvider) {
+		id := vsphereVMID(ctx, t, p, "DC0_H0_VM0")
+		status := func() models.VMStatus {
+			t.Helper()
+			vm, err := p.GetVM(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return vm.Status
+		}
+
+		steps := []struct {
+			name   string
+			action func() error
+			want   models.VMStatus
+		}{
+			{"stop", func() error { return p.StopVM(ctx, id, true) }, models.StatusStopped},
+			{"start", func() error { return p.StartVM(ctx, id) }, models.StatusRunning},
+			{"restart", func() error { return p.RestartVM(ctx, id, true) }, models.StatusRunning},
+			{"suspend", func() error { return p.SuspendVM(ctx, id) }, models.StatusSuspended},
+			{"resume", func() error { return p.StartVM(ctx, id) }, models.StatusRunning},
+		}
+		for _, step := range steps {
+			if err := step.action(); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if got := status(); got != step.want {
+				t.Fatalf("after %s the VM is %s, want %s", step.name, got, step.want)
+			}
+		}
+	})
+}
+
+func TestVSphereCreateVM(t *testing.T) {
+	vcsimTest(t, func(ctx context.Context, p *VSphereProvider) {
+		vm, err := p.CreateVM(ctx, models.VMSpec{
+			Name:     "web01",
+			Image:    "DC0_C0_RP0_VM0",
+			Region:   "DC0_C0",
+			CPUs:     4,
+			MemoryMB: 8192,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm.Name != "web01" || vm.Region != "DC0/DC0_C0" {
+			t.Errorf("created %s in %q, want web01 in DC0/DC0_C0", vm.Name, vm.Region)
+		}
+		// vcsim neither powers clones on nor applies CPUs and memory, so those
+		// aren't checked here.
+
+		clone, err := p.CreateVM(ctx, models.VMSpec{Name: "web02", Image: "DC0_H0_VM0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clone.Region != "DC0/DC0_H0" {
+			t.Errorf("clone without region is in %q, want the template's host DC0/DC0_H0", clone.Region)
+		}
+
+		// The region of a listed VM, "<datacenter>/<cluster or host>", is
+		// accepted as well.
+		vms, err := p.ListVMs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, listed := range vms {
+			if listed.Name != "DC0_H0_VM1" && listed.Name != "DC0_C0_RP0_VM1" {
+				continue
+			}
+			vm, err := p.CreateVM(ctx, models.VMSpec{Name: listed.Name + "_clone", Image: listed.Name, Region: listed.Region})
+			if err != nil {
+				t.Fatalf("creating in region %q of %s: %v", listed.Region, listed.Name, err)
+			}
+			if vm.Region != listed.Region {
+				t.Errorf("created in %q, want %q", vm.Region, listed.Region)
+			}
+		}
+		if _, err := p.CreateVM(ctx, models.VMSpec{Name: "web03", Image: "DC0_H0_VM0", Region: "DC1/DC0_C0"}); err == nil {
+			t.Error("creating in a missing datacenter succeeded")
+		}
+
+		if _, err := p.CreateVM(ctx, models.VMSpec{Name: "web03"}); err == nil {
+			t.Error("creating without a template succeeded")
+		}
+		if _, err := p.CreateVM(ctx, models.VMSpec{Name: "web03", Image: "missing"}); err == nil {
+			t.Error("cloning a missing template succeeded")
+		}
+	})
+}
+
+func TestVSphereDeleteVM(t *testing.T) {
+	vcsimTest(t, func(ctx context.Context, p *VSphereProvider) {
+		id := vsphereVMID(ctx, t, p, "DC0_H0_VM0")
+		results, err := p.DeleteVM(ctx, id, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kinds []string
+		for _, r := range results {
+			if !r.Deleted {
+				t.Errorf("%s %s wasn't deleted: %s", r.Type, r.ID, r.Error)
+			}
+			if r.Type == "disk" && !strings.HasSuffix(r.ID, ".vmdk") {
+				t.Errorf("disk %q isn't a vmdk file", r.ID)
+			}
+			kinds = append(kinds, r.Type)
+		}
+		sort.Strings(kinds)
+		if strings.Join(kinds, ",") != "disk,vm" {
+			t.Errorf("cascade delete removed %v, want the VM and its disk", kinds)
+		}
+		if _, err := p.GetVM(ctx, id); err == nil {
+			t.Error("deleted VM still exists")
+		}
+
+		// Without cascade the disks are detached, keeping their files, before
+		// the VM is destroyed. vcsim deletes the whole VM folder on destroy, so
+		// the kept files can't be checked on the datastore.
+		recorder := &detachRecorder{RoundTripper: p.client.Client.RoundTripper}
+		p.client.Client.RoundTripper = recorder
+		id = vsphereVMID(ctx, t, p, "DC0_H0_VM1")
+		results, err = p.DeleteVM(ctx, id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Type != "vm" || !results[0].Deleted {
+			t.Errorf("delete without cascade returned %+v", results)
+		}
+		if got := strings.Join(recorder.calls, ","); got != "detach,destroy" {
+			t.Errorf("delete without cascade made the calls %s, want detach,destroy", got)
+		}
+		if _, err := p.GetVM(ctx, id); err == nil {
+			t.Error("deleted VM still exists")
+		}
+	})
+}
+
+// detachRecorder records the disks detached from VMs without deleting their
+// files and the VMs destroyed, in order.
+type detachRecorder struct {
+	soap.RoundTripper
+	calls []string
+}
+
+func (r *detachRecorder) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	switch body := req.(type) {
+	case *methods.ReconfigVM_TaskBody:
+		for _, change := range body.Req.Spec.DeviceChange {
+			spec := change.GetVirtualDeviceConfigSpec()
+			if _, disk := spec.Device.(*types.VirtualDisk); disk && spec.Operation == types.VirtualDeviceConfigSpecOperationRemove && spec.FileOperation == "" {
+				r.calls = append(r.calls, "detach")
+			}
+		}
+	case *methods.Destroy_TaskBody:
+		r.calls = append(r.calls, "destroy")
+	}
+	return r.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+func TestVSphereUpdateTags(t *testing.T) {
+	vcsimTest(t, func(ctx context.Context, p *VSphereProvider) {
+		id := vsphereVMID(ctx, t, p, "DC0_H0_VM0")
+		if err := p.UpdateTags(ctx, id, map[string]string{"env": "prod", "owner": "ops"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.UpdateTags(ctx, id, map[string]string{"env": "dev"}, []string{"owner", "missing"}); err != nil {
+			t.Fatal(err)
+		}
+		vm, err := p.GetVM(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vm.Tags) != 1 || vm.Tags["env"] != "dev" {
+			t.Errorf("tags = %v, want env=dev", vm.Tags)
+		}
+
+		vms, err := p.ListVMs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, listed := range vms {
+			if listed.ID == id && listed.Tags["env"] != "dev" {
+				t.Errorf("listed tags = %v, want env=dev", listed.Tags)
+			}
+		}
+	})
+}